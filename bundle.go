@@ -1,22 +1,68 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
-	"time"
+
+	"BundleTools/bundle"
 )
 
-// FileEntry represents an entry in the file table
-type FileEntry struct {
-	Index  int    // Index of the file in the table
-	Offset uint32 // Offset of the file in the bundle
-	Length uint32 // Length of the file data
-	Name   string // Name of the file
+// openBundle opens a bundle file and reads its table with the detected layout and seed, the caller must close the returned file
+func openBundle(bundlePath string) (*os.File, *bundle.Reader, error) {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open %s: %w", bundlePath, err)
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("unable to stat %s: %w", bundlePath, err)
+	}
+
+	// Other releases and games encrypt the table with another seed or use another layout
+	layout, seed, err := detectLayout(file, fileInfo.Size())
+	if errors.Is(err, bundle.ErrNoSeed) {
+		// A damaged bundle may still be readable with the default layout, as before detection existed
+		layout, seed = defaultLayout(), 0
+		fmt.Fprintf(os.Stderr, "Warning: %v, reading %s with the %s layout and seed 0\n", err, bundlePath, layout.Name)
+	} else if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("error getting table data: %w", err)
+	} else if layout != bundle.Daybreak || seed != 0 {
+		// Not on stdout, where -json and -dot write their output
+		fmt.Fprintf(os.Stderr, "Detected %s layout with table seed %d in %s\n", layout.Name, seed, bundlePath)
+	}
+
+	reader, err := bundle.OpenLayout(file, fileInfo.Size(), layout, seed)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("error getting table data: %w", err)
+	}
+
+	return file, reader, nil
+}
+
+// readEntry reads and decrypts the whole data of an entry
+func readEntry(reader *bundle.Reader, entry *bundle.Entry) ([]byte, error) {
+	entryReader, err := reader.Open(entry)
+	if err != nil {
+		return nil, err
+	}
+	defer entryReader.Close()
+
+	data, err := io.ReadAll(entryReader)
+	if err != nil {
+		return nil, fmt.Errorf("error extracting %s from bundle: %w", entry.Name, err)
+	}
+	return data, nil
 }
 
 // listBundle reads a bundle file and prints the table data
@@ -25,18 +71,13 @@ func listBundle(bundlePath string) error {
 		return fmt.Errorf("%s does not exist", bundlePath)
 	}
 
-	file, err := os.Open(bundlePath)
+	file, reader, err := openBundle(bundlePath)
 	if err != nil {
-		return fmt.Errorf("unable to open %s: %w", bundlePath, err)
+		return err
 	}
 	defer file.Close()
 
-	_, fileEntries, err := getTableData(file)
-	if err != nil {
-		return fmt.Errorf("error getting table data: %w", err)
-	}
-
-	for _, entry := range fileEntries {
+	for _, entry := range reader.Entries() {
 		fmt.Printf("   index: %d, offset: %d, length: %d, name: %s\n",
 			entry.Index, entry.Offset, entry.Length, entry.Name)
 	}
@@ -44,7 +85,8 @@ func listBundle(bundlePath string) error {
 	return nil
 }
 
-func extractBundle(bundlePath, extractPath, pattern string) error {
+// extractBundle extracts the entries matching pattern to extractPath, converting them as options select
+func extractBundle(bundlePath, extractPath, pattern string, options conversionOptions) error {
 	if _, err := os.Stat(bundlePath); os.IsNotExist(err) {
 		return fmt.Errorf("%s does not exist", bundlePath)
 	}
@@ -54,374 +96,186 @@ func extractBundle(bundlePath, extractPath, pattern string) error {
 		return fmt.Errorf("error creating extraction directory %s: %w", extractPath, err)
 	}
 
-	file, err := os.Open(bundlePath)
+	file, reader, err := openBundle(bundlePath)
 	if err != nil {
-		return fmt.Errorf("unable to open %s: %w", bundlePath, err)
+		return err
 	}
 	defer file.Close()
 
-	_, fileEntries, err := getTableData(file) // First return value (fileMap) is unused
-	if err != nil {
-		return fmt.Errorf("failed to get table data: %w", err)
-	}
-
 	regex, err := regexp.Compile(pattern)
 	if err != nil {
 		return fmt.Errorf("invalid pattern: %w", err)
 	}
 
-	for _, entry := range fileEntries {
+	manifest := &extractManifest{
+		Bundle:     bundlePath,
+		EntryCount: len(reader.Entries()),
+		Layout:     reader.Layout().Name,
+		Seed:       reader.Seed(),
+	}
+
+	for _, entry := range reader.Entries() {
 		if !regex.MatchString(entry.Name) {
 			continue
 		}
 
 		fmt.Printf("  %+v\n", entry)
 
-		// Move the file cursor to the correct position
-		if _, err = file.Seek(int64(entry.Offset), 0); err != nil {
-			return fmt.Errorf("error seeking in bundle: %w", err)
-		}
-
-		// Read data from the file
-		fileData := make([]byte, entry.Length)
-		bytesRead, err := file.Read(fileData)
-		if err != nil {
-			return fmt.Errorf("error extracting from bundle: %w", err)
-		}
-		if bytesRead != int(entry.Length) {
-			return fmt.Errorf("expected to read %d bytes, but got %d", entry.Length, bytesRead)
-		}
-
-		encryptionKey := getFileKey(int64(entry.Offset))
-		var decryptedData []byte
-		for i := range int(entry.Length) {
-			decryptedData = append(decryptedData, fileData[i]^byte(encryptionKey))
-		}
-
 		outputPath := extractPath + string(os.PathSeparator) + entry.Name
-		// Create directories as needed for the output path
-		dirPath := filepath.Dir(outputPath)
-		if err = os.MkdirAll(dirPath, os.ModePerm); err != nil {
-			return fmt.Errorf("error creating directory for %s: %w", outputPath, err)
-		}
-
-		if entry.Name[len(entry.Name)-4:] == ".cnv" {
-			dataKey := decryptedData[0]
-
-			if dataKey == 1 {
-				// Add panic recovery for WAV conversion
-				func() {
-					defer func() {
-						if r := recover(); r != nil {
-							fmt.Printf("WAV conversion panicked for %s: %v\n", entry.Name, r)
-							err = fmt.Errorf("WAV conversion panicked: %v", r)
-						}
-					}()
-					err = convertWav(&decryptedData)
-				}()
-
-				if err != nil {
-					fmt.Printf("Error converting WAV for %s: %v, saving as .unknown\n", entry.Name, err)
-					outputPath = outputPath[:len(outputPath)-4] + ".unknown"
-				} else {
-					outputPath = outputPath[:len(outputPath)-4] + ".wav"
-				}
-			} else if dataKey == 24 || dataKey == 32 {
-				err = convertImage(&decryptedData)
-				if err != nil {
-					return fmt.Errorf("error converting image: %w", err)
-				}
-				outputPath = outputPath[:len(outputPath)-4] + ".bmp"
-			} else {
-				fmt.Printf("Bad data key (%d) in %s, saving as .unknown\n", dataKey, outputPath)
-				outputPath = outputPath[:len(outputPath)-4] + ".unknown"
-			}
+		extracted, err := extractEntry(reader, entry, outputPath, options)
+		if err != nil {
+			return err
 		}
 
-		// Write the converted data to a new file
-		err = os.WriteFile(outputPath, decryptedData, 0644)
+		manifestEntry, err := newManifestEntry(entry, extractPath, extracted)
 		if err != nil {
-			return fmt.Errorf("unable to write %s: %w", outputPath, err)
+			return err
 		}
+		manifest.Entries = append(manifest.Entries, manifestEntry)
 	}
-	return nil
+
+	// Record how every entry was exported so it can be mapped back exactly
+	return writeManifest(extractPath, manifest)
 }
 
+// patchBundle replaces every entry matching a file of the source folder and
+// writes the whole bundle again in one pass, so replacements may be larger than the originals
 func patchBundle(datFilePath string, outputPath string) {
-	// Create a backup filename by adding a timestamp
-	timeStamp := time.Now().Format("20060102-150405")
-	backupFileName := fmt.Sprintf("%s.%s.bak", datFilePath, timeStamp)
-
-	// First, create a copy of the original DAT file
-	sourceData, err := os.ReadFile(datFilePath)
+	file, reader, err := openBundle(datFilePath)
 	if err != nil {
-		log.Fatalf("Unable to read source DAT file %s: %v", datFilePath, err)
+		log.Fatalf("Unable to get table data: %v", err)
 	}
+	fileEntries := reader.Entries()
 
-	// Create a patched copy
-	patchedFileName := fmt.Sprintf("%s.patched", datFilePath)
-	err = os.WriteFile(patchedFileName, sourceData, 0644)
+	manifest, err := readManifest(outputPath)
 	if err != nil {
-		log.Fatalf("Unable to create patched file %s: %v", patchedFileName, err)
+		log.Fatalf("Unable to read manifest: %v", err)
 	}
 
-	// Keep a backup of the original file
-	err = os.WriteFile(backupFileName, sourceData, 0644)
+	// Resolve every source file to its entry before touching the bundle
+	updateFiles, err := collectUpdateFiles(outputPath, newEntryResolver(fileEntries, manifest))
 	if err != nil {
-		log.Printf("Warning: Unable to create backup file %s: %v", backupFileName, err)
-	} else {
-		fmt.Printf("Created backup of original file: %s\n", backupFileName)
+		log.Fatalf("Unable to collect source files: %v", err)
 	}
 
-	// Open the patched file for modifications
-	fileInfo, err := os.Stat(patchedFileName)
-	if err != nil {
-		log.Fatal(err)
-	}
+	items := keepEntries(fileEntries)
+	replaced := 0
+	for _, updateFile := range updateFiles {
+		entry := updateFile.entry
+		data, err := loadReplacementData(updateFile.sourcePath, entry, reader)
+		if err != nil {
+			fmt.Printf("Error patching %s: %v\n", updateFile.sourcePath, err)
+			continue
+		}
 
-	// Get the last modified time of the file
-	modificationTime := time.Since(fileInfo.ModTime()).Hours() / 24
-	outputFile, err := os.OpenFile(patchedFileName, os.O_RDWR, 0644)
-	if err != nil {
-		log.Fatalf("Unable to open %s for writing: %v", patchedFileName, err)
+		fmt.Printf("Updating index: %d, name: %s, length: %d -> %d\n", entry.Index, entry.Name, entry.Length, len(data))
+		items[entry.Index] = &rewriteItem{name: entry.Name, data: data}
+		replaced++
 	}
-	defer outputFile.Close()
+	// The original entries were only needed as templates of converted images
+	file.Close()
 
-	_, fileEntries, err := getTableData(outputFile)
-	if err != nil {
-		log.Fatalf("Unable to get table data: %v", err)
+	if replaced == 0 {
+		fmt.Printf("Nothing to update in %s\n", datFilePath)
+		return
 	}
-	// Use the improved revisedRecursivePatchDir function which uses index-based lookups
-	recursivePatchDir(outputFile, outputPath, "", fileEntries, modificationTime)
 
-	// After patching is complete, replace the original file with the patched one
-	outputFile.Close() // Ensure file is closed before replacing
-
-	err = os.Rename(patchedFileName, datFilePath)
-	if err != nil {
-		log.Fatalf("Unable to replace original file with patched version: %v", err)
+	if err := rewriteBundle(datFilePath, items); err != nil {
+		log.Fatalf("Unable to patch %s: %v", datFilePath, err)
 	}
 
-	fmt.Printf("Successfully patched %s (original backed up as %s)\n", datFilePath, backupFileName)
+	fmt.Printf("Successfully patched %d entries in %s\n", replaced, datFilePath)
 }
 
-// matchFileToIndex tries to match a file to an index in the DAT file
-func matchFileToIndex(filePath string, fileEntries []*FileEntry) (int, error) {
-	// Get base name and extension
-	fileName := filepath.Base(filePath)
-	ext := filepath.Ext(fileName)
-	nameWithoutExt := strings.TrimSuffix(fileName, ext)
-
-	// Convert to lowercase for case-insensitive comparison
-	nameLC := strings.ToLower(nameWithoutExt) // We're just extracting the filename, we don't need the directory path
-	// for matching against file entries
-	_ = filepath.Dir(filePath) // Just to avoid compiler warning about unused variable
-
-	// Hardcoded mappings for known files
-	// These are specific to the daybreak05.dat structure
-	if nameLC == "title" && (ext == ".ogg" || ext == ".wav") {
-		return 6, nil // Known index for title.ogg
-	}
-
-	if nameLC == "titlesemi" && (ext == ".ogg" || ext == ".wav") {
-		return 4, nil // Known index for titlesemi.ogg
-	}
+var (
+	// errNoMatchingEntry is returned when a file does not correspond to any entry
+	errNoMatchingEntry = errors.New("no matching entry")
+	// errAmbiguousEntry is returned when a file could correspond to several entries
+	errAmbiguousEntry = errors.New("ambiguous match")
+)
 
-	if nameLC == "title" && ext == ".sfl" {
-		return 5, nil // Known index for title.sfl
-	}
+// entryResolver maps files of a source folder back to the entries of a bundle.
+// Relative paths are compared case and separator insensitively and converted files, like
+// .bmp or .wav files, also match the entry they were extracted from, like a .cnv entry.
+// When the folder has an extraction manifest, its recorded output paths are used first.
+type entryResolver struct {
+	fileEntries []*bundle.Entry
+	byPath      map[string][]*bundle.Entry
+	manifest    map[string]*manifestEntry
+}
 
-	if nameLC == "titlesemi" && ext == ".sfl" {
-		return 3, nil // Known index for titlesemi.sfl
+// newEntryResolver indexes the entries of a bundle, manifest may be nil
+func newEntryResolver(fileEntries []*bundle.Entry, manifest *extractManifest) *entryResolver {
+	resolver := &entryResolver{
+		fileEntries: fileEntries,
+		byPath:      make(map[string][]*bundle.Entry, len(fileEntries)),
 	}
-	// If we don't have a specific mapping, try to find a matching entry
-	for i, entry := range fileEntries {
-		entryName := strings.ToLower(entry.Name) // Try to match by filename
-		if strings.Contains(entryName, nameLC) { // If we find a match on the name, check for extension match
-			if (ext == ".ogg" && strings.HasSuffix(entryName, ".ogg")) ||
-				(ext == ".sfl" && strings.HasSuffix(entryName, ".sfl")) ||
-				(ext == ".bmp" && strings.HasSuffix(entryName, ".cnv")) {
-				return i, nil
-			}
-		}
+	for _, entry := range fileEntries {
+		key := normalizeEntryPath(entry.Name)
+		resolver.byPath[key] = append(resolver.byPath[key], entry)
 	}
 
-	return -1, fmt.Errorf("could not find a matching entry for %s", filePath)
-}
-
-// patchFileByIndex is the improved patchFile function that uses indices rather than names
-func patchFileByIndex(outputFile *os.File, inputFileName string, fileEntries []*FileEntry, targetIndex int) error {
-	if targetIndex < 0 || targetIndex >= len(fileEntries) {
-		return fmt.Errorf("invalid file index: %d (max: %d)", targetIndex, len(fileEntries)-1)
-	}
-	// Get the target entry
-	fileEntry := fileEntries[targetIndex] // Read and process the input file
-	var fileData []byte
-	var err error
-
-	// Check if this is a BMP file being patched to a CNV file
-	if strings.HasSuffix(strings.ToLower(fileEntry.Name), ".cnv") {
-		ext := strings.ToLower(filepath.Ext(inputFileName))
-		if ext == ".bmp" {
-			// Convert the image back to CNV format
-			fmt.Printf("Converting %s back to CNV format...\n", filepath.Base(inputFileName))
-			convertedData, err := convertImageToCnv(inputFileName)
-			if err != nil {
-				return fmt.Errorf("error converting image to CNV: %w", err)
-			}
-			fileData = convertedData
-			fmt.Printf("Successfully converted %s to CNV format (%d bytes)\n",
-				filepath.Base(inputFileName), len(fileData))
-		} else {
-			// For non-image files, read directly
-			fileData, err = os.ReadFile(inputFileName)
-			if err != nil {
-				return fmt.Errorf("error reading input file: %v", err)
-			}
-		}
-	} else {
-		// For non-CNV files, read directly
-		fileData, err = os.ReadFile(inputFileName)
-		if err != nil {
-			return fmt.Errorf("error reading input file: %v", err)
+	if manifest != nil {
+		resolver.manifest = make(map[string]*manifestEntry, len(manifest.Entries))
+		for _, manifestEntry := range manifest.Entries {
+			resolver.manifest[normalizeEntryPath(manifestEntry.OutputPath)] = manifestEntry
 		}
 	}
+	return resolver
+}
 
-	dataLength := len(fileData)
+// normalizeEntryPath returns the lowercase, backslash separated form of a path used for matching
+func normalizeEntryPath(path string) string {
+	return strings.ToLower(strings.ReplaceAll(filepath.ToSlash(path), "/", `\`))
+}
 
-	fmt.Printf("Updating file at index: %d, name: %s\n", targetIndex, fileEntry.Name)
-	fmt.Printf("File details - offset: %d, length: %d\n", fileEntry.Offset, fileEntry.Length)
+// resolve returns the entry a file replaces from its path relative to the source folder
+func (r *entryResolver) resolve(relPath string) (*bundle.Entry, error) {
+	key := normalizeEntryPath(relPath)
 
-	// Check if the file will fit in the DAT entry
-	if dataLength > int(fileEntry.Length) {
-		return fmt.Errorf("input file is too large (%d bytes) to fit in entry (%d bytes)",
-			dataLength, fileEntry.Length)
-	}
-
-	// Seek to the appropriate position in the DAT file
-	_, err = outputFile.Seek(int64(fileEntry.Offset), io.SeekStart)
-	if err != nil {
-		return fmt.Errorf("error seeking to position %d: %v", fileEntry.Offset, err)
+	if manifestEntry, ok := r.manifest[key]; ok {
+		if manifestEntry.Index >= 0 && manifestEntry.Index < len(r.fileEntries) && r.fileEntries[manifestEntry.Index].Name == manifestEntry.Name {
+			return r.fileEntries[manifestEntry.Index], nil
+		}
+		return nil, fmt.Errorf("%w: manifest entry %d (%s) is not in the bundle",
+			errNoMatchingEntry, manifestEntry.Index, manifestEntry.Name)
 	}
 
-	// Encrypt the data as needed
-	encryptionKey := byte(fileEntry.Offset & 0xFF)
-	encryptedData := make([]byte, dataLength)
-
-	// Encrypt the data
-	for i := range dataLength {
-		encryptedData[i] = fileData[i] ^ encryptionKey
+	candidates := r.byPath[key]
+	if converter := converterByExtension(key, replacementOptions); converter != nil {
+		// Converters that keep the extension, like the one of text models, already matched above
+		if storedKey := strings.TrimSuffix(key, converter.Extension()) + converter.StoredExtension(); storedKey != key {
+			candidates = append(slices.Clone(candidates), r.byPath[storedKey]...)
+		}
 	}
 
-	// Write the encrypted data to the DAT file
-	bytesWritten, err := outputFile.Write(encryptedData)
-	if err != nil {
-		return fmt.Errorf("error writing data: %v", err)
-	}
-	if bytesWritten != dataLength {
-		return fmt.Errorf("wrote %d bytes but expected to write %d bytes",
-			bytesWritten, dataLength)
+	switch len(candidates) {
+	case 0:
+		return nil, errNoMatchingEntry
+	case 1:
+		return candidates[0], nil
+	default:
+		names := make([]string, 0, len(candidates))
+		for _, entry := range candidates {
+			names = append(names, fmt.Sprintf("%d (%s)", entry.Index, entry.Name))
+		}
+		return nil, fmt.Errorf("%w: could be entry %s", errAmbiguousEntry, strings.Join(names, " or "))
 	}
-
-	fmt.Printf("Successfully updated entry at index %d\n", targetIndex)
-	return nil
 }
 
-// extractSingleFile extracts a single file from the bundle to a specified path
-func extractSingleFile(bundlePath string, fileIndex int, outputPath string) error {
-	file, err := os.Open(bundlePath)
+// extractSingleFile extracts a single file from the bundle to a specified path, converting it as options select
+func extractSingleFile(bundlePath string, fileIndex int, outputPath string, options conversionOptions) error {
+	file, reader, err := openBundle(bundlePath)
 	if err != nil {
-		return fmt.Errorf("unable to open %s: %w", bundlePath, err)
+		return err
 	}
 	defer file.Close()
 
-	_, fileEntries, err := getTableData(file)
-	if err != nil {
-		return fmt.Errorf("failed to get table data: %w", err)
-	}
-
+	fileEntries := reader.Entries()
 	if fileIndex < 0 || fileIndex >= len(fileEntries) {
 		return fmt.Errorf("invalid file index %d", fileIndex)
 	}
 
-	entry := fileEntries[fileIndex]
-
-	// Move the file cursor to the correct position
-	if _, err = file.Seek(int64(entry.Offset), 0); err != nil {
-		return fmt.Errorf("error seeking in bundle: %w", err)
-	}
-
-	// Read data from the file
-	fileData := make([]byte, entry.Length)
-	bytesRead, err := file.Read(fileData)
-	if err != nil {
-		return fmt.Errorf("error extracting from bundle: %w", err)
-	}
-	if bytesRead != int(entry.Length) {
-		return fmt.Errorf("expected to read %d bytes, but got %d", entry.Length, bytesRead)
-	}
-
-	encryptionKey := getFileKey(int64(entry.Offset))
-	var decryptedData []byte
-	for i := range int(entry.Length) {
-		decryptedData = append(decryptedData, fileData[i]^byte(encryptionKey))
-	}
-
-	// Handle conversion based on file type
-	finalOutputPath := outputPath
-	if entry.Name[len(entry.Name)-4:] == ".cnv" {
-		dataKey := decryptedData[0]
-
-		if dataKey == 1 {
-			// WAV conversion with panic recovery
-			err = func() (convertErr error) {
-				defer func() {
-					if r := recover(); r != nil {
-						convertErr = fmt.Errorf("WAV conversion panicked: %v", r)
-					}
-				}()
-				return convertWav(&decryptedData)
-			}()
-
-			if err != nil {
-				// Change extension to .unknown for failed WAV conversion
-				if strings.HasSuffix(finalOutputPath, ".cnv") {
-					finalOutputPath = finalOutputPath[:len(finalOutputPath)-4] + ".unknown"
-				}
-			} else {
-				// Change extension to .wav for successful conversion
-				if strings.HasSuffix(finalOutputPath, ".cnv") {
-					finalOutputPath = finalOutputPath[:len(finalOutputPath)-4] + ".wav"
-				}
-			}
-		} else if dataKey == 24 || dataKey == 32 {
-			err = convertImage(&decryptedData)
-			if err != nil {
-				return fmt.Errorf("error converting image: %w", err)
-			}
-			// Change extension to .bmp for image conversion
-			if strings.HasSuffix(finalOutputPath, ".cnv") {
-				finalOutputPath = finalOutputPath[:len(finalOutputPath)-4] + ".bmp"
-			}
-		} else {
-			// Unknown data key, save as .unknown
-			if strings.HasSuffix(finalOutputPath, ".cnv") {
-				finalOutputPath = finalOutputPath[:len(finalOutputPath)-4] + ".unknown"
-			}
-		}
-	}
-
-	// Create directories as needed for the output path
-	dirPath := filepath.Dir(finalOutputPath)
-	if err = os.MkdirAll(dirPath, os.ModePerm); err != nil {
-		return fmt.Errorf("error creating directory for %s: %w", finalOutputPath, err)
-	}
-
-	// Write the converted data to a new file
-	err = os.WriteFile(finalOutputPath, decryptedData, 0644)
-	if err != nil {
-		return fmt.Errorf("unable to write %s: %w", finalOutputPath, err)
-	}
-
-	return nil
+	_, err = extractEntry(reader, fileEntries[fileIndex], outputPath, options)
+	return err
 }
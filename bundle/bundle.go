@@ -0,0 +1,166 @@
+// Package bundle reads and writes the .DAT bundle files used by Higurashi Daybreak.
+//
+// A bundle starts with a little endian uint16 entry count followed by the
+// encrypted file table. Every table entry is 268 bytes long: a 260 byte
+// Shift JIS name padded with zeros, the uint32 length and the uint32 offset
+// of the file data. The file data itself is XORed with a key derived from
+// its offset, see Cipher.
+//
+// These parameters are those of the Daybreak layout. Other layouts can be
+// described with a Layout and used through OpenLayout and NewLayoutWriter.
+package bundle
+
+import (
+	"fmt"
+	"io"
+)
+
+// Sizes of the Daybreak layout
+const (
+	// TableOffset is the position of the file table, right after the entry count
+	TableOffset = 2
+	// EntrySize is the size of a single file table entry
+	EntrySize = 268
+	// NameSize is the size of the zero padded name field of a file table entry
+	NameSize = 260
+)
+
+// Entry represents an entry in the file table
+type Entry struct {
+	Index  int    // Index of the file in the table
+	Offset uint32 // Offset of the file in the bundle
+	Length uint32 // Length of the file data
+	Name   string // Name of the file
+}
+
+// Reader gives access to the files stored in a bundle.
+//
+// A Reader only ever calls ReadAt on the underlying reader, so it can be used
+// from several goroutines at once as long as the io.ReaderAt allows it, which
+// is the case for *os.File.
+type Reader struct {
+	r       io.ReaderAt
+	size    int64
+	layout  *Layout
+	seed    int
+	entries []*Entry
+	names   map[string]*Entry
+}
+
+// Open reads the file table of the bundle stored in r, which is size bytes long.
+// The table is decrypted with seed 0, used by the retail release.
+func Open(r io.ReaderAt, size int64) (*Reader, error) {
+	return OpenSeed(r, size, 0)
+}
+
+// OpenSeed is like Open but decrypts the table with the given seed, see DetectSeed
+func OpenSeed(r io.ReaderAt, size int64, seed int) (*Reader, error) {
+	return OpenLayout(r, size, Daybreak, seed)
+}
+
+// OpenLayout is like Open for a bundle using the given layout and table seed, see DetectLayout
+func OpenLayout(r io.ReaderAt, size int64, layout *Layout, seed int) (*Reader, error) {
+	// Check the count against the size before the table is allocated
+	count, err := layout.ReadCount(r)
+	if err != nil {
+		return nil, err
+	}
+	if tableEnd := layout.TableEnd(count); tableEnd > size {
+		return nil, fmt.Errorf("file table (%d bytes) is larger than the bundle (%d bytes)", tableEnd, size)
+	}
+
+	entries, err := layout.ReadTable(r, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]*Entry, len(entries))
+	for _, entry := range entries {
+		names[entry.Name] = entry
+	}
+
+	return &Reader{
+		r:       r,
+		size:    size,
+		layout:  layout,
+		seed:    seed,
+		entries: entries,
+		names:   names,
+	}, nil
+}
+
+// Layout returns the layout of the bundle
+func (r *Reader) Layout() *Layout {
+	return r.layout
+}
+
+// Seed returns the seed the file table was decrypted with
+func (r *Reader) Seed() int {
+	return r.seed
+}
+
+// Entries returns the entries of the bundle in table order
+func (r *Reader) Entries() []*Entry {
+	return r.entries
+}
+
+// Lookup returns the entry with the given name
+func (r *Reader) Lookup(name string) (*Entry, bool) {
+	entry, ok := r.names[name]
+	return entry, ok
+}
+
+// Open returns a reader for the decrypted contents of an entry
+func (r *Reader) Open(entry *Entry) (io.ReadCloser, error) {
+	return r.openEntry(entry)
+}
+
+func (r *Reader) openEntry(entry *Entry) (*entryReader, error) {
+	end := int64(entry.Offset) + int64(entry.Length)
+	if end > r.size {
+		return nil, fmt.Errorf("entry %d (%s) ends at %d, past the end of the bundle (%d bytes)",
+			entry.Index, entry.Name, end, r.size)
+	}
+
+	return &entryReader{
+		r:      io.NewSectionReader(r.r, int64(entry.Offset), int64(entry.Length)),
+		cipher: r.layout.NewCipher(int64(entry.Offset)),
+	}, nil
+}
+
+// entryReader decrypts the data of a single entry while it is read
+type entryReader struct {
+	r      *io.SectionReader
+	cipher *Cipher
+}
+
+func (e *entryReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	e.cipher.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+func (e *entryReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := e.r.ReadAt(p, off)
+	e.cipher.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+// Seek works on decrypted positions since the cipher does not depend on the position
+func (e *entryReader) Seek(offset int64, whence int) (int64, error) {
+	return e.r.Seek(offset, whence)
+}
+
+func (e *entryReader) Close() error {
+	return nil
+}
+
+// ReadTable reads and decrypts the file table of a bundle with seed 0
+func ReadTable(r io.ReaderAt) ([]*Entry, error) {
+	return ReadTableSeed(r, 0)
+}
+
+// ReadTableSeed reads and decrypts the file table of a bundle with the given seed
+func ReadTableSeed(r io.ReaderAt, seed int) ([]*Entry, error) {
+	return Daybreak.ReadTable(r, seed)
+}
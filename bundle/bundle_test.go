@@ -0,0 +1,78 @@
+package bundle
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+// writeTestBundle writes a bundle holding contents under names and opens it again
+func writeTestBundle(t *testing.T, names []string, contents [][]byte) (*Reader, []byte) {
+	t.Helper()
+
+	var buffer writeSeekBuffer
+	writer, err := NewWriter(&buffer, len(names))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range names {
+		if _, err := writer.Add(name, bytes.NewReader(contents[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := Open(bytes.NewReader(buffer.data), int64(len(buffer.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader, buffer.data
+}
+
+func TestReaderConcurrentReads(t *testing.T) {
+	names := []string{`bgm\title.ogg`, `image\顔.cnv`, `data\script.txt`}
+	contents := [][]byte{
+		bytes.Repeat([]byte("OggS"), 4096),
+		bytes.Repeat([]byte{32, 1, 2, 3}, 1024),
+		bytes.Repeat([]byte("script "), 2048),
+	}
+	reader, _ := writeTestBundle(t, names, contents)
+
+	if _, ok := reader.Lookup(`bgm\missing.ogg`); ok {
+		t.Error("Lookup found an entry that is not in the bundle")
+	}
+
+	// Every goroutine reads every entry through the same Reader, each read must still get its own bytes
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, name := range names {
+				entry, ok := reader.Lookup(name)
+				if !ok {
+					t.Errorf("Lookup did not find %s", name)
+					return
+				}
+				entryReader, err := reader.Open(entry)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				got, err := io.ReadAll(entryReader)
+				entryReader.Close()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(got, contents[i]) {
+					t.Errorf("entry %d: concurrent read returned different bytes", i)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,28 @@
+package bundle
+
+import "crypto/cipher"
+
+// Cipher encrypts and decrypts the data of an entry. Every byte of an entry is
+// XORed with the same key, derived from the offset the entry is stored at, so
+// encryption and decryption are the same operation and positions inside the
+// entry do not matter.
+type Cipher struct {
+	key byte
+}
+
+var _ cipher.Stream = (*Cipher)(nil)
+
+// NewCipher returns the cipher of an entry stored at offset in a Daybreak bundle
+func NewCipher(offset int64) *Cipher {
+	return Daybreak.NewCipher(offset)
+}
+
+// XORKeyStream implements cipher.Stream, dst and src may overlap entirely
+func (c *Cipher) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("bundle: output smaller than input")
+	}
+	for i, b := range src {
+		dst[i] = b ^ c.key
+	}
+}
@@ -0,0 +1,136 @@
+package bundle
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestFileKey(t *testing.T) {
+	tests := []struct {
+		offset int64
+		key    byte
+	}{
+		{0, 0x08},
+		{2, 0x09},
+		{1342, 0x9f},
+		{0x1fe, 0xff},
+		{0x200, 0x08},
+	}
+	for _, test := range tests {
+		if key := FileKey(test.offset); key != test.key {
+			t.Errorf("FileKey(%d) = %#x, want %#x", test.offset, key, test.key)
+		}
+	}
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	plain := []byte("OggS\x00\x02 some entry data")
+	for _, offset := range []int64{0, 1, 2, 1342, 0x1ff, 1 << 20, MaxSize} {
+		encrypted := make([]byte, len(plain))
+		NewCipher(offset).XORKeyStream(encrypted, plain)
+		if bytes.Equal(encrypted, plain) {
+			t.Errorf("offset %d: encryption left the data unchanged", offset)
+		}
+
+		// Decrypting in place with a fresh cipher must give the data back
+		NewCipher(offset).XORKeyStream(encrypted, encrypted)
+		if !bytes.Equal(encrypted, plain) {
+			t.Errorf("offset %d: got %q after round trip, want %q", offset, encrypted, plain)
+		}
+	}
+}
+
+func TestTableRoundTrip(t *testing.T) {
+	entries := []*Entry{
+		{Index: 0, Offset: 2 + 2*EntrySize, Length: 10, Name: `bgm\title.ogg`},
+		{Index: 1, Offset: 2 + 2*EntrySize + 10, Length: 3, Name: `image\テスト.cnv`},
+	}
+
+	var buffer bytes.Buffer
+	if err := WriteTable(&buffer, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadTable(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("got %d entries, want %d", len(got), len(entries))
+	}
+	for i := range entries {
+		if *got[i] != *entries[i] {
+			t.Errorf("entry %d: got %+v, want %+v", i, got[i], entries[i])
+		}
+	}
+}
+
+func TestWriterReaderRoundTrip(t *testing.T) {
+	names := []string{`bgm\title.ogg`, `bgm\title.sfl`, `image\テスト.cnv`, `empty.txt`}
+	contents := [][]byte{
+		bytes.Repeat([]byte("OggS"), 1000),
+		[]byte("RIFF\x04\x00\x00\x00SFPL"),
+		{32, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4},
+		{},
+	}
+	reader, data := writeTestBundle(t, names, contents)
+
+	fsys, err := NewFS(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, entry := range reader.Entries() {
+		if entry.Name != names[i] {
+			t.Errorf("entry %d: got name %q, want %q", i, entry.Name, names[i])
+		}
+
+		// The stored bytes must use the key of the entry offset
+		stored := data[entry.Offset : entry.Offset+entry.Length]
+		for j := range stored {
+			if stored[j] != contents[i][j]^FileKey(int64(entry.Offset)) {
+				t.Fatalf("entry %d: byte %d is not encrypted with the key of offset %d", i, j, entry.Offset)
+			}
+		}
+
+		// Sequential reads
+		entryReader, err := reader.Open(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(entryReader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, contents[i]) {
+			t.Errorf("entry %d: Open returned %q, want %q", i, got, contents[i])
+		}
+
+		// Random access reads
+		if len(contents[i]) > 1 {
+			at := make([]byte, len(contents[i])-1)
+			if _, err := entryReader.(io.ReaderAt).ReadAt(at, 1); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(at, contents[i][1:]) {
+				t.Errorf("entry %d: ReadAt returned %q, want %q", i, at, contents[i][1:])
+			}
+		}
+
+		// File system reads
+		elems, err := splitEntryName(names[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsData, err := fs.ReadFile(fsys, strings.Join(elems, "/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(fsData, contents[i]) {
+			t.Errorf("entry %d: fs.ReadFile returned %q, want %q", i, fsData, contents[i])
+		}
+	}
+}
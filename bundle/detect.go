@@ -0,0 +1,226 @@
+package bundle
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// SeedCount is the number of table seeds tried, DecryptFileTableBlock only keeps 9 bits
+	SeedCount = 512
+
+	// sampleEntries bounds the number of entries scored per seed, so detection stays fast on large tables
+	sampleEntries = 256
+	// minSeedScore is the lowest score DetectSeed and DetectLayout accept
+	minSeedScore = 0.9
+)
+
+// ErrNoSeed is returned by DetectSeed and DetectLayout when no seed gives a plausible file table
+var ErrNoSeed = errors.New("no plausible table seed")
+
+// SeedScore tells how plausible the file table looks once decrypted with a seed
+type SeedScore struct {
+	Seed    int // Seed the table was decrypted with
+	Entries int // Number of entries checked
+	Names   int // Entries with a printable name followed by zero padding
+	Bounds  int // Entries whose data lies between the end of the table and the end of the bundle
+}
+
+// Score returns the share of checks passed, between 0 and 1
+func (s SeedScore) Score() float64 {
+	if s.Entries == 0 {
+		return 1
+	}
+	return float64(s.Names+s.Bounds) / float64(2*s.Entries)
+}
+
+// ScoreSeeds decrypts the file table of a Daybreak bundle with every seed and returns the scores, best first
+func ScoreSeeds(r io.ReaderAt, size int64) ([]SeedScore, error) {
+	return Daybreak.ScoreSeeds(r, size)
+}
+
+// DetectSeed returns the seed the file table of a Daybreak bundle is encrypted with
+func DetectSeed(r io.ReaderAt, size int64) (int, error) {
+	return Daybreak.DetectSeed(r, size)
+}
+
+// DetectLayout returns the first of layouts, and its seed, giving a plausible file table.
+// A layout whose table decrypts perfectly with seed 0 wins right away, otherwise
+// the layout with the best seed score is returned.
+func DetectLayout(r io.ReaderAt, size int64, layouts []*Layout) (*Layout, int, error) {
+	for _, layout := range layouts {
+		count, table, err := layout.readSampleTable(r, size)
+		if err == nil && layout.scoreTable(layout.TableCipher(0, table), count, size, 0).Score() == 1 {
+			return layout, 0, nil
+		}
+	}
+
+	var best *Layout
+	var bestScore SeedScore
+	for _, layout := range layouts {
+		scores, err := layout.ScoreSeeds(r, size)
+		if err != nil {
+			// The entry count does not fit this layout
+			continue
+		}
+		if best == nil || scores[0].Score() > bestScore.Score() {
+			best, bestScore = layout, scores[0]
+		}
+	}
+
+	if best == nil {
+		return nil, 0, fmt.Errorf("%w: the file table does not fit any layout", ErrNoSeed)
+	}
+	if bestScore.Score() < minSeedScore {
+		return nil, 0, fmt.Errorf("%w: best match is seed %d of the %s layout, scoring only %.2f",
+			ErrNoSeed, bestScore.Seed, best.Name, bestScore.Score())
+	}
+	return best, bestScore.Seed, nil
+}
+
+// ScoreSeeds decrypts the file table with every seed and returns the scores, best first.
+// Ties keep the lowest seed first.
+func (l *Layout) ScoreSeeds(r io.ReaderAt, size int64) ([]SeedScore, error) {
+	count, table, err := l.readSampleTable(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]SeedScore, SeedCount)
+	for seed := range SeedCount {
+		scores[seed] = l.scoreTable(l.TableCipher(seed, table), count, size, seed)
+	}
+	slices.SortStableFunc(scores, func(a, b SeedScore) int { return cmp.Compare(b.Score(), a.Score()) })
+	return scores, nil
+}
+
+// DetectSeed returns the seed the file table of a bundle is encrypted with.
+// Seed 0 is checked first since it is the one used by the retail release,
+// the other seeds are only tried when its table does not look right.
+func (l *Layout) DetectSeed(r io.ReaderAt, size int64) (int, error) {
+	count, table, err := l.readSampleTable(r, size)
+	if err != nil {
+		return 0, err
+	}
+	if l.scoreTable(l.TableCipher(0, table), count, size, 0).Score() == 1 {
+		return 0, nil
+	}
+
+	scores, err := l.ScoreSeeds(r, size)
+	if err != nil {
+		return 0, err
+	}
+	if best := scores[0]; best.Score() >= minSeedScore {
+		return best.Seed, nil
+	}
+	return 0, fmt.Errorf("%w: best seed %d only scores %.2f", ErrNoSeed, scores[0].Seed, scores[0].Score())
+}
+
+// readSampleTable reads the entry count and the encrypted table entries used for scoring
+func (l *Layout) readSampleTable(r io.ReaderAt, size int64) (int, []byte, error) {
+	if size < l.TableOffset() {
+		return 0, nil, fmt.Errorf("bundle (%d bytes) is too short to hold the entry count", size)
+	}
+	count, err := l.ReadCount(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tableEnd := l.TableEnd(count)
+	if tableEnd > size {
+		return 0, nil, fmt.Errorf("file table (%d bytes) is larger than the bundle (%d bytes)", tableEnd, size)
+	}
+
+	// The key stream starts with the table, so the sample is its first entries
+	table := make([]byte, l.EntrySize*min(count, sampleEntries))
+	if _, err := r.ReadAt(table, l.TableOffset()); err != nil {
+		return 0, nil, fmt.Errorf("error reading table: %w", err)
+	}
+	return count, table, nil
+}
+
+// scoreTable checks the decrypted entries of a table sample
+func (l *Layout) scoreTable(table []byte, count int, size int64, seed int) SeedScore {
+	score := SeedScore{Seed: seed, Entries: len(table) / l.EntrySize}
+	tableEnd := l.TableEnd(count)
+
+	for i := range score.Entries {
+		name, length, offset := l.SplitEntry(table[i*l.EntrySize : (i+1)*l.EntrySize])
+		if l.plausibleName(name) {
+			score.Names++
+		}
+
+		end := int64(offset) + int64(length)
+		if (length == 0 || int64(offset) >= tableEnd) && end <= size {
+			score.Bounds++
+		}
+	}
+	return score
+}
+
+// plausibleName reports whether a name field holds printable characters followed by zero padding only
+func (l *Layout) plausibleName(field []byte) bool {
+	name, padding, _ := bytes.Cut(field, []byte{0})
+	if len(name) == 0 || len(bytes.Trim(padding, "\x00")) != 0 {
+		return false
+	}
+
+	decoded, err := l.DecodeName(name)
+	if err != nil {
+		return false
+	}
+	for _, r := range decoded {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// payloadMagics are the first bytes of the entries whose format is known, by extension
+var payloadMagics = map[string][]string{
+	".ogg": {"OggS"},
+	".wav": {"RIFF"},
+	".sfl": {"RIFF"},
+	".x":   {"xof "},
+	".cnv": {"\x01", "\x18", "\x20"}, // Data keys of audio, 24 bit and 32 bit image CNVs
+}
+
+// CheckPayloads decrypts the first bytes of every entry with a known format and counts
+// the ones starting with the expected magic. A payload key that does not match the
+// bundle leaves matched close to zero.
+func CheckPayloads(r *Reader) (checked, matched int, err error) {
+	for _, entry := range r.entries {
+		magics, ok := payloadMagics[strings.ToLower(path.Ext(strings.ReplaceAll(entry.Name, `\`, "/")))]
+		if !ok || entry.Length == 0 {
+			continue
+		}
+
+		entryReader, err := r.openEntry(entry)
+		if err != nil {
+			// Entries out of bounds cannot be checked
+			continue
+		}
+		header := make([]byte, min(int(entry.Length), 4))
+		if _, err := entryReader.ReadAt(header, 0); err != nil {
+			return checked, matched, fmt.Errorf("error reading entry %d: %w", entry.Index, err)
+		}
+
+		checked++
+		for _, magic := range magics {
+			if bytes.HasPrefix(header, []byte(magic)) {
+				matched++
+				break
+			}
+		}
+	}
+	return checked, matched, nil
+}
@@ -0,0 +1,48 @@
+package bundle
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDetectSeed(t *testing.T) {
+	names := []string{`bgm\title.ogg`, `image\テスト.cnv`, `model\chara.x`}
+	contents := [][]byte{[]byte("OggS data"), {32, 1, 0, 0, 0}, []byte("xof 0303txt 0032")}
+
+	for _, seed := range []int{0, 1, 301, SeedCount - 1} {
+		var buffer writeSeekBuffer
+		writer, err := NewWriterSeed(&buffer, len(names), seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range names {
+			if _, err := writer.Add(name, bytes.NewReader(contents[i])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		data := bytes.NewReader(buffer.data)
+		detected, err := DetectSeed(data, int64(len(buffer.data)))
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if detected != seed {
+			t.Errorf("detected seed %d, want %d", detected, seed)
+		}
+
+		reader, err := OpenSeed(data, int64(len(buffer.data)), detected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checked, matched, err := CheckPayloads(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checked != len(names) || matched != checked {
+			t.Errorf("seed %d: %d/%d payloads matched, want %d/%d", seed, matched, checked, len(names), len(names))
+		}
+	}
+}
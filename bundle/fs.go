@@ -0,0 +1,244 @@
+package bundle
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FS exposes the entries of a bundle as a read only file system.
+//
+// The directory tree is built from the entry names, which may use
+// backslashes or slashes as separators. FS paths always use slashes, as
+// required by io/fs. When several entries share a name, the last one wins,
+// like for Reader.Lookup.
+type FS struct {
+	reader *Reader
+	root   *fsNode
+}
+
+var (
+	_ fs.FS        = (*FS)(nil)
+	_ fs.ReadDirFS = (*FS)(nil)
+	_ fs.StatFS    = (*FS)(nil)
+)
+
+// fsNode is a file or a directory of the tree built by NewFS
+type fsNode struct {
+	name     string
+	entry    *Entry             // nil for directories
+	children map[string]*fsNode // nil for files
+}
+
+// NewFS builds the directory tree of the bundle read by r
+func NewFS(r *Reader) (*FS, error) {
+	root := &fsNode{name: ".", children: map[string]*fsNode{}}
+
+	for _, entry := range r.entries {
+		elems, err := splitEntryName(entry.Name)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", entry.Index, err)
+		}
+
+		dir := root
+		for _, elem := range elems[:len(elems)-1] {
+			child, ok := dir.children[elem]
+			if !ok {
+				child = &fsNode{name: elem, children: map[string]*fsNode{}}
+				dir.children[elem] = child
+			}
+			if child.entry != nil {
+				return nil, fmt.Errorf("entry %d: %s is both a file and a directory", entry.Index, entry.Name)
+			}
+			dir = child
+		}
+
+		base := elems[len(elems)-1]
+		if child, ok := dir.children[base]; ok && child.entry == nil {
+			return nil, fmt.Errorf("entry %d: %s is both a file and a directory", entry.Index, entry.Name)
+		}
+		dir.children[base] = &fsNode{name: base, entry: entry}
+	}
+
+	return &FS{reader: r, root: root}, nil
+}
+
+// splitEntryName splits an entry name into slash separated path elements
+func splitEntryName(name string) ([]string, error) {
+	var elems []string
+	for _, elem := range strings.Split(strings.ReplaceAll(name, `\`, "/"), "/") {
+		if elem == "" || elem == "." {
+			continue
+		}
+		if elem == ".." {
+			return nil, fmt.Errorf("invalid name %q", name)
+		}
+		elems = append(elems, elem)
+	}
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("invalid name %q", name)
+	}
+	return elems, nil
+}
+
+// lookup finds the node for a slash separated path
+func (f *FS) lookup(op, name string) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	node := f.root
+	if name == "." {
+		return node, nil
+	}
+	for _, elem := range strings.Split(name, "/") {
+		child, ok := node.children[elem]
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// Open opens the named file or directory
+func (f *FS) Open(name string) (fs.File, error) {
+	node, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.entry == nil {
+		return &fsDir{node: node, entries: node.dirEntries()}, nil
+	}
+
+	entryReader, err := f.reader.openEntry(node.entry)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &fsFile{entryReader: entryReader, node: node}, nil
+}
+
+// Stat returns the file info of the named file or directory
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	node, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fsFileInfo{node}, nil
+}
+
+// ReadDir returns the entries of the named directory sorted by name
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := f.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.entry != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return node.dirEntries(), nil
+}
+
+// dirEntries returns the children of a directory node sorted by name
+func (n *fsNode) dirEntries() []fs.DirEntry {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	entries := make([]fs.DirEntry, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, fs.FileInfoToDirEntry(fsFileInfo{n.children[name]}))
+	}
+	return entries
+}
+
+// fsFileInfo implements fs.FileInfo for a node
+type fsFileInfo struct {
+	node *fsNode
+}
+
+func (i fsFileInfo) Name() string {
+	return path.Base(i.node.name)
+}
+
+func (i fsFileInfo) Size() int64 {
+	if i.node.entry == nil {
+		return 0
+	}
+	return int64(i.node.entry.Length)
+}
+
+func (i fsFileInfo) Mode() fs.FileMode {
+	if i.node.entry == nil {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i fsFileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (i fsFileInfo) IsDir() bool {
+	return i.node.entry == nil
+}
+
+// Sys returns the *Entry of files and nil for directories
+func (i fsFileInfo) Sys() any {
+	if i.node.entry == nil {
+		return nil
+	}
+	return i.node.entry
+}
+
+// fsFile is an open file of an FS, reads are decrypted on the fly
+type fsFile struct {
+	*entryReader
+	node *fsNode
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return fsFileInfo{f.node}, nil
+}
+
+// fsDir is an open directory of an FS
+type fsDir struct {
+	node    *fsNode
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return fsFileInfo{d.node}, nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.name, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+// ReadDir follows the semantics of fs.ReadDirFile
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(remaining))
+	d.offset += n
+	return remaining[:n], nil
+}
@@ -0,0 +1,30 @@
+package bundle
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	// Names are stored in Shift-JIS and nested with both separators
+	names := []string{`bgm\タイトル.ogg`, `image\キャラ\顔.cnv`, `image/キャラ/体.cnv`, `image\背景.cnv`, `readme.txt`}
+	contents := [][]byte{[]byte("OggS"), {32, 1, 0, 0, 0}, {24, 2, 0, 0, 0}, {}, []byte("readme")}
+	reader, _ := writeTestBundle(t, names, contents)
+
+	fsys, err := NewFS(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "bgm/タイトル.ogg", "image/キャラ/顔.cnv", "image/キャラ/体.cnv", "image/背景.cnv", "readme.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat(fsys, "image/キャラ/顔.cnv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := info.Sys().(*Entry); !ok || entry.Index != 1 {
+		t.Errorf("got Sys() %v, want entry 1", info.Sys())
+	}
+}
@@ -0,0 +1,316 @@
+package bundle
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// FieldOrder is the order of the length and offset fields following the name of a table entry
+type FieldOrder int
+
+const (
+	LengthOffset FieldOrder = iota // Length first, as in Daybreak
+	OffsetLength                   // Offset first
+)
+
+// Layout describes how a bundle stores its file table and encrypts its data.
+// Bundles of sibling games share the structure described in the package
+// documentation but may differ in these parameters.
+type Layout struct {
+	Name       string     // Name used to select the layout
+	CountSize  int        // Size of the little endian entry count in front of the table, 2 or 4
+	NameSize   int        // Size of the zero padded name field at the start of a table entry
+	EntrySize  int        // Size of a table entry, at least NameSize+8, extra bytes are zero
+	FieldOrder FieldOrder // Order of the uint32 length and offset fields following the name
+
+	// TableCipher encrypts or decrypts the file table with a seed, applying it twice gives the data back
+	TableCipher func(seed int, data []byte) []byte
+	// FileKey returns the key entry data stored at offset is XORed with
+	FileKey func(offset int64) byte
+	// NameEncoding converts the names of the table to and from UTF-8
+	NameEncoding encoding.Encoding
+}
+
+// Daybreak is the layout of the Higurashi Daybreak bundles
+var Daybreak = &Layout{
+	Name:         "daybreak",
+	CountSize:    TableOffset,
+	NameSize:     NameSize,
+	EntrySize:    EntrySize,
+	FieldOrder:   LengthOffset,
+	TableCipher:  DecryptFileTableBlock,
+	FileKey:      FileKey,
+	NameEncoding: japanese.ShiftJIS,
+}
+
+// Layouts returns the built-in layouts, in the order auto-detection tries them
+func Layouts() []*Layout {
+	return []*Layout{Daybreak}
+}
+
+// LookupLayout returns the built-in layout with the given name
+func LookupLayout(name string) (*Layout, bool) {
+	for _, layout := range Layouts() {
+		if strings.EqualFold(layout.Name, name) {
+			return layout, true
+		}
+	}
+	return nil, false
+}
+
+// Named key functions and encodings layout files can refer to
+var (
+	tableCiphers = map[string]func(int, []byte) []byte{
+		"daybreak": DecryptFileTableBlock,
+		"none":     func(seed int, data []byte) []byte { return append([]byte(nil), data...) },
+	}
+	fileKeys = map[string]func(int64) byte{
+		"daybreak": FileKey,
+		"none":     func(offset int64) byte { return 0 },
+	}
+	nameEncodings = map[string]encoding.Encoding{
+		"shift_jis": japanese.ShiftJIS,
+		"euc-jp":    japanese.EUCJP,
+		"utf-8":     unicode.UTF8,
+	}
+)
+
+// layoutFile is the JSON form of a layout, key functions and encodings are given by name
+type layoutFile struct {
+	Name         string `json:"name"`
+	CountSize    int    `json:"countSize"`
+	NameSize     int    `json:"nameSize"`
+	EntrySize    int    `json:"entrySize"`
+	FieldOrder   string `json:"fieldOrder"`   // "length-offset" or "offset-length"
+	TableCipher  string `json:"tableCipher"`  // "daybreak" or "none"
+	FileKey      string `json:"fileKey"`      // "daybreak" or "none"
+	NameEncoding string `json:"nameEncoding"` // "shift_jis", "euc-jp" or "utf-8"
+}
+
+// ParseLayout reads a layout from its JSON form, missing fields take the Daybreak values
+func ParseLayout(data []byte) (*Layout, error) {
+	file := layoutFile{
+		CountSize:    Daybreak.CountSize,
+		NameSize:     Daybreak.NameSize,
+		EntrySize:    Daybreak.EntrySize,
+		FieldOrder:   "length-offset",
+		TableCipher:  "daybreak",
+		FileKey:      "daybreak",
+		NameEncoding: "shift_jis",
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error decoding layout: %w", err)
+	}
+
+	layout := &Layout{
+		Name:         file.Name,
+		CountSize:    file.CountSize,
+		NameSize:     file.NameSize,
+		EntrySize:    file.EntrySize,
+		TableCipher:  tableCiphers[file.TableCipher],
+		FileKey:      fileKeys[file.FileKey],
+		NameEncoding: nameEncodings[strings.ToLower(file.NameEncoding)],
+	}
+
+	switch file.FieldOrder {
+	case "length-offset":
+		layout.FieldOrder = LengthOffset
+	case "offset-length":
+		layout.FieldOrder = OffsetLength
+	default:
+		return nil, fmt.Errorf("unknown field order %q", file.FieldOrder)
+	}
+	if layout.TableCipher == nil {
+		return nil, fmt.Errorf("unknown table cipher %q", file.TableCipher)
+	}
+	if layout.FileKey == nil {
+		return nil, fmt.Errorf("unknown file key %q", file.FileKey)
+	}
+	if layout.NameEncoding == nil {
+		return nil, fmt.Errorf("unknown name encoding %q", file.NameEncoding)
+	}
+
+	if err := layout.validate(); err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+// validate checks that the sizes of a layout are consistent
+func (l *Layout) validate() error {
+	if l.Name == "" {
+		return fmt.Errorf("layout has no name")
+	}
+	if l.CountSize != 2 && l.CountSize != 4 {
+		return fmt.Errorf("layout %s: count size must be 2 or 4, not %d", l.Name, l.CountSize)
+	}
+	if l.NameSize <= 0 || l.EntrySize < l.NameSize+8 {
+		return fmt.Errorf("layout %s: entries of %d bytes cannot hold a %d bytes name and two uint32 fields",
+			l.Name, l.EntrySize, l.NameSize)
+	}
+	return nil
+}
+
+// TableOffset returns the position of the file table, right after the entry count
+func (l *Layout) TableOffset() int64 {
+	return int64(l.CountSize)
+}
+
+// TableEnd returns the position right after a table of count entries, where the file data starts
+func (l *Layout) TableEnd(count int) int64 {
+	return l.TableOffset() + int64(l.EntrySize)*int64(count)
+}
+
+// MaxEntries returns the largest number of entries the entry count can hold.
+// A 4 byte count is limited to the largest int, which is smaller on 32-bit targets.
+func (l *Layout) MaxEntries() int {
+	if l.CountSize == 2 {
+		return math.MaxUint16
+	}
+	return min(math.MaxUint32, math.MaxInt)
+}
+
+// NewCipher returns the cipher of an entry stored at offset
+func (l *Layout) NewCipher(offset int64) *Cipher {
+	return &Cipher{key: l.FileKey(offset)}
+}
+
+// ReadCount reads the number of entries in front of the table
+func (l *Layout) ReadCount(r io.ReaderAt) (int, error) {
+	buffer := make([]byte, l.CountSize)
+	if _, err := r.ReadAt(buffer, 0); err != nil {
+		return 0, fmt.Errorf("error reading table length: %w", err)
+	}
+	if l.CountSize == 2 {
+		return int(binary.LittleEndian.Uint16(buffer)), nil
+	}
+	count := binary.LittleEndian.Uint32(buffer)
+	if uint64(count) > uint64(l.MaxEntries()) {
+		return 0, fmt.Errorf("%w: entry count %d (max %d)", ErrTooManyEntries, count, l.MaxEntries())
+	}
+	return int(count), nil
+}
+
+// SplitEntry returns the raw name field, the length and the offset of a decrypted table entry
+func (l *Layout) SplitEntry(field []byte) (name []byte, length, offset uint32) {
+	first := binary.LittleEndian.Uint32(field[l.NameSize : l.NameSize+4])
+	second := binary.LittleEndian.Uint32(field[l.NameSize+4 : l.NameSize+8])
+	if l.FieldOrder == OffsetLength {
+		return field[:l.NameSize], second, first
+	}
+	return field[:l.NameSize], first, second
+}
+
+// ReadTable reads and decrypts the file table of a bundle with the given seed
+func (l *Layout) ReadTable(r io.ReaderAt, seed int) ([]*Entry, error) {
+	numFiles, err := l.ReadCount(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// A corrupt count must not allocate a table larger than the bundle, check that its last byte exists first
+	tableEnd := l.TableEnd(numFiles)
+	if numFiles > 0 {
+		if _, err := r.ReadAt(make([]byte, 1), tableEnd-1); err != nil {
+			return nil, fmt.Errorf("file table of %d entries (%d bytes) is larger than the bundle: %w", numFiles, tableEnd, err)
+		}
+	}
+
+	// Read the file table
+	buffer := make([]byte, tableEnd-l.TableOffset())
+	if _, err := io.ReadFull(io.NewSectionReader(r, l.TableOffset(), int64(len(buffer))), buffer); err != nil {
+		return nil, fmt.Errorf("error reading table: %w", err)
+	}
+
+	decryptedData := l.TableCipher(seed, buffer)
+
+	fileEntries := make([]*Entry, 0, numFiles)
+	for i := range numFiles {
+		field, length, offset := l.SplitEntry(decryptedData[i*l.EntrySize : (i+1)*l.EntrySize])
+		name, err := l.DecodeName(field)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding name of entry %d: %w", i, err)
+		}
+
+		fileEntries = append(fileEntries, &Entry{
+			Index:  i,
+			Offset: offset,
+			Length: length,
+			Name:   name,
+		})
+	}
+
+	return fileEntries, nil
+}
+
+// WriteTable writes the entry count and the file table encrypted with the given seed
+func (l *Layout) WriteTable(w io.Writer, entries []*Entry, seed int) error {
+	if len(entries) > l.MaxEntries() {
+		return fmt.Errorf("%w: %d (max %d)", ErrTooManyEntries, len(entries), l.MaxEntries())
+	}
+
+	// Write the number of files (little endian)
+	tableData := make([]byte, l.TableEnd(len(entries)))
+	if l.CountSize == 2 {
+		binary.LittleEndian.PutUint16(tableData, uint16(len(entries)))
+	} else {
+		binary.LittleEndian.PutUint32(tableData, uint32(len(entries)))
+	}
+
+	for i, entry := range entries {
+		name, err := l.EncodeName(entry.Name)
+		if err != nil {
+			return err
+		}
+
+		// The name field is zero padded, the buffer is already zeroed
+		field := tableData[l.TableOffset()+int64(i*l.EntrySize) : l.TableOffset()+int64((i+1)*l.EntrySize)]
+		copy(field, name)
+		first, second := entry.Length, entry.Offset
+		if l.FieldOrder == OffsetLength {
+			first, second = second, first
+		}
+		binary.LittleEndian.PutUint32(field[l.NameSize:l.NameSize+4], first)
+		binary.LittleEndian.PutUint32(field[l.NameSize+4:l.NameSize+8], second)
+	}
+
+	// Encrypt the table data and write it
+	copy(tableData[l.TableOffset():], l.TableCipher(seed, tableData[l.TableOffset():]))
+	if _, err := w.Write(tableData); err != nil {
+		return fmt.Errorf("error writing file table: %w", err)
+	}
+	return nil
+}
+
+// DecodeName decodes a zero padded name field
+func (l *Layout) DecodeName(field []byte) (string, error) {
+	// Remove null bytes from the filename
+	filename := strings.TrimRight(string(field), "\x00")
+
+	decoded, _, err := transform.String(l.NameEncoding.NewDecoder(), filename)
+	if err != nil {
+		return "", err
+	}
+	return decoded, nil
+}
+
+// EncodeName converts a name to the table encoding and checks that it fits in the name field
+func (l *Layout) EncodeName(name string) ([]byte, error) {
+	encoded, err := l.NameEncoding.NewEncoder().Bytes([]byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %q for the %s layout: %w", name, l.Name, err)
+	}
+	if len(encoded) > l.NameSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes in the %s layout (max %d)", ErrNameTooLong, name, len(encoded), l.Name, l.NameSize)
+	}
+	return encoded, nil
+}
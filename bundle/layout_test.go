@@ -0,0 +1,85 @@
+package bundle
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestLayoutRoundTrip(t *testing.T) {
+	sibling, err := ParseLayout([]byte(`{"name": "sibling", "countSize": 4, "nameSize": 64, "entrySize": 76,
+		"fieldOrder": "offset-length", "tableCipher": "none", "fileKey": "none", "nameEncoding": "utf-8"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{`bgm\title.ogg`, `image\テスト.cnv`}
+	contents := [][]byte{[]byte("OggS data"), {32, 1, 0, 0, 0}}
+
+	for _, layout := range []*Layout{Daybreak, sibling} {
+		var buffer writeSeekBuffer
+		writer, err := NewLayoutWriter(&buffer, len(names), layout, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range names {
+			if _, err := writer.Add(name, bytes.NewReader(contents[i])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if size := layout.TableEnd(len(names)) + int64(len(contents[0])+len(contents[1])); int64(len(buffer.data)) != size {
+			t.Errorf("%s: bundle is %d bytes, want %d", layout.Name, len(buffer.data), size)
+		}
+
+		data := bytes.NewReader(buffer.data)
+		detected, seed, err := DetectLayout(data, int64(len(buffer.data)), []*Layout{Daybreak, sibling})
+		if err != nil {
+			t.Fatalf("%s: %v", layout.Name, err)
+		}
+		// The sibling table is not encrypted, so every seed reads it
+		if detected != layout || (layout == Daybreak && seed != 7) {
+			t.Errorf("detected seed %d of the %s layout, want seed 7 of the %s layout", seed, detected.Name, layout.Name)
+		}
+
+		reader, err := OpenLayout(data, int64(len(buffer.data)), detected, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, entry := range reader.Entries() {
+			if entry.Name != names[i] {
+				t.Errorf("%s: entry %d: got name %q, want %q", layout.Name, i, entry.Name, names[i])
+			}
+			entryReader, err := reader.Open(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(entryReader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, contents[i]) {
+				t.Errorf("%s: entry %d: got %q, want %q", layout.Name, i, got, contents[i])
+			}
+		}
+	}
+}
+
+func TestCorruptEntryCount(t *testing.T) {
+	sibling, err := ParseLayout([]byte(`{"name": "sibling", "countSize": 4, "nameSize": 64, "entrySize": 76,
+		"fieldOrder": "offset-length", "tableCipher": "none", "fileKey": "none", "nameEncoding": "utf-8"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A count this large would ask for hundreds of gigabytes if the table were allocated first
+	data := append([]byte{0xf0, 0xff, 0xff, 0x7f}, make([]byte, 100)...)
+	if _, err := OpenLayout(bytes.NewReader(data), int64(len(data)), sibling, 0); err == nil {
+		t.Error("OpenLayout accepted a table larger than the bundle")
+	}
+	if _, err := sibling.ReadTable(bytes.NewReader(data), 0); err == nil {
+		t.Error("ReadTable accepted a table larger than the bundle")
+	}
+}
@@ -0,0 +1,142 @@
+package bundle
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// MaxEntries is the largest number of entries the uint16 count of the Daybreak layout can hold
+	MaxEntries = math.MaxUint16
+	// MaxSize is the largest bundle size the uint32 offsets and lengths can address
+	MaxSize = math.MaxUint32
+)
+
+var (
+	// ErrTooManyEntries is returned when a bundle would have more entries than its count can hold
+	ErrTooManyEntries = errors.New("too many entries")
+	// ErrTooLarge is returned when file data would end past MaxSize
+	ErrTooLarge = errors.New("bundle too large")
+	// ErrNameTooLong is returned when an encoded name does not fit in the name field
+	ErrNameTooLong = errors.New("name too long")
+)
+
+// Writer builds a complete bundle from scratch.
+//
+// The file table sits in front of the file data and its size decides where
+// the data starts, so the number of entries must be known in advance. File
+// data is written as entries are added, the table is written by Close.
+type Writer struct {
+	w       io.WriteSeeker
+	count   int
+	layout  *Layout
+	seed    int
+	entries []*Entry
+	offset  int64
+}
+
+// NewWriter returns a Writer for a bundle holding count entries, its table is encrypted with seed 0
+func NewWriter(w io.WriteSeeker, count int) (*Writer, error) {
+	return NewWriterSeed(w, count, 0)
+}
+
+// NewWriterSeed is like NewWriter but encrypts the table with the given seed
+func NewWriterSeed(w io.WriteSeeker, count int, seed int) (*Writer, error) {
+	return NewLayoutWriter(w, count, Daybreak, seed)
+}
+
+// NewLayoutWriter is like NewWriter for a bundle using the given layout and table seed
+func NewLayoutWriter(w io.WriteSeeker, count int, layout *Layout, seed int) (*Writer, error) {
+	if count < 0 || count > layout.MaxEntries() {
+		return nil, fmt.Errorf("%w: %d (max %d)", ErrTooManyEntries, count, layout.MaxEntries())
+	}
+
+	// Leave room for the table, it is filled in by Close
+	offset := layout.TableEnd(count)
+	if _, err := w.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error seeking past file table: %w", err)
+	}
+
+	return &Writer{
+		w:       w,
+		count:   count,
+		layout:  layout,
+		seed:    seed,
+		entries: make([]*Entry, 0, count),
+		offset:  offset,
+	}, nil
+}
+
+// Add encrypts the contents of r and stores them under name right after the
+// previous entry. It returns the entry as it will appear in the table.
+func (w *Writer) Add(name string, r io.Reader) (*Entry, error) {
+	if len(w.entries) == w.count {
+		return nil, fmt.Errorf("%w: bundle was created for %d entries", ErrTooManyEntries, w.count)
+	}
+	if _, err := w.layout.EncodeName(name); err != nil {
+		return nil, err
+	}
+	if w.offset > MaxSize {
+		return nil, fmt.Errorf("%w: %s would start at offset %d", ErrTooLarge, name, w.offset)
+	}
+
+	entry := &Entry{
+		Index:  len(w.entries),
+		Offset: uint32(w.offset),
+		Name:   name,
+	}
+
+	// Copy at most one byte past the limit so oversized data can be detected
+	limit := MaxSize - w.offset
+	encrypter := cipher.StreamWriter{S: w.layout.NewCipher(w.offset), W: w.w}
+	written, err := io.Copy(encrypter, io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("error writing %s: %w", name, err)
+	}
+	if written > limit {
+		return nil, fmt.Errorf("%w: %s would end past offset %d", ErrTooLarge, name, int64(MaxSize))
+	}
+
+	entry.Length = uint32(written)
+	w.entries = append(w.entries, entry)
+	w.offset += written
+	return entry, nil
+}
+
+// Close writes the file table. It fails if fewer entries than announced were added.
+func (w *Writer) Close() error {
+	if len(w.entries) != w.count {
+		return fmt.Errorf("bundle was created for %d entries but %d were added", w.count, len(w.entries))
+	}
+
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking to file table: %w", err)
+	}
+	if err := w.layout.WriteTable(w.w, w.entries, w.seed); err != nil {
+		return err
+	}
+
+	// Leave the writer positioned at the end of the bundle
+	if _, err := w.w.Seek(w.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking to end of bundle: %w", err)
+	}
+	return nil
+}
+
+// WriteTable writes the entry count and the file table encrypted with seed 0
+func WriteTable(w io.Writer, entries []*Entry) error {
+	return WriteTableSeed(w, entries, 0)
+}
+
+// WriteTableSeed writes the entry count and the file table encrypted with the given seed
+func WriteTableSeed(w io.Writer, entries []*Entry, seed int) error {
+	return Daybreak.WriteTable(w, entries, seed)
+}
+
+// EncodeName converts a name to Shift JIS and checks that it fits in the table
+func EncodeName(name string) ([]byte, error) {
+	return Daybreak.EncodeName(name)
+}
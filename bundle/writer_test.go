@@ -0,0 +1,86 @@
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// writeSeekBuffer is an in memory io.WriteSeeker
+type writeSeekBuffer struct {
+	data   []byte
+	offset int64
+}
+
+func (b *writeSeekBuffer) Write(p []byte) (int, error) {
+	if end := b.offset + int64(len(p)); end > int64(len(b.data)) {
+		b.data = append(b.data, make([]byte, end-int64(len(b.data)))...)
+	}
+	n := copy(b.data[b.offset:], p)
+	b.offset += int64(n)
+	return n, nil
+}
+
+func (b *writeSeekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += int64(len(b.data))
+	}
+	b.offset = offset
+	return offset, nil
+}
+
+func TestWriterOffsets(t *testing.T) {
+	var buffer writeSeekBuffer
+	writer, err := NewWriter(&buffer, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Data starts right after the table and every entry follows the previous one
+	offset := int64(TableOffset + EntrySize*3)
+	for i, content := range []string{"first", "", "third entry"} {
+		entry, err := writer.Add(strings.Repeat("x", i+1), strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(entry.Offset) != offset || int(entry.Length) != len(content) {
+			t.Errorf("entry %d: got offset %d and length %d, want %d and %d", i, entry.Offset, entry.Length, offset, len(content))
+		}
+		offset += int64(len(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(buffer.data)) != offset {
+		t.Errorf("got %d bytes, want %d", len(buffer.data), offset)
+	}
+}
+
+func TestWriterLimits(t *testing.T) {
+	var buffer writeSeekBuffer
+	if _, err := NewWriter(&buffer, MaxEntries+1); !errors.Is(err, ErrTooManyEntries) {
+		t.Errorf("got %v for %d entries, want %v", err, MaxEntries+1, ErrTooManyEntries)
+	}
+
+	writer, err := NewWriter(&buffer, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Add(strings.Repeat("名", NameSize/2+1), bytes.NewReader(nil)); !errors.Is(err, ErrNameTooLong) {
+		t.Errorf("got %v for a name of %d Shift-JIS bytes, want %v", err, NameSize+2, ErrNameTooLong)
+	}
+	if err := writer.Close(); err == nil {
+		t.Error("Close succeeded with fewer entries than announced")
+	}
+	if _, err := writer.Add("data.txt", bytes.NewReader(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Add("extra.txt", bytes.NewReader(nil)); !errors.Is(err, ErrTooManyEntries) {
+		t.Errorf("got %v for an extra entry, want %v", err, ErrTooManyEntries)
+	}
+}
@@ -1,127 +1,86 @@
 package main
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
-	"io"
-	"os"
+	"io/fs"
 	"path/filepath"
-	"strings"
+	"slices"
 
-	"golang.org/x/text/encoding/japanese"
-	"golang.org/x/text/transform"
+	"BundleTools/bundle"
 )
 
-// getTableData reads the table data from the file and returns a map of the table and a slice of the table
-func getTableData(inputFile *os.File) (map[string]*FileEntry, []*FileEntry, error) {
-	// Move to the beginning of the file
-	if _, err := inputFile.Seek(0, io.SeekStart); err != nil {
-		return nil, nil, fmt.Errorf("error seeking to start of file: %v", err)
-	}
-
-	buffer := make([]byte, 2)
-	bytesRead, err := inputFile.Read(buffer)
-	if err != nil || bytesRead != len(buffer) {
-		return nil, nil, fmt.Errorf("error reading table length: %v", err)
-	}
-	numFiles := binary.LittleEndian.Uint16(buffer)
-
-	// Read the file table
-	buffer = make([]byte, 268*int(numFiles))
-	bytesRead, err = inputFile.Read(buffer)
-	if err != nil || bytesRead != len(buffer) {
-		return nil, nil, fmt.Errorf("error reading table: %v", err)
-	}
-
-	decryptedData := decryptFileTableBlock(0, buffer)
-
-	// Tables to be returned
-	fileEntries := make([]*FileEntry, 0, numFiles)
-	fileEntryMap := make(map[string]*FileEntry, numFiles)
-
-	// Loop to process each file
-	for i := range int(numFiles) {
-		entry := decryptedData[i*268 : (i+1)*268]
-		filename := string(entry[:260])
-		length := binary.LittleEndian.Uint32(entry[260:264])
-		offset := binary.LittleEndian.Uint32(entry[264:268])
+// updateFile is a source file together with the entry it replaces
+type updateFile struct {
+	sourcePath string
+	entry      *bundle.Entry
+}
 
-		// Remove null bytes from the filename
-		filename = strings.TrimRight(filename, "\x00")
+// collectUpdateFiles walks the source folder and resolves every file to the entry it replaces.
+// Files matching no entry or several entries, and files competing for the same entry,
+// are reported and left out instead of guessing.
+func collectUpdateFiles(sourcePath string, resolver *entryResolver) ([]*updateFile, error) {
+	var updateFiles []*updateFile
+	var unmatched, ambiguous []string
+	byIndex := make(map[int][]*updateFile)
 
-		// Decode shift_jis
-		decodedFilenameReader := transform.NewReader(strings.NewReader(filename), japanese.ShiftJIS.NewDecoder())
-		decodedFilenameData, err := io.ReadAll(decodedFilenameReader)
+	err := filepath.WalkDir(sourcePath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return nil, nil, fmt.Errorf("error decoding shift_jis: %v", err)
+			return err
 		}
-
-		// Create the table entry
-		decodedFilename := string(decodedFilenameData)
-		fileEntry := &FileEntry{
-			Index:  i,
-			Offset: offset,
-			Length: length,
-			Name:   decodedFilename,
+		if d.IsDir() {
+			return nil
 		}
 
-		fileEntries = append(fileEntries, fileEntry)
-		fileEntryMap[decodedFilename] = fileEntry
-	}
-
-	return fileEntryMap, fileEntries, nil
-}
-
-// recursivePatchDir processes directories recursively for patching operations
-// index-based lookups are faster than name-based lookups
-func recursivePatchDir(outputFile *os.File, dirPath string, relPath string, fileEntries []*FileEntry, modificationTime float64) {
-	// Open the directory
-	dir, err := os.Open(dirPath)
-	if err != nil {
-		fmt.Printf("Unable to open %s: %v\n", dirPath, err)
-		return
-	}
-	defer dir.Close()
+		relPath, err := filepath.Rel(sourcePath, path)
+		if err != nil {
+			return err
+		}
+		if relPath == manifestFileName {
+			return nil
+		}
 
-	// Get all files in the directory
-	fileInfos, err := dir.Readdir(0)
+		entry, err := resolver.resolve(relPath)
+		switch {
+		case errors.Is(err, errAmbiguousEntry):
+			ambiguous = append(ambiguous, fmt.Sprintf("%s: %v", path, err))
+		case err != nil:
+			unmatched = append(unmatched, fmt.Sprintf("%s: %v", path, err))
+		default:
+			updateFile := &updateFile{sourcePath: path, entry: entry}
+			byIndex[entry.Index] = append(byIndex[entry.Index], updateFile)
+			updateFiles = append(updateFiles, updateFile)
+		}
+		return nil
+	})
 	if err != nil {
-		fmt.Printf("Error reading directory %s: %v\n", dirPath, err)
-		return
+		return nil, fmt.Errorf("error reading %s: %w", sourcePath, err)
 	}
 
-	// Process each file
-	for _, fileInfo := range fileInfos {
-		fullPath := filepath.Join(dirPath, fileInfo.Name())
-		localPath := filepath.Join(relPath, fileInfo.Name())
-
-		// If it's a directory, recursively process it
-		if fileInfo.IsDir() {
-			recursivePatchDir(outputFile, fullPath, localPath, fileEntries, modificationTime)
-			continue
+	// Several files for one entry, for example both a .bmp and a .cnv, are ambiguous too
+	updateFiles = slices.DeleteFunc(updateFiles, func(updateFile *updateFile) bool {
+		competing := byIndex[updateFile.entry.Index]
+		if len(competing) == 1 {
+			return false
 		}
-
-		// Check if the file is recent enough to be patched
-		hoursSinceModified := float64(0)
-		if modificationTime > 0 {
-			hoursSinceModified = float64(fileInfo.ModTime().Hour()) / 24
+		ambiguous = append(ambiguous, fmt.Sprintf("%s: %d files match entry %d (%s)",
+			updateFile.sourcePath, len(competing), updateFile.entry.Index, updateFile.entry.Name))
+		return true
+	})
+
+	fmt.Printf("Matched %d files in %s\n", len(updateFiles), sourcePath)
+	if len(unmatched) > 0 {
+		fmt.Printf("Unmatched files (not patched):\n")
+		for _, line := range unmatched {
+			fmt.Printf("   %s\n", line)
 		}
-
-		if hoursSinceModified <= modificationTime {
-			// Find matching index for this file
-			index, err := matchFileToIndex(fullPath, fileEntries)
-			if err != nil {
-				fmt.Printf("Skipping %s: %v\n", fullPath, err)
-				continue
-			}
-
-			// Update the file using the index
-			err = patchFileByIndex(outputFile, fullPath, fileEntries, index)
-			if err != nil {
-				fmt.Printf("Error patching %s: %v\n", fullPath, err)
-			} else {
-				fmt.Printf("Successfully patched %s (index: %d)\n", fullPath, index)
-			}
+	}
+	if len(ambiguous) > 0 {
+		fmt.Printf("Ambiguous files (not patched):\n")
+		for _, line := range ambiguous {
+			fmt.Printf("   %s\n", line)
 		}
 	}
+
+	return updateFiles, nil
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+
+	"BundleTools/bundle"
+)
+
+// dataUsage describes how the entries of a bundle use the data area
+type dataUsage struct {
+	size        int64 // Size of the bundle
+	packedSize  int64 // Size of the bundle with every entry stored back to back in index order
+	unusedBytes int64 // Bytes of the data area that belong to no entry
+	gaps        int   // Number of unused ranges, trailing bytes included
+	overlaps    int   // Number of entries sharing bytes with an entry stored before them
+	outOfOrder  int   // Number of entries stored before the entry with the previous index
+	misplaced   int   // Number of entries not at the offset a packed bundle would give them
+}
+
+// analyzeDataUsage looks for holes, overlapping entries and entries out of index order
+func analyzeDataUsage(fileEntries []*bundle.Entry, tableEnd int64, size int64) *dataUsage {
+	usage := &dataUsage{size: size, packedSize: tableEnd}
+
+	var previous *bundle.Entry
+	for _, entry := range fileEntries {
+		if int64(entry.Offset) != usage.packedSize {
+			usage.misplaced++
+		}
+		usage.packedSize += int64(entry.Length)
+
+		// Empty entries take no room, their offset does not matter
+		if entry.Length == 0 {
+			continue
+		}
+		if previous != nil && entry.Offset < previous.Offset {
+			usage.outOfOrder++
+		}
+		previous = entry
+	}
+
+	// Walk the entries in data order to find the unused and shared ranges
+	sorted := slices.DeleteFunc(slices.Clone(fileEntries), func(entry *bundle.Entry) bool { return entry.Length == 0 })
+	slices.SortStableFunc(sorted, func(a, b *bundle.Entry) int { return cmp.Compare(a.Offset, b.Offset) })
+
+	position := tableEnd
+	for _, entry := range sorted {
+		offset := int64(entry.Offset)
+		if offset < position {
+			usage.overlaps++
+		} else if offset > position {
+			usage.gaps++
+			usage.unusedBytes += offset - position
+		}
+		position = max(position, offset+int64(entry.Length))
+	}
+	if position < size {
+		usage.gaps++
+		usage.unusedBytes += size - position
+	}
+
+	return usage
+}
+
+// compactBundle rewrites a bundle with its entries packed in index order, dropping every unused byte
+func compactBundle(datFilePath string) error {
+	file, reader, err := openBundle(datFilePath)
+	if err != nil {
+		return err
+	}
+	fileEntries := reader.Entries()
+
+	fileInfo, err := file.Stat()
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %w", datFilePath, err)
+	}
+
+	usage := analyzeDataUsage(fileEntries, reader.Layout().TableEnd(len(fileEntries)), fileInfo.Size())
+	fmt.Printf("Found %d gaps (%d unused bytes), %d overlapping entries and %d entries out of order\n",
+		usage.gaps, usage.unusedBytes, usage.overlaps, usage.outOfOrder)
+
+	if usage.misplaced == 0 && usage.size == usage.packedSize {
+		fmt.Printf("%s is already compact, nothing to do\n", datFilePath)
+		return nil
+	}
+
+	// Every entry is encrypted again for its new offset
+	if err := rewriteBundle(datFilePath, keepEntries(fileEntries)); err != nil {
+		return err
+	}
+
+	fileInfo, err = os.Stat(datFilePath)
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %w", datFilePath, err)
+	}
+
+	saved := usage.size - fileInfo.Size()
+	if saved >= 0 {
+		fmt.Printf("Successfully compacted %s: %d -> %d bytes, %d bytes saved\n", datFilePath, usage.size, fileInfo.Size(), saved)
+	} else {
+		// Overlapping entries each get their own copy of the shared bytes
+		fmt.Printf("Successfully compacted %s: %d -> %d bytes, %d bytes added to store overlapping entries separately\n",
+			datFilePath, usage.size, fileInfo.Size(), -saved)
+	}
+	return nil
+}
@@ -1,109 +1,231 @@
 package main
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"image"
+	"image/png"
+	"math"
 )
 
-func convertImage(data *[]byte) error {
-	return convertImageToBMP(data)
+// convertImage converts CNV image data to the given format, one of the image conversion constants
+func convertImage(data *[]byte, format string) error {
+	img, err := decodeCnvImage(*data)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case conversionBmp:
+		*data = encodeBMP(img)
+	case conversionPng:
+		// PNG stores straight alpha like CNV, so the pixels are kept exactly
+		var buffer bytes.Buffer
+		if err := png.Encode(&buffer, img); err != nil {
+			return fmt.Errorf("error encoding PNG: %w", err)
+		}
+		*data = buffer.Bytes()
+	case conversionTga:
+		*data, err = encodeTGA(img)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown image format %s", format)
+	}
+	return nil
 }
 
-func convertWav(data *[]byte) error {
-	const headerSize = 22
-	if len(*data) < headerSize {
-		return errors.New("data is too short to read WAV header")
+// CNV header sizes, the image header is followed by BGRA pixels and the audio header by PCM data
+const (
+	cnvImageHeaderSize = 17
+	cnvAudioHeaderSize = 22
+)
+
+// cnvAudioHeader is the header of an audio CNV, the fields of a WAV fmt chunk followed by the data size
+type cnvAudioHeader struct {
+	audioFmt      uint16
+	nChannels     uint16
+	sampleRate    uint32
+	byteRate      uint32
+	blockAlign    uint16
+	bitsPerSample uint16
+	dataSize      uint32
+}
+
+// parseCnvAudioHeader reads and checks the header of an audio CNV, data must hold at least the header
+func parseCnvAudioHeader(data []byte) (*cnvAudioHeader, error) {
+	if len(data) < cnvAudioHeaderSize {
+		return nil, errors.New("data is too short to read WAV header")
 	}
 
 	// Unpack WAV header
-	audioFmt := binary.LittleEndian.Uint16((*data)[0:2])
-	nChannels := binary.LittleEndian.Uint16((*data)[2:4])
-	sampleRate := binary.LittleEndian.Uint32((*data)[4:8])
-	byteRate := binary.LittleEndian.Uint32((*data)[8:12])
-	blockAlign := binary.LittleEndian.Uint16((*data)[12:14])
-	bitsPerSample := binary.LittleEndian.Uint16((*data)[14:16])
-	subchunk2Size := binary.LittleEndian.Uint32((*data)[16:20])
-
-	// Check subchunk size
-	if subchunk2Size != uint32(len(*data))-headerSize {
-		fmt.Printf(" *** Warning ----: Size mismatch: %d vs %d.\n", subchunk2Size, len(*data)-headerSize)
+	header := &cnvAudioHeader{
+		audioFmt:      binary.LittleEndian.Uint16(data[0:2]),
+		nChannels:     binary.LittleEndian.Uint16(data[2:4]),
+		sampleRate:    binary.LittleEndian.Uint32(data[4:8]),
+		byteRate:      binary.LittleEndian.Uint32(data[8:12]),
+		blockAlign:    binary.LittleEndian.Uint16(data[12:14]),
+		bitsPerSample: binary.LittleEndian.Uint16(data[14:16]),
+		dataSize:      binary.LittleEndian.Uint32(data[16:20]),
 	}
 
 	// Check byte rate
-	if byteRate != (sampleRate * uint32(nChannels) * (uint32(bitsPerSample) / 8)) {
-		return fmt.Errorf("byte rate mismatch: %d vs %d", byteRate, (sampleRate * uint32(nChannels) * (uint32(bitsPerSample) / 8)))
+	expectedByteRate := header.sampleRate * uint32(header.nChannels) * (uint32(header.bitsPerSample) / 8)
+	if header.byteRate != expectedByteRate {
+		return nil, fmt.Errorf("byte rate mismatch: %d vs %d", header.byteRate, expectedByteRate)
 	}
 
 	// Check block align
-	if blockAlign != nChannels*(bitsPerSample/8) {
-		return fmt.Errorf("block align mismatch: %d vs %d", blockAlign, nChannels*(bitsPerSample/8))
+	if header.blockAlign != header.nChannels*(header.bitsPerSample/8) {
+		return nil, fmt.Errorf("block align mismatch: %d vs %d", header.blockAlign, header.nChannels*(header.bitsPerSample/8))
 	}
 
-	// Pack new WAV data
-	outData := make([]byte, 0)
+	return header, nil
+}
+
+func convertWav(data *[]byte) error {
+	const headerSize = cnvAudioHeaderSize
+	header, err := parseCnvAudioHeader(*data)
+	if err != nil {
+		return err
+	}
+
+	// Check subchunk size
+	if header.dataSize != uint32(len(*data))-headerSize {
+		fmt.Printf(" *** Warning ----: Size mismatch: %d vs %d.\n", header.dataSize, len(*data)-headerSize)
+	}
+
+	// Pack new WAV data, the canonical header is 44 bytes long
+	outData := make([]byte, 44, 44+len(*data)-headerSize)
 
 	// RIFF header
-	outData = append(outData, []byte("RIFF")...)
-	outData = append(outData, make([]byte, 4)...)
-	binary.LittleEndian.PutUint32(outData[4:], subchunk2Size+36)
-	outData = append(outData, []byte("WAVE")...)
+	copy(outData[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(outData[4:8], header.dataSize+36)
+	copy(outData[8:12], "WAVE")
 
 	// fmt subchunk
-	outData = append(outData, []byte("fmt ")...)
-	outData = append(outData, 16, 0, 0, 0) // Subchunk size
-	binary.LittleEndian.PutUint16(outData[16:], audioFmt)
-	binary.LittleEndian.PutUint16(outData[18:], nChannels)
-	binary.LittleEndian.PutUint32(outData[20:], sampleRate)
-	binary.LittleEndian.PutUint32(outData[24:], byteRate)
-	binary.LittleEndian.PutUint16(outData[28:], blockAlign)
-	binary.LittleEndian.PutUint16(outData[30:], bitsPerSample)
+	copy(outData[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(outData[16:20], 16) // Subchunk size
+	binary.LittleEndian.PutUint16(outData[20:22], header.audioFmt)
+	binary.LittleEndian.PutUint16(outData[22:24], header.nChannels)
+	binary.LittleEndian.PutUint32(outData[24:28], header.sampleRate)
+	binary.LittleEndian.PutUint32(outData[28:32], header.byteRate)
+	binary.LittleEndian.PutUint16(outData[32:34], header.blockAlign)
+	binary.LittleEndian.PutUint16(outData[34:36], header.bitsPerSample)
 
 	// Data subchunk
-	outData = append(outData, []byte("data")...)
-	binary.LittleEndian.PutUint32(outData[36:], subchunk2Size)
+	copy(outData[36:40], "data")
+	binary.LittleEndian.PutUint32(outData[40:44], header.dataSize)
 	outData = append(outData, (*data)[headerSize:]...)
 
 	*data = outData
 	return nil
 }
 
-// convertImageToBMP converts CNV image data to BMP format
-func convertImageToBMP(data *[]byte) error {
-	const headerSize = 17
-	if len(*data) < headerSize {
-		return errors.New("data is too short to read image header")
+// cnvImageHeader is the header of an image CNV
+type cnvImageHeader struct {
+	bpp    uint8
+	width  uint32
+	height uint32
+	width2 uint32 // Second width value, used as the row stride of the pixel data
+}
+
+// bytesPerPixel returns the size of a pixel, BGR for 24-bit images and BGRA for 32-bit ones
+func (h *cnvImageHeader) bytesPerPixel() int {
+	return int(h.bpp) / 8
+}
+
+// dataSize returns the size of a CNV holding the header and its pixels
+func (h *cnvImageHeader) dataSize() int {
+	return cnvImageHeaderSize + int(h.width2)*int(h.height)*h.bytesPerPixel()
+}
+
+// parseCnvImageHeader reads and checks the header of an image CNV.
+// data must hold at least the header, size is the length of the whole CNV.
+func parseCnvImageHeader(data []byte, size int) (*cnvImageHeader, error) {
+	if len(data) < cnvImageHeaderSize {
+		return nil, errors.New("data is too short to read image header")
 	}
 
 	// Unpack image header
-	bpp := (*data)[0]
-	width := binary.LittleEndian.Uint32((*data)[1:5])
-	height := binary.LittleEndian.Uint32((*data)[5:9])
-	width2 := binary.LittleEndian.Uint32((*data)[9:13])
-	zero := (*data)[headerSize-1]
-
-	// Check width consistency
-	if width != width2 {
-		fmt.Printf(" *** Warning ----: Two width values disagree: %d %d\n", width, width2)
+	header := &cnvImageHeader{
+		bpp:    data[0],
+		width:  binary.LittleEndian.Uint32(data[1:5]),
+		height: binary.LittleEndian.Uint32(data[5:9]),
+		width2: binary.LittleEndian.Uint32(data[9:13]),
 	}
+	zero := data[cnvImageHeaderSize-1]
 
 	// Check bits per pixel
-	if bpp != 24 && bpp != 32 {
-		return fmt.Errorf("BPP must be 24 or 32, not %d", bpp)
+	if header.bpp != 24 && header.bpp != 32 {
+		return nil, fmt.Errorf("BPP must be 24 or 32, not %d", header.bpp)
 	}
 
 	// Check data length consistency
-	if int(width2)*int(height)*4+headerSize != len(*data) {
-		return fmt.Errorf("data lengths disagree: %d vs %d", int(width2)*int(height)*4+headerSize, len(*data))
+	if header.dataSize() != size {
+		return nil, fmt.Errorf("data lengths disagree: %d vs %d", header.dataSize(), size)
 	}
 
 	if zero != 0 {
-		return errors.New("nonzero value in final header block")
+		return nil, errors.New("nonzero value in final header block")
 	}
 
-	// Calculate BMP parameters
-	rowSize := ((int(width)*4 + 3) / 4) * 4 // BMP rows must be padded to 4-byte boundaries
-	imageSize := rowSize * int(height)
+	return header, nil
+}
+
+// decodeCnvImage decodes the top-down BGR or BGRA pixels of CNV image data.
+// Rows are width2 pixels apart, the pixels past width are padding.
+func decodeCnvImage(data []byte) (*image.NRGBA, error) {
+	const headerSize = cnvImageHeaderSize
+	header, err := parseCnvImageHeader(data, len(data))
+	if err != nil {
+		return nil, err
+	}
+	width := int(header.width)
+	height := int(header.height)
+
+	// Check width consistency
+	if header.width != header.width2 {
+		fmt.Printf(" *** Warning ----: Two width values disagree: %d %d\n", header.width, header.width2)
+	}
+	if header.width > header.width2 {
+		return nil, fmt.Errorf("width %d is larger than the row stride %d", header.width, header.width2)
+	}
+
+	bytesPerPixel := header.bytesPerPixel()
+	stride := int(header.width2) * bytesPerPixel
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for r := 0; r < height; r++ {
+		for c := 0; c < width; c++ {
+			srcPos := headerSize + r*stride + c*bytesPerPixel
+			if srcPos+bytesPerPixel > len(data) {
+				return nil, errors.New("data overflow while reading pixels")
+			}
+
+			dstPos := img.PixOffset(c, r)
+			img.Pix[dstPos] = data[srcPos+2]   // R
+			img.Pix[dstPos+1] = data[srcPos+1] // G
+			img.Pix[dstPos+2] = data[srcPos]   // B
+			img.Pix[dstPos+3] = 255            // 24-bit images are opaque
+			if bytesPerPixel == 4 {
+				img.Pix[dstPos+3] = data[srcPos+3] // A
+			}
+		}
+	}
+	return img, nil
+}
+
+// encodeBMP encodes an image as a 32-bit BI_RGB BMP
+func encodeBMP(img *image.NRGBA) []byte {
+	width := img.Rect.Dx()
+	height := img.Rect.Dy()
+
+	// Calculate BMP parameters, rows of 4 bytes per pixel are always padded to 4-byte boundaries
+	imageSize := width * 4 * height
 	fileSize := 54 + imageSize // 54 bytes for BMP header + image data
 
 	// Create BMP header (54 bytes total)
@@ -129,31 +251,50 @@ func convertImageToBMP(data *[]byte) error {
 	binary.LittleEndian.PutUint32(bmpData[46:50], 0)                 // Colors used
 	binary.LittleEndian.PutUint32(bmpData[50:54], 0)                 // Important colors
 
-	// Convert pixel data (CNV is BGRA, BMP expects BGRA but bottom-up)
+	// Convert pixel data (BMP expects BGRA bottom-up)
 	pixelIndex := 54
-	for r := int(height) - 1; r >= 0; r-- { // BMP is bottom-up
-		for c := 0; c < int(width); c++ {
-			srcPos := headerSize + 4*(r*int(width)+c)
-			if srcPos+4 > len(*data) {
-				return errors.New("data overflow while reading pixels")
-			}
-
-			// Copy BGRA directly (CNV and BMP both use BGRA)
-			bmpData[pixelIndex] = (*data)[srcPos]     // B
-			bmpData[pixelIndex+1] = (*data)[srcPos+1] // G
-			bmpData[pixelIndex+2] = (*data)[srcPos+2] // R
-			bmpData[pixelIndex+3] = (*data)[srcPos+3] // A
+	for r := height - 1; r >= 0; r-- { // BMP is bottom-up
+		for c := 0; c < width; c++ {
+			srcPos := img.PixOffset(c, r)
+			bmpData[pixelIndex] = img.Pix[srcPos+2]   // B
+			bmpData[pixelIndex+1] = img.Pix[srcPos+1] // G
+			bmpData[pixelIndex+2] = img.Pix[srcPos]   // R
+			bmpData[pixelIndex+3] = img.Pix[srcPos+3] // A
 			pixelIndex += 4
 		}
+	}
+	return bmpData
+}
 
-		// Add padding to reach 4-byte boundary
-		for pad := int(width) * 4; pad < rowSize; pad++ {
-			if pixelIndex < len(bmpData) {
-				bmpData[pixelIndex] = 0
-				pixelIndex++
-			}
+// encodeTGA encodes an image as an uncompressed 32-bit top-down TGA with straight alpha
+func encodeTGA(img *image.NRGBA) ([]byte, error) {
+	const headerSize = 18
+	width := img.Rect.Dx()
+	height := img.Rect.Dy()
+	if width > math.MaxUint16 || height > math.MaxUint16 {
+		return nil, fmt.Errorf("image is too large for TGA: %dx%d", width, height)
+	}
+
+	tgaData := make([]byte, headerSize+4*width*height)
+
+	// TGA header (18 bytes)
+	tgaData[2] = 2                                                // Image type (2 = uncompressed true color)
+	binary.LittleEndian.PutUint16(tgaData[12:14], uint16(width))  // Width
+	binary.LittleEndian.PutUint16(tgaData[14:16], uint16(height)) // Height
+	tgaData[16] = 32                                              // Bits per pixel
+	tgaData[17] = 0x28                                            // 8 alpha bits, top-left origin
+
+	// TGA stores BGRA like CNV
+	pixelIndex := headerSize
+	for r := 0; r < height; r++ {
+		for c := 0; c < width; c++ {
+			srcPos := img.PixOffset(c, r)
+			tgaData[pixelIndex] = img.Pix[srcPos+2]   // B
+			tgaData[pixelIndex+1] = img.Pix[srcPos+1] // G
+			tgaData[pixelIndex+2] = img.Pix[srcPos]   // R
+			tgaData[pixelIndex+3] = img.Pix[srcPos+3] // A
+			pixelIndex += 4
 		}
 	}
-	*data = bmpData
-	return nil
+	return tgaData, nil
 }
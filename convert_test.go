@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createTestCnvImage builds an image CNV with patterned pixels and zero padding past width
+func createTestCnvImage(bpp uint8, width, height, width2 int) []byte {
+	bytesPerPixel := int(bpp) / 8
+	data := make([]byte, cnvImageHeaderSize+width2*height*bytesPerPixel)
+	data[0] = bpp
+	binary.LittleEndian.PutUint32(data[1:5], uint32(width))
+	binary.LittleEndian.PutUint32(data[5:9], uint32(height))
+	binary.LittleEndian.PutUint32(data[9:13], uint32(width2))
+
+	for y := range height {
+		for x := range width * bytesPerPixel {
+			// Translucent pixels with any color check that alpha is not premultiplied
+			data[cnvImageHeaderSize+y*width2*bytesPerPixel+x] = byte(y*53 + x*37 + 11)
+		}
+	}
+	return data
+}
+
+func TestImageRoundTrip(t *testing.T) {
+	names := []string{`image\rgb.cnv`, `image\rgba.cnv`}
+	contents := [][]byte{
+		createTestCnvImage(24, 3, 2, 4),
+		createTestCnvImage(32, 3, 2, 3),
+	}
+
+	for _, format := range imageConversions {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			datFilePath := filepath.Join(dir, "test.dat")
+			createTestBundle(t, datFilePath, names, contents)
+			original, err := os.ReadFile(datFilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			extractPath := filepath.Join(dir, "extracted")
+			if err := extractBundle(datFilePath, extractPath, ".", conversionOptions{imageFormat: format}); err != nil {
+				t.Fatal(err)
+			}
+
+			// -update uses the entries it replaces as templates
+			patchBundle(datFilePath, extractPath)
+			checkBundleContents(t, datFilePath, names, contents)
+
+			// -pack only has the headers recorded in the manifest
+			packedPath := filepath.Join(dir, "packed.dat")
+			if err := packBundle(extractPath, packedPath, "", conversionOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			packed, err := os.ReadFile(packedPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(packed, original) {
+				t.Errorf("packed bundle differs from the original")
+			}
+		})
+	}
+}
+
+func TestWavRoundTrip(t *testing.T) {
+	// 16-bit stereo PCM at 22050 Hz
+	audio := make([]byte, cnvAudioHeaderSize, cnvAudioHeaderSize+16)
+	audio[0] = 1
+	binary.LittleEndian.PutUint16(audio[2:4], 2)
+	binary.LittleEndian.PutUint32(audio[4:8], 22050)
+	binary.LittleEndian.PutUint32(audio[8:12], 22050*4)
+	binary.LittleEndian.PutUint16(audio[12:14], 4)
+	binary.LittleEndian.PutUint16(audio[14:16], 16)
+	binary.LittleEndian.PutUint32(audio[16:20], 16)
+	// The last header bytes are not in the WAV file
+	audio[20], audio[21] = 0x5a, 0xa5
+	audio = append(audio, []byte("0123456789abcdef")...)
+
+	dir := t.TempDir()
+	datFilePath := filepath.Join(dir, "test.dat")
+	names := []string{`se\click.cnv`}
+	contents := [][]byte{audio}
+	createTestBundle(t, datFilePath, names, contents)
+
+	wavPath := filepath.Join(dir, "click.wav")
+	if err := extractSingleFile(datFilePath, 0, filepath.Join(dir, "click.cnv"), conversionOptions{imageFormat: conversionBmp}); err != nil {
+		t.Fatal(err)
+	}
+
+	// -single-patch with the extracted WAV stores the original audio CNV again
+	if err := patchSingleFile(datFilePath, wavPath, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkBundleContents(t, datFilePath, names, contents)
+}
+
+// createTestWav builds a RIFF WAVE file with a fmt chunk of the given format tag and size
+func createTestWav(formatTag uint16, fmtSize int) []byte {
+	fmtChunk := make([]byte, fmtSize)
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], formatTag)
+	binary.LittleEndian.PutUint16(fmtChunk[2:4], 1)
+	binary.LittleEndian.PutUint32(fmtChunk[4:8], 22050)
+
+	data := []byte("RIFF\x00\x00\x00\x00WAVEfmt ")
+	data = binary.LittleEndian.AppendUint32(data, uint32(fmtSize))
+	data = append(data, fmtChunk...)
+	data = append(data, "data\x04\x00\x00\x000123"...)
+	binary.LittleEndian.PutUint32(data[4:8], uint32(len(data)-8))
+	return data
+}
+
+func TestWavToCnvFormats(t *testing.T) {
+	if _, err := wavToCnv(createTestWav(1, 16), nil); err != nil {
+		t.Errorf("PCM: %v", err)
+	}
+	for name, wav := range map[string][]byte{
+		"extensible": createTestWav(0xFFFE, 40),
+		"float":      createTestWav(3, 18),
+		"short fmt":  createTestWav(1, 14),
+	} {
+		if _, err := wavToCnv(wav, nil); err == nil {
+			t.Errorf("%s: converted to CNV audio", name)
+		}
+	}
+}
+
+func TestPackModelWithoutManifest(t *testing.T) {
+	dir := t.TempDir()
+	extractPath := filepath.Join(dir, "extracted")
+	names := []string{`chara\broken.x`}
+	// Models are stored as they are without -text-models, even the ones Direct3D could not read
+	contents := [][]byte{[]byte("xof 0303txt 0032\nFrame Root {\n")}
+	if err := os.MkdirAll(filepath.Join(extractPath, "chara"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(extractPath, "chara", "broken.x"), contents[0], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	packedPath := filepath.Join(dir, "packed.dat")
+	if err := packBundle(extractPath, packedPath, "", conversionOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkBundleContents(t, packedPath, names, contents)
+
+	// With -text-models they are checked
+	if err := packBundle(extractPath, packedPath, "", conversionOptions{textModels: true}); err == nil {
+		t.Error("packed a broken text model with text models enabled")
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+)
+
+// Converter turns the entries of a stored format into files editors understand, and back.
+// Extraction, patching, packing and the round trip check all look converters up in converters.
+type Converter interface {
+	// Name is the conversion recorded in extraction manifests
+	Name() string
+	// Extension is the extension of converted files, with the dot
+	Extension() string
+	// StoredExtension is the extension of the entries handled, with the dot
+	StoredExtension() string
+	// Detect reports whether an entry is handled from its first bytes, header holds up to converterHeaderSize bytes
+	Detect(header []byte) bool
+	// Decode converts the data of an entry to the external format
+	Decode(data []byte) ([]byte, error)
+	// Encode converts external data back to the stored format. template is the entry being
+	// replaced, or the first TemplateSize bytes of it, nil for new entries.
+	Encode(data, template []byte) ([]byte, error)
+}
+
+// templateConverter is implemented by converters encoding back with the header of the entry they replace
+type templateConverter interface {
+	// TemplateSize is the number of bytes of the original entry recorded for Encode
+	TemplateSize() int
+}
+
+// replacementChecker is implemented by converters warning about files that do not fit the entry they replace
+type replacementChecker interface {
+	// CheckReplacement returns warnings about data replacing original, names is the name encoding of the bundle
+	CheckReplacement(data, original []byte, names encoding.Encoding) []string
+}
+
+// lossyConverter is implemented by converters that do not store decoded files back as the original entry
+type lossyConverter interface {
+	// Lossy reports whether Encode keeps files in their converted form
+	Lossy() bool
+}
+
+// optionalConverter is implemented by converters only applied when the conversion options ask for them
+type optionalConverter interface {
+	// Enabled reports whether options turn the converter on
+	Enabled(options conversionOptions) bool
+}
+
+// conversionOptions select the converters applied on extraction and packing
+type conversionOptions struct {
+	imageFormat string // Format image CNVs are converted to, one of imageConversions
+	textModels  bool   // Decode binary and compressed .x models to text, set with -text-models
+}
+
+// replacementOptions turn every converter on, files replacing entries are always converted back and checked
+var replacementOptions = conversionOptions{textModels: true}
+
+// enables reports whether options apply converter, converters that are not optional always apply
+func (options conversionOptions) enables(converter Converter) bool {
+	if optional, ok := converter.(optionalConverter); ok {
+		return optional.Enabled(options)
+	}
+	return true
+}
+
+// converterHeaderSize is the number of bytes passed to Detect
+const converterHeaderSize = 16
+
+// converters are the registered converters. When several converters detect an entry the
+// first one wins, unless another one matches the requested image format, so catch-all
+// converters like the .unknown one come last.
+var converters = []Converter{
+	cnvAudioConverter{},
+	cnvImageConverter{format: conversionBmp},
+	cnvImageConverter{format: conversionPng},
+	cnvImageConverter{format: conversionTga},
+	sflConverter{},
+	xTextConverter{},
+	rawConverter{name: conversionUnknown, extension: ".unknown", storedExtension: ".cnv"},
+}
+
+// lowerExt returns the lowercase extension of an entry name or file path
+func lowerExt(name string) string {
+	return strings.ToLower(entryExt(name))
+}
+
+// converterByName returns the converter recorded in a manifest under name, nil when there is none
+func converterByName(name string) Converter {
+	for _, converter := range converters {
+		if converter.Name() == name {
+			return converter
+		}
+	}
+	return nil
+}
+
+// converterByExtension returns the converter enabled by options writing files with the extension of filePath,
+// nil when there is none. Extensions may be double ones like .sfl.json.
+func converterByExtension(filePath string, options conversionOptions) Converter {
+	name := strings.ToLower(filePath)
+	for _, converter := range converters {
+		if options.enables(converter) && strings.HasSuffix(name, converter.Extension()) {
+			return converter
+		}
+	}
+	return nil
+}
+
+// detectConverter returns the converter enabled by options handling an entry from its name and first bytes,
+// nil when it is stored as is. The converter of the image format of options wins over the others detecting the entry.
+func detectConverter(entryName string, header []byte, options conversionOptions) Converter {
+	var detected Converter
+	ext := lowerExt(entryName)
+	for _, converter := range converters {
+		if converter.StoredExtension() != ext || !options.enables(converter) || !converter.Detect(header) {
+			continue
+		}
+		if converter.Name() == options.imageFormat {
+			return converter
+		}
+		if detected == nil {
+			detected = converter
+		}
+	}
+	return detected
+}
+
+// replacementConverter returns the converter turning filePath back into the format of an entry, nil when
+// the file is stored as is
+func replacementConverter(entryName, filePath string) Converter {
+	converter := converterByExtension(filePath, replacementOptions)
+	if converter == nil || converter.StoredExtension() != lowerExt(entryName) {
+		return nil
+	}
+	return converter
+}
+
+// convertedExtensions returns the extensions of the files entries with storedExtension are converted to,
+// in lookup order. An empty storedExtension returns the extensions of every converter.
+func convertedExtensions(storedExtension string) []string {
+	var extensions []string
+	for _, converter := range converters {
+		if storedExtension == "" || converter.StoredExtension() == storedExtension {
+			extensions = append(extensions, converter.Extension())
+		}
+	}
+	return extensions
+}
+
+// converterTemplateSize returns the number of bytes of the original entry a converter needs to encode back, 0 for none
+func converterTemplateSize(converter Converter) int {
+	if templater, ok := converter.(templateConverter); ok {
+		return templater.TemplateSize()
+	}
+	return 0
+}
+
+// isLossyConverter reports whether a converter stores files back in another form than the entries it decoded
+func isLossyConverter(converter Converter) bool {
+	lossy, ok := converter.(lossyConverter)
+	return ok && lossy.Lossy()
+}
+
+// isRawConverter reports whether a converter stores data as it is
+func isRawConverter(converter Converter) bool {
+	_, ok := converter.(rawConverter)
+	return ok
+}
+
+// cnvAudioConverter converts audio CNVs to RIFF WAV
+type cnvAudioConverter struct{}
+
+func (cnvAudioConverter) Name() string            { return conversionWav }
+func (cnvAudioConverter) Extension() string       { return ".wav" }
+func (cnvAudioConverter) StoredExtension() string { return ".cnv" }
+func (cnvAudioConverter) TemplateSize() int       { return cnvAudioHeaderSize }
+
+func (cnvAudioConverter) Detect(header []byte) bool {
+	return len(header) > 0 && header[0] == 1
+}
+
+func (cnvAudioConverter) Decode(data []byte) ([]byte, error) {
+	converted := bytes.Clone(data)
+	if err := convertWav(&converted); err != nil {
+		return nil, err
+	}
+	return converted, nil
+}
+
+func (cnvAudioConverter) Encode(data, template []byte) ([]byte, error) {
+	return wavToCnv(data, template)
+}
+
+// cnvImageConverter converts 24-bit and 32-bit image CNVs to BMP, PNG or TGA
+type cnvImageConverter struct {
+	format string // One of imageConversions
+}
+
+func (c cnvImageConverter) Name() string          { return c.format }
+func (c cnvImageConverter) Extension() string     { return "." + c.format }
+func (cnvImageConverter) StoredExtension() string { return ".cnv" }
+func (cnvImageConverter) TemplateSize() int       { return cnvImageHeaderSize }
+
+func (cnvImageConverter) Detect(header []byte) bool {
+	return len(header) > 0 && (header[0] == 24 || header[0] == 32)
+}
+
+func (c cnvImageConverter) Decode(data []byte) ([]byte, error) {
+	converted := bytes.Clone(data)
+	if err := convertImage(&converted, c.format); err != nil {
+		return nil, err
+	}
+	return converted, nil
+}
+
+func (c cnvImageConverter) Encode(data, template []byte) ([]byte, error) {
+	format := strings.ToUpper(c.format)
+	fmt.Printf("Converting %s image to CNV format\n", format)
+
+	img, err := decodeImage(c.format, data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s image: %w", format, err)
+	}
+
+	cnvData := encodeCnvImage(img, template)
+	fmt.Printf("Successfully converted image: %dx%d pixels, %d bpp, %d bytes\n",
+		img.Bounds().Dx(), img.Bounds().Dy(), cnvData[0], len(cnvData))
+	return cnvData, nil
+}
+
+// rawConverter only renames entries, it catches those no other converter handles
+type rawConverter struct {
+	name            string
+	extension       string
+	storedExtension string
+}
+
+func (c rawConverter) Name() string            { return c.name }
+func (c rawConverter) Extension() string       { return c.extension }
+func (c rawConverter) StoredExtension() string { return c.storedExtension }
+func (rawConverter) Detect(header []byte) bool { return true }
+
+func (rawConverter) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (rawConverter) Encode(data, template []byte) ([]byte, error) {
+	return data, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"BundleTools/bundle"
+	"BundleTools/xfile"
+)
+
+// dependencyReport lists the textures the models of a bundle refer to
+type dependencyReport struct {
+	Bundle     string               `json:"bundle"`     // Path of the bundle
+	References int                  `json:"references"` // Number of texture references of all models
+	Missing    int                  `json:"missing"`    // Number of references without CNV entry
+	Models     []*modelDependencies `json:"models"`
+	Orphaned   []*entryReference    `json:"orphaned"`   // Textures no model refers to
+	Unreadable []*unreadableEntry   `json:"unreadable"` // CNV entries whose header cannot be read when looking for orphans
+}
+
+// modelDependencies are the textures of a .x entry
+type modelDependencies struct {
+	Index    int                  `json:"index"`
+	Name     string               `json:"name"`
+	Error    string               `json:"error,omitempty"` // Set when the model cannot be read
+	Textures []*textureDependency `json:"textures"`
+}
+
+// textureDependency is a TextureFilename of a model and the CNV entry it resolves to
+type textureDependency struct {
+	Reference string          `json:"reference"`       // Texture file name as written in the model
+	Entry     *entryReference `json:"entry,omitempty"` // nil when the texture is missing
+}
+
+// entryReference identifies an entry of the bundle by its index and name
+type entryReference struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+}
+
+// unreadableEntry is an entry that could not be read while looking for orphans, with the error
+type unreadableEntry struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// bundleDependencies reads the TextureFilename of every model of a bundle and resolves them to CNV entries
+// the way -export-model does. Image CNVs that no model refers to, in the directories holding the textures
+// of models, are reported as orphaned: the other images, like the ones of the interface, are not textures.
+func bundleDependencies(bundlePath string) (*dependencyReport, error) {
+	file, reader, err := openBundle(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fileEntries := reader.Entries()
+	names := reader.Layout().NameEncoding
+	report := &dependencyReport{Bundle: bundlePath, Models: []*modelDependencies{}, Orphaned: []*entryReference{},
+		Unreadable: []*unreadableEntry{}}
+	referenced := make(map[int]bool)
+	textureDirs := make(map[string]bool)
+
+	for _, entry := range fileEntries {
+		if lowerExt(entry.Name) != ".x" {
+			continue
+		}
+		model := &modelDependencies{Index: entry.Index, Name: entry.Name, Textures: []*textureDependency{}}
+		report.Models = append(report.Models, model)
+
+		textures, err := modelTextures(reader, entry)
+		if err != nil {
+			model.Error = err.Error()
+			continue
+		}
+		for _, textureName := range textures {
+			dependency := &textureDependency{Reference: decodeModelText(names, textureName)}
+			model.Textures = append(model.Textures, dependency)
+			report.References++
+
+			texture := textureEntry(fileEntries, entry.Name, dependency.Reference)
+			if texture == nil {
+				report.Missing++
+				continue
+			}
+			dependency.Entry = &entryReference{Index: texture.Index, Name: texture.Name}
+			referenced[texture.Index] = true
+			textureDirs[strings.ToLower(entryDir(texture.Name))] = true
+		}
+	}
+
+	for _, entry := range fileEntries {
+		if lowerExt(entry.Name) != ".cnv" || referenced[entry.Index] || !textureDirs[strings.ToLower(entryDir(entry.Name))] {
+			continue
+		}
+		header, err := readEntryHeader(reader, entry, converterHeaderSize)
+		if err != nil {
+			// Like unreadable models, a broken entry does not keep the others from being reported
+			report.Unreadable = append(report.Unreadable, &unreadableEntry{Index: entry.Index, Name: entry.Name, Error: err.Error()})
+			continue
+		}
+		if (cnvImageConverter{}).Detect(header) {
+			report.Orphaned = append(report.Orphaned, &entryReference{Index: entry.Index, Name: entry.Name})
+		}
+	}
+	return report, nil
+}
+
+// modelTextures returns the texture file names of the materials of a model, each one once in order of use
+func modelTextures(reader *bundle.Reader, entry *bundle.Entry) ([]string, error) {
+	data, err := readEntry(reader, entry)
+	if err != nil {
+		return nil, err
+	}
+	model, err := xfile.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	scene, err := model.Scene()
+	if err != nil {
+		return nil, err
+	}
+
+	var textures []string
+	seen := make(map[string]bool)
+	for _, material := range sceneMaterials(scene) {
+		if material.TextureFilename != "" && !seen[material.TextureFilename] {
+			seen[material.TextureFilename] = true
+			textures = append(textures, material.TextureFilename)
+		}
+	}
+	return textures, nil
+}
+
+// printDependencyReport prints the missing and orphaned textures of a dependency report for humans
+func printDependencyReport(report *dependencyReport) {
+	// Models and textures that cannot be read are both listed as unreadable
+	unreadable := len(report.Unreadable)
+	for _, model := range report.Models {
+		if model.Error != "" {
+			unreadable++
+		}
+	}
+	fmt.Printf("Read %d models of %s: %d texture references, %d missing, %d orphaned textures, %d unreadable\n",
+		len(report.Models), report.Bundle, report.References, report.Missing, len(report.Orphaned), unreadable)
+	for _, model := range report.Models {
+		if model.Error != "" {
+			fmt.Printf("   unreadable: index %d (%s): %s\n", model.Index, model.Name, model.Error)
+		}
+		for _, texture := range model.Textures {
+			if texture.Entry == nil {
+				fmt.Printf("   missing: index %d (%s): %s\n", model.Index, model.Name, texture.Reference)
+			}
+		}
+	}
+	for _, orphan := range report.Orphaned {
+		fmt.Printf("   orphaned: index %d (%s)\n", orphan.Index, orphan.Name)
+	}
+	for _, unreadable := range report.Unreadable {
+		fmt.Printf("   unreadable: index %d (%s): %s\n", unreadable.Index, unreadable.Name, unreadable.Error)
+	}
+}
+
+// writeDependencyReportJSON writes a dependency report as JSON
+func writeDependencyReportJSON(w io.Writer, report *dependencyReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// dotQuoter escapes the names written between quotes in DOT graphs
+var dotQuoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// writeDependencyGraph writes a dependency report as a Graphviz DOT graph with an edge from every model to its
+// textures. Missing textures are red and dashed, orphaned ones gray.
+func writeDependencyGraph(w io.Writer, report *dependencyReport) error {
+	var b strings.Builder
+	b.WriteString("digraph dependencies {\n  rankdir=LR;\n  node [shape=box];\n")
+
+	textures := make(map[int]bool)
+	for i, model := range report.Models {
+		fmt.Fprintf(&b, "  e%d [label=\"%s\"];\n", model.Index, dotQuoter.Replace(model.Name))
+		for j, texture := range model.Textures {
+			if texture.Entry == nil {
+				fmt.Fprintf(&b, "  missing%d_%d [label=\"%s\", shape=ellipse, style=dashed, color=red];\n", i, j, dotQuoter.Replace(texture.Reference))
+				fmt.Fprintf(&b, "  e%d -> missing%d_%d [style=dashed, color=red];\n", model.Index, i, j)
+				continue
+			}
+			if !textures[texture.Entry.Index] {
+				textures[texture.Entry.Index] = true
+				fmt.Fprintf(&b, "  e%d [label=\"%s\", shape=ellipse];\n", texture.Entry.Index, dotQuoter.Replace(texture.Entry.Name))
+			}
+			fmt.Fprintf(&b, "  e%d -> e%d;\n", model.Index, texture.Entry.Index)
+		}
+	}
+	for _, orphan := range report.Orphaned {
+		fmt.Fprintf(&b, "  e%d [label=\"%s\", shape=ellipse, color=gray, fontcolor=gray];\n", orphan.Index, dotQuoter.Replace(orphan.Name))
+	}
+
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
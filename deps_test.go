@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundleDependencies(t *testing.T) {
+	datFilePath := filepath.Join(t.TempDir(), "test.dat")
+	names := []string{`chara\model.x`, `chara\other.x`, `texture\face.cnv`, `texture\unused.cnv`, `ui\button.cnv`}
+	contents := [][]byte{
+		[]byte(testModel),
+		[]byte(strings.ReplaceAll(testModel, "Face.bmp", "Hair.bmp")),
+		createTestCnvImage(32, 2, 2, 2),
+		createTestCnvImage(24, 2, 2, 2),
+		createTestCnvImage(24, 2, 2, 2),
+	}
+	createTestBundle(t, datFilePath, names, contents)
+
+	report, err := bundleDependencies(datFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Models) != 2 || report.References != 2 || report.Missing != 1 {
+		t.Fatalf("got %d models, %d references, %d missing", len(report.Models), report.References, report.Missing)
+	}
+
+	// Textures resolve to their CNV by base name, the interface image is not a texture
+	if entry := report.Models[0].Textures[0].Entry; entry == nil || entry.Name != `texture\face.cnv` {
+		t.Errorf("got texture entry %+v, want texture\\face.cnv", entry)
+	}
+	if texture := report.Models[1].Textures[0]; texture.Entry != nil || !strings.HasSuffix(texture.Reference, "Hair.bmp") {
+		t.Errorf("got texture %+v, want the missing Hair.bmp", texture)
+	}
+	if len(report.Orphaned) != 1 || report.Orphaned[0].Name != `texture\unused.cnv` {
+		t.Errorf("got orphaned textures %+v, want texture\\unused.cnv", report.Orphaned)
+	}
+
+	var graph strings.Builder
+	if err := writeDependencyGraph(&graph, report); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"e0 -> e2;", "e1 -> missing1_0", `label="texture\\unused.cnv"`} {
+		if !strings.Contains(graph.String(), want) {
+			t.Errorf("graph has no %q:\n%s", want, graph.String())
+		}
+	}
+}
+
+func TestBundleDependenciesUnreadable(t *testing.T) {
+	datFilePath := filepath.Join(t.TempDir(), "test.dat")
+	names := []string{`chara\model.x`, `texture\face.cnv`, `bgm\title.ogg`, `data\script.txt`, `texture\broken.cnv`}
+	contents := [][]byte{
+		[]byte(testModel),
+		createTestCnvImage(32, 2, 2, 2),
+		[]byte("OggS"),
+		[]byte("script"),
+		createTestCnvImage(24, 2, 2, 2),
+	}
+	createTestBundle(t, datFilePath, names, contents)
+
+	// The last entry now ends past the end of the bundle, the others still let the table seed be detected
+	info, err := os.Stat(datFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(datFilePath, info.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := bundleDependencies(datFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Unreadable) != 1 || report.Unreadable[0].Index != 4 || report.Unreadable[0].Error == "" {
+		t.Errorf("got unreadable entries %+v, want texture\\broken.cnv", report.Unreadable)
+	}
+	if len(report.Models) != 1 || report.Models[0].Textures[0].Entry == nil || len(report.Orphaned) != 0 {
+		t.Errorf("got models %+v and orphaned textures %+v", report.Models, report.Orphaned)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"BundleTools/bundle"
+)
+
+// detectBundle prints the most plausible table seeds for the layout of a bundle and checks the payload key
+// against the magics of the entries opened with the detected seed
+func detectBundle(bundlePath string) error {
+	file, reader, err := openBundle(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %w", bundlePath, err)
+	}
+
+	scores, err := reader.Layout().ScoreSeeds(file, fileInfo.Size())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Best table seeds:")
+	for _, score := range scores[:5] {
+		fmt.Printf("   seed: %d, score: %.2f (%d/%d names, %d/%d offsets)\n",
+			score.Seed, score.Score(), score.Names, score.Entries, score.Bounds, score.Entries)
+	}
+	fmt.Printf("Using the %s layout with table seed %d\n", reader.Layout().Name, reader.Seed())
+
+	checked, matched, err := bundle.CheckPayloads(reader)
+	if err != nil {
+		return err
+	}
+	if checked == 0 {
+		fmt.Println("No entry with a known format, the payload key could not be checked")
+		return nil
+	}
+
+	fmt.Printf("%d/%d entries with a known format start with the expected magic\n", matched, checked)
+	if matched*2 < checked {
+		fmt.Println("Warning: the payload key does not seem to match this bundle")
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"BundleTools/bundle"
+)
+
+// loadEntriesForEdit reads the file table and the layout of a bundle for an operation that changes it
+func loadEntriesForEdit(datFilePath string) ([]*bundle.Entry, *bundle.Layout, error) {
+	file, reader, err := openBundle(datFilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	return reader.Entries(), reader.Layout(), nil
+}
+
+// entryExt returns the extension of an entry name, which may use backslashes as separators
+func entryExt(name string) string {
+	return path.Ext(strings.ReplaceAll(name, `\`, "/"))
+}
+
+// validateEntryName checks that a name can be stored in the file table and is not used by another entry
+func validateEntryName(fileEntries []*bundle.Entry, layout *bundle.Layout, name string, ignoreIndex int) error {
+	if name == "" {
+		return fmt.Errorf("entry name must not be empty")
+	}
+	if _, err := layout.EncodeName(name); err != nil {
+		return err
+	}
+
+	for _, entry := range fileEntries {
+		if entry.Index != ignoreIndex && normalizeEntryPath(entry.Name) == normalizeEntryPath(name) {
+			return fmt.Errorf("entry %d is already named %s", entry.Index, entry.Name)
+		}
+	}
+	return nil
+}
+
+// addEntry appends a new entry holding the contents of inputFilePath to the bundle
+func addEntry(datFilePath string, inputFilePath string, entryName string) error {
+	fileEntries, layout, err := loadEntriesForEdit(datFilePath)
+	if err != nil {
+		return err
+	}
+
+	// Validate everything before touching the bundle
+	if err := validateEntryName(fileEntries, layout, entryName, -1); err != nil {
+		return err
+	}
+	if len(fileEntries) >= layout.MaxEntries() {
+		return fmt.Errorf("%w: %s already holds %d entries", bundle.ErrTooManyEntries, datFilePath, len(fileEntries))
+	}
+
+	newEntry := &bundle.Entry{Index: len(fileEntries), Name: entryName}
+	data, err := loadReplacementData(inputFilePath, newEntry, nil)
+	if err != nil {
+		return err
+	}
+
+	items := append(keepEntries(fileEntries), &rewriteItem{name: entryName, data: data})
+	if err := rewriteBundle(datFilePath, items); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully added %s as index %d (%d bytes) in %s\n", entryName, newEntry.Index, len(data), datFilePath)
+	return nil
+}
+
+// removeEntry drops an entry from the bundle, the following entries move down by one index
+func removeEntry(datFilePath string, targetIndex int) error {
+	fileEntries, _, err := loadEntriesForEdit(datFilePath)
+	if err != nil {
+		return err
+	}
+
+	if targetIndex < 0 || targetIndex >= len(fileEntries) {
+		return fmt.Errorf("invalid file index: %d (valid range: 0-%d)", targetIndex, len(fileEntries)-1)
+	}
+	removed := fileEntries[targetIndex]
+
+	items := slices.Delete(keepEntries(fileEntries), targetIndex, targetIndex+1)
+	if err := rewriteBundle(datFilePath, items); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully removed index %d (%s, %d bytes) from %s\n", targetIndex, removed.Name, removed.Length, datFilePath)
+	return nil
+}
+
+// renameEntry changes the name of an entry in the file table
+func renameEntry(datFilePath string, targetIndex int, newName string) error {
+	fileEntries, layout, err := loadEntriesForEdit(datFilePath)
+	if err != nil {
+		return err
+	}
+
+	if targetIndex < 0 || targetIndex >= len(fileEntries) {
+		return fmt.Errorf("invalid file index: %d (valid range: 0-%d)", targetIndex, len(fileEntries)-1)
+	}
+	if err := validateEntryName(fileEntries, layout, newName, targetIndex); err != nil {
+		return err
+	}
+
+	oldName := fileEntries[targetIndex].Name
+	if !strings.EqualFold(entryExt(oldName), entryExt(newName)) {
+		fmt.Printf("Warning: renaming changes the extension of %s, the game may not load it anymore\n", oldName)
+	}
+
+	items := keepEntries(fileEntries)
+	items[targetIndex].name = newName
+	if err := rewriteBundle(datFilePath, items); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully renamed index %d from %s to %s in %s\n", targetIndex, oldName, newName, datFilePath)
+	return nil
+}
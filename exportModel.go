@@ -0,0 +1,689 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"BundleTools/bundle"
+	"BundleTools/gltf"
+	"BundleTools/xfile"
+
+	"golang.org/x/text/encoding"
+)
+
+// gltfGenerator names the tool in the glTF documents written
+const gltfGenerator = "BundleTools"
+
+// modelTexture is a texture of a model exported as a PNG file next to the glTF document
+type modelTexture struct {
+	uri   string // Name of the PNG file
+	alpha bool   // Whether the CNV has an alpha channel
+}
+
+// findEntry returns the entry with the index or the name given on the command line
+func findEntry(reader *bundle.Reader, indexOrName string) (*bundle.Entry, error) {
+	fileEntries := reader.Entries()
+	if index, err := strconv.Atoi(indexOrName); err == nil {
+		if index < 0 || index >= len(fileEntries) {
+			return nil, fmt.Errorf("invalid file index: %d (valid range: 0-%d)", index, len(fileEntries)-1)
+		}
+		return fileEntries[index], nil
+	}
+
+	entry, ok := reader.Lookup(indexOrName)
+	if !ok {
+		return nil, fmt.Errorf("no entry named %s", indexOrName)
+	}
+	return entry, nil
+}
+
+// exportModel converts a .x model of a bundle to glTF. Its textures are written as PNG files next to outputPath.
+func exportModel(bundlePath, indexOrName, outputPath string) error {
+	file, reader, err := openBundle(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entry, err := findEntry(reader, indexOrName)
+	if err != nil {
+		return err
+	}
+	if lowerExt(entry.Name) != ".x" {
+		return fmt.Errorf("%s is not a .x model", entry.Name)
+	}
+
+	data, err := readEntry(reader, entry)
+	if err != nil {
+		return err
+	}
+	model, err := xfile.Parse(data)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", entry.Name, err)
+	}
+	scene, err := model.Scene()
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", entry.Name, err)
+	}
+
+	names := reader.Layout().NameEncoding
+	textures, err := exportModelTextures(reader, entry, scene, names, filepath.Dir(outputPath))
+	if err != nil {
+		return err
+	}
+
+	exporter := newGLTFExporter(names, textures)
+	document := exporter.export(scene)
+	if err := document.Write(outputPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %s to %s: %d nodes, %d meshes, %d skins, %d animations, %d textures, %d ticks per second\n",
+		entry.Name, outputPath, len(document.Nodes), len(document.Meshes), len(document.Skins), len(document.Animations),
+		len(document.Images), scene.TicksPerSecond)
+	return nil
+}
+
+// decodeModelText converts a name or string of a model to UTF-8 with the name encoding of the bundle.
+// Text that does not decode is returned as it is.
+func decodeModelText(names encoding.Encoding, text string) string {
+	decoded, err := names.NewDecoder().String(text)
+	if err != nil {
+		return text
+	}
+	return decoded
+}
+
+// textureBaseName returns the file name of a texture or entry without its directories and extension
+func textureBaseName(name string) string {
+	name = name[strings.LastIndexAny(name, `\/`)+1:]
+	return strings.TrimSuffix(name, entryExt(name))
+}
+
+// entryDir returns the directories of an entry name, empty for entries at the root
+func entryDir(name string) string {
+	return name[:max(strings.LastIndexAny(name, `\/`), 0)]
+}
+
+// textureEntry returns the CNV entry holding a texture referenced by a model, nil when there is none.
+// Models refer to the original image files, so only the base names are compared, and a CNV in the
+// directory of the model wins over the others.
+func textureEntry(fileEntries []*bundle.Entry, modelName, textureName string) *bundle.Entry {
+	base := strings.ToLower(textureBaseName(textureName))
+	modelDir := strings.ToLower(entryDir(modelName))
+
+	var found *bundle.Entry
+	for _, entry := range fileEntries {
+		if lowerExt(entry.Name) != ".cnv" || strings.ToLower(textureBaseName(entry.Name)) != base {
+			continue
+		}
+		if strings.ToLower(entryDir(entry.Name)) == modelDir {
+			return entry
+		}
+		if found == nil {
+			found = entry
+		}
+	}
+	return found
+}
+
+// texturePNGName returns the name of the PNG file a texture entry is exported to. Names that are not
+// plain ASCII are replaced with the entry index, since editors do not all handle them.
+func texturePNGName(entry *bundle.Entry) string {
+	base := textureBaseName(entry.Name)
+	for _, c := range base {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return fmt.Sprintf("texture_%d.png", entry.Index)
+		}
+	}
+	return base + ".png"
+}
+
+// exportModelTextures writes the textures of the materials of a scene as PNG files to outputDir, and
+// returns them by texture file name. Textures without a CNV entry are left out with a warning.
+func exportModelTextures(reader *bundle.Reader, model *bundle.Entry, scene *xfile.Scene, names encoding.Encoding, outputDir string) (map[string]*modelTexture, error) {
+	textures := make(map[string]*modelTexture)
+	var exported []*bundle.Entry
+
+	for _, material := range sceneMaterials(scene) {
+		textureName := material.TextureFilename
+		if _, done := textures[textureName]; done || textureName == "" {
+			continue
+		}
+
+		entry := textureEntry(reader.Entries(), model.Name, decodeModelText(names, textureName))
+		if entry == nil {
+			fmt.Printf("Warning: texture %s of material %s not found in the bundle\n",
+				decodeModelText(names, textureName), decodeModelText(names, material.Name))
+			textures[textureName] = nil
+			continue
+		}
+
+		data, err := readEntry(reader, entry)
+		if err != nil {
+			return nil, err
+		}
+		converter := cnvImageConverter{format: conversionPng}
+		if !converter.Detect(data[:min(len(data), converterHeaderSize)]) {
+			fmt.Printf("Warning: texture %s is not an image CNV\n", entry.Name)
+			textures[textureName] = nil
+			continue
+		}
+		pngData, err := converter.Decode(data)
+		if err != nil {
+			return nil, fmt.Errorf("error converting texture %s: %w", entry.Name, err)
+		}
+
+		texture := &modelTexture{uri: texturePNGName(entry), alpha: data[0] == 32}
+		textures[textureName] = texture
+		if !slices.Contains(exported, entry) {
+			pngPath := filepath.Join(outputDir, texture.uri)
+			if err := os.WriteFile(pngPath, pngData, 0644); err != nil {
+				return nil, fmt.Errorf("error writing %s: %w", pngPath, err)
+			}
+			fmt.Printf("Exported texture %s to %s\n", entry.Name, pngPath)
+			exported = append(exported, entry)
+		}
+	}
+	return textures, nil
+}
+
+// sceneMeshes returns every mesh of a scene, the ones of frames included
+func sceneMeshes(scene *xfile.Scene) []*xfile.Mesh {
+	meshes := slices.Clone(scene.Meshes)
+	var addFrame func(frame *xfile.Frame)
+	addFrame = func(frame *xfile.Frame) {
+		meshes = append(meshes, frame.Meshes...)
+		for _, child := range frame.Children {
+			addFrame(child)
+		}
+	}
+	for _, frame := range scene.Frames {
+		addFrame(frame)
+	}
+	return meshes
+}
+
+// sceneMaterials returns the materials of every mesh of a scene
+func sceneMaterials(scene *xfile.Scene) []*xfile.Material {
+	var materials []*xfile.Material
+	for _, mesh := range sceneMeshes(scene) {
+		materials = append(materials, mesh.Materials...)
+	}
+	return materials
+}
+
+// gltfExporter converts a scene to a glTF document.
+//
+// Direct3D is left-handed and glTF right-handed, so the scene is mirrored along Z and the winding of
+// the faces reversed. Matrices of .x files are stored row by row for row vectors, which is the column
+// by column storage of glTF for column vectors, so they only need mirroring.
+type gltfExporter struct {
+	document  *gltf.Document
+	names     encoding.Encoding
+	textures  map[string]*modelTexture // Exported textures by texture file name, nil for missing ones
+	nodes     map[string]int           // Nodes of the frames by frame name
+	materials map[string]int           // Materials by name, unnamed materials are not shared
+	images    map[string]int           // Textures by PNG file name
+	meshes    []*pendingMesh           // Meshes waiting for every frame to have a node, as skins refer to them
+	roots     []int
+}
+
+// pendingMesh is a mesh of the frame of node parent, -1 for meshes outside of frames
+type pendingMesh struct {
+	mesh   *xfile.Mesh
+	parent int
+}
+
+func newGLTFExporter(names encoding.Encoding, textures map[string]*modelTexture) *gltfExporter {
+	return &gltfExporter{
+		document:  gltf.New(gltfGenerator),
+		names:     names,
+		textures:  textures,
+		nodes:     make(map[string]int),
+		materials: make(map[string]int),
+		images:    make(map[string]int),
+	}
+}
+
+func (e *gltfExporter) export(scene *xfile.Scene) *gltf.Document {
+	for _, frame := range scene.Frames {
+		e.roots = append(e.roots, e.addFrame(frame))
+	}
+	for _, mesh := range scene.Meshes {
+		e.meshes = append(e.meshes, &pendingMesh{mesh: mesh, parent: -1})
+	}
+
+	for _, pending := range e.meshes {
+		node := e.addMesh(pending.mesh)
+		if node < 0 {
+			continue
+		}
+		if pending.parent < 0 {
+			e.roots = append(e.roots, node)
+		} else {
+			parent := e.document.Nodes[pending.parent]
+			parent.Children = append(parent.Children, node)
+		}
+	}
+
+	for _, set := range scene.AnimationSets {
+		e.addAnimationSet(set, scene.TicksPerSecond)
+	}
+
+	e.document.Scenes = []*gltf.Scene{{Nodes: e.roots}}
+	e.document.Scene = gltf.Index(0)
+	return e.document
+}
+
+// addFrame adds the nodes of a frame and of its children, and returns the index of the frame node
+func (e *gltfExporter) addFrame(frame *xfile.Frame) int {
+	node := &gltf.Node{Name: decodeModelText(e.names, frame.Name)}
+	translation, rotation, scale := decomposeMatrix(mirrorMatrix(frame.Transform))
+	if translation != [3]float32{} {
+		node.Translation = &translation
+	}
+	if rotation != [4]float32{0, 0, 0, 1} {
+		node.Rotation = &rotation
+	}
+	if scale != [3]float32{1, 1, 1} {
+		node.Scale = &scale
+	}
+
+	index := len(e.document.Nodes)
+	e.document.Nodes = append(e.document.Nodes, node)
+	if _, ok := e.nodes[frame.Name]; !ok {
+		e.nodes[frame.Name] = index
+	}
+
+	for _, child := range frame.Children {
+		childIndex := e.addFrame(child)
+		node.Children = append(node.Children, childIndex)
+	}
+	for _, mesh := range frame.Meshes {
+		e.meshes = append(e.meshes, &pendingMesh{mesh: mesh, parent: index})
+	}
+	return index
+}
+
+// vertexKey identifies a glTF vertex, .x faces index positions and normals separately
+type vertexKey struct {
+	position uint32
+	normal   uint32
+}
+
+// influence is the weight of a joint on a vertex
+type influence struct {
+	joint  uint16
+	weight float32
+}
+
+// addMesh adds a mesh and the node holding it, and returns the index of the node, -1 for meshes without faces
+func (e *gltfExporter) addMesh(mesh *xfile.Mesh) int {
+	name := decodeModelText(e.names, mesh.Name)
+	hasNormals := len(mesh.NormalFaces) == len(mesh.Faces)
+	for i, face := range mesh.NormalFaces {
+		if hasNormals && len(face) != len(mesh.Faces[i]) {
+			fmt.Printf("Warning: normals of mesh %s do not match its faces, they are left out\n", name)
+			hasNormals = false
+		}
+	}
+	hasTexCoords := len(mesh.TexCoords) == len(mesh.Positions)
+	if len(mesh.TexCoords) > 0 && !hasTexCoords {
+		fmt.Printf("Warning: mesh %s has %d texture coordinates for %d vertices, they are left out\n", name, len(mesh.TexCoords), len(mesh.Positions))
+	}
+
+	joints, inverseBindMatrices, influences := e.meshSkin(mesh, name)
+
+	var positions, normals, texCoords, weights []float32
+	var jointIndices []uint16
+	vertices := make(map[vertexKey]uint32)
+	vertex := func(position, normal uint32) uint32 {
+		key := vertexKey{position, normal}
+		if index, ok := vertices[key]; ok {
+			return index
+		}
+		index := uint32(len(vertices))
+		vertices[key] = index
+
+		p := mesh.Positions[position]
+		positions = append(positions, p[0], p[1], -p[2])
+		if hasNormals {
+			n := mesh.Normals[normal]
+			normals = append(normals, n[0], n[1], -n[2])
+		}
+		if hasTexCoords {
+			texCoords = append(texCoords, mesh.TexCoords[position][:]...)
+		}
+		if joints != nil {
+			vertexJoints, vertexWeights := vertexInfluences(influences[position])
+			jointIndices = append(jointIndices, vertexJoints[:]...)
+			weights = append(weights, vertexWeights[:]...)
+		}
+		return index
+	}
+
+	// Faces are split into triangles and grouped by material, in the order materials are first used
+	triangles := make(map[int][]uint32)
+	var materialOrder []int
+	for i, face := range mesh.Faces {
+		material := faceMaterial(mesh, i)
+		if _, ok := triangles[material]; !ok {
+			materialOrder = append(materialOrder, material)
+		}
+		for j := 1; j+1 < len(face); j++ {
+			corners := [3]int{0, j + 1, j}
+			for _, corner := range corners {
+				var normal uint32
+				if hasNormals {
+					normal = mesh.NormalFaces[i][corner]
+				}
+				triangles[material] = append(triangles[material], vertex(face[corner], normal))
+			}
+		}
+	}
+	if len(vertices) == 0 {
+		fmt.Printf("Warning: mesh %s has no faces, it is left out\n", name)
+		return -1
+	}
+
+	attributes := map[string]int{"POSITION": e.document.AddFloats(positions, "VEC3", gltf.ArrayBuffer, true)}
+	if hasNormals {
+		attributes["NORMAL"] = e.document.AddFloats(normals, "VEC3", gltf.ArrayBuffer, false)
+	}
+	if hasTexCoords {
+		attributes["TEXCOORD_0"] = e.document.AddFloats(texCoords, "VEC2", gltf.ArrayBuffer, false)
+	}
+	if joints != nil {
+		attributes["JOINTS_0"] = e.document.AddUint16s(jointIndices, "VEC4", gltf.ArrayBuffer)
+		attributes["WEIGHTS_0"] = e.document.AddFloats(weights, "VEC4", gltf.ArrayBuffer, false)
+	}
+
+	gltfMesh := &gltf.Mesh{Name: name}
+	for _, material := range materialOrder {
+		if len(triangles[material]) == 0 {
+			continue
+		}
+		primitive := &gltf.Primitive{Attributes: attributes, Indices: gltf.Index(e.document.AddIndices(triangles[material]))}
+		if material >= 0 {
+			primitive.Material = gltf.Index(e.material(mesh.Materials[material]))
+		}
+		gltfMesh.Primitives = append(gltfMesh.Primitives, primitive)
+	}
+	e.document.Meshes = append(e.document.Meshes, gltfMesh)
+
+	node := &gltf.Node{Name: name, Mesh: gltf.Index(len(e.document.Meshes) - 1)}
+	if joints != nil {
+		e.document.Skins = append(e.document.Skins, &gltf.Skin{
+			Name:                name,
+			InverseBindMatrices: gltf.Index(e.document.AddFloats(inverseBindMatrices, "MAT4", 0, false)),
+			Joints:              joints,
+		})
+		node.Skin = gltf.Index(len(e.document.Skins) - 1)
+	}
+	e.document.Nodes = append(e.document.Nodes, node)
+	return len(e.document.Nodes) - 1
+}
+
+// faceMaterial returns the material of a face of a mesh, -1 for meshes without materials
+func faceMaterial(mesh *xfile.Mesh, face int) int {
+	if len(mesh.Materials) == 0 || len(mesh.FaceMaterials) == 0 {
+		return -1
+	}
+	return int(mesh.FaceMaterials[min(face, len(mesh.FaceMaterials)-1)])
+}
+
+// meshSkin returns the joint nodes and inverse bind matrices of the skin of a mesh, and the joint influences
+// on every position. The joints are nil for meshes without skin weights.
+func (e *gltfExporter) meshSkin(mesh *xfile.Mesh, name string) ([]int, []float32, [][]influence) {
+	var joints []int
+	var inverseBindMatrices []float32
+	influences := make([][]influence, len(mesh.Positions))
+	for _, skin := range mesh.Skins {
+		node, ok := e.nodes[skin.Frame]
+		if !ok {
+			fmt.Printf("Warning: bone %s of mesh %s has no frame, its weights are left out\n", decodeModelText(e.names, skin.Frame), name)
+			continue
+		}
+
+		joint := uint16(len(joints))
+		joints = append(joints, node)
+		offset := mirrorMatrix(skin.Offset)
+		inverseBindMatrices = append(inverseBindMatrices, offset[:]...)
+		for i, vertex := range skin.Vertices {
+			if skin.Weights[i] > 0 {
+				influences[vertex] = append(influences[vertex], influence{joint, skin.Weights[i]})
+			}
+		}
+	}
+	return joints, inverseBindMatrices, influences
+}
+
+// vertexInfluences returns the four strongest joint influences of a vertex with weights summing to 1.
+// Vertices without weights follow the first joint.
+func vertexInfluences(influences []influence) ([4]uint16, [4]float32) {
+	var joints [4]uint16
+	var weights [4]float32
+	for _, candidate := range influences {
+		// Insertion into the weights sorted by decreasing weight, the weakest falls off
+		for i := range weights {
+			if candidate.weight > weights[i] {
+				copy(joints[i+1:], joints[i:3])
+				copy(weights[i+1:], weights[i:3])
+				joints[i], weights[i] = candidate.joint, candidate.weight
+				break
+			}
+		}
+	}
+
+	total := weights[0] + weights[1] + weights[2] + weights[3]
+	if total == 0 {
+		return joints, [4]float32{1, 0, 0, 0}
+	}
+	for i := range weights {
+		weights[i] /= total
+	}
+	return joints, weights
+}
+
+// material returns the index of the glTF material of a .x material, adding it first
+func (e *gltfExporter) material(material *xfile.Material) int {
+	if index, ok := e.materials[material.Name]; ok && material.Name != "" {
+		return index
+	}
+
+	metallic, roughness := float32(0), float32(1)
+	color := material.FaceColor
+	gltfMaterial := &gltf.Material{
+		Name: decodeModelText(e.names, material.Name),
+		PBRMetallicRoughness: &gltf.PBRMetallicRoughness{
+			BaseColorFactor: &color,
+			MetallicFactor:  &metallic,
+			RoughnessFactor: &roughness,
+		},
+	}
+	if emissive := material.Emissive; emissive != [3]float32{} {
+		gltfMaterial.EmissiveFactor = &emissive
+	}
+
+	texture := e.textures[material.TextureFilename]
+	if texture != nil {
+		image, ok := e.images[texture.uri]
+		if !ok {
+			e.document.Images = append(e.document.Images, &gltf.Image{Name: texture.uri, URI: texture.uri})
+			e.document.Textures = append(e.document.Textures, &gltf.Texture{Source: gltf.Index(len(e.document.Images) - 1)})
+			image = len(e.document.Textures) - 1
+			e.images[texture.uri] = image
+		}
+		gltfMaterial.PBRMetallicRoughness.BaseColorTexture = &gltf.TextureInfo{Index: image}
+	}
+
+	switch {
+	case color[3] < 1:
+		gltfMaterial.AlphaMode = "BLEND"
+	case texture != nil && texture.alpha:
+		gltfMaterial.AlphaMode = "MASK"
+	}
+
+	e.document.Materials = append(e.document.Materials, gltfMaterial)
+	index := len(e.document.Materials) - 1
+	if material.Name != "" {
+		e.materials[material.Name] = index
+	}
+	return index
+}
+
+// addAnimationSet adds an animation set as a glTF animation, with key times converted from ticks to seconds
+func (e *gltfExporter) addAnimationSet(set *xfile.AnimationSet, ticksPerSecond int) {
+	animation := &gltf.Animation{Name: decodeModelText(e.names, set.Name)}
+	addChannel := func(node, input int, path, outputType string, values []float32) {
+		output := e.document.AddFloats(values, outputType, 0, false)
+		animation.Samplers = append(animation.Samplers, &gltf.AnimationSampler{Input: input, Output: output, Interpolation: "LINEAR"})
+		animation.Channels = append(animation.Channels, &gltf.Channel{
+			Sampler: len(animation.Samplers) - 1,
+			Target:  gltf.ChannelTarget{Node: gltf.Index(node), Path: path},
+		})
+	}
+
+	for _, frameAnimation := range set.Animations {
+		node, ok := e.nodes[frameAnimation.Frame]
+		if !ok {
+			fmt.Printf("Warning: animation set %s animates the unknown frame %s, it is left out\n",
+				animation.Name, decodeModelText(e.names, frameAnimation.Frame))
+			continue
+		}
+
+		for _, key := range frameAnimation.Keys {
+			if len(key.Times) == 0 {
+				continue
+			}
+			times := make([]float32, len(key.Times))
+			for i, tick := range key.Times {
+				times[i] = float32(float64(tick) / float64(ticksPerSecond))
+			}
+			input := e.document.AddFloats(times, "SCALAR", 0, true)
+
+			var translations, rotations, scales []float32
+			for _, value := range key.Values {
+				switch key.Type {
+				case xfile.KeyRotation:
+					rotations = append(rotations, mirrorQuaternion([4]float32{value[1], value[2], value[3], value[0]})...)
+				case xfile.KeyScale:
+					scales = append(scales, value...)
+				case xfile.KeyPosition:
+					translations = append(translations, value[0], value[1], -value[2])
+				case xfile.KeyMatrix:
+					translation, rotation, scale := decomposeMatrix(mirrorMatrix(xfile.Matrix(value)))
+					translations = append(translations, translation[:]...)
+					rotations = append(rotations, rotation[:]...)
+					scales = append(scales, scale[:]...)
+				}
+			}
+
+			if translations != nil {
+				addChannel(node, input, "translation", "VEC3", translations)
+			}
+			if rotations != nil {
+				makeQuaternionsContinuous(rotations)
+				addChannel(node, input, "rotation", "VEC4", rotations)
+			}
+			if scales != nil {
+				addChannel(node, input, "scale", "VEC3", scales)
+			}
+		}
+	}
+
+	if len(animation.Channels) > 0 {
+		e.document.Animations = append(e.document.Animations, animation)
+	}
+}
+
+// mirrorMatrix mirrors a matrix along Z, negating the elements mixing Z with another axis
+func mirrorMatrix(m xfile.Matrix) xfile.Matrix {
+	for i := range m {
+		if (i%4 == 2) != (i/4 == 2) {
+			m[i] = -m[i]
+		}
+	}
+	return m
+}
+
+// mirrorQuaternion mirrors a quaternion x, y, z, w along Z and normalizes it
+func mirrorQuaternion(q [4]float32) []float32 {
+	length := float32(math.Sqrt(float64(q[0]*q[0] + q[1]*q[1] + q[2]*q[2] + q[3]*q[3])))
+	if length == 0 {
+		return []float32{0, 0, 0, 1}
+	}
+	return []float32{-q[0] / length, -q[1] / length, q[2] / length, q[3] / length}
+}
+
+// makeQuaternionsContinuous flips the sign of the quaternions of a list going the long way from the previous one
+func makeQuaternionsContinuous(quaternions []float32) {
+	for i := 4; i+4 <= len(quaternions); i += 4 {
+		previous, current := quaternions[i-4:i], quaternions[i:i+4]
+		if previous[0]*current[0]+previous[1]*current[1]+previous[2]*current[2]+previous[3]*current[3] < 0 {
+			for j := range current {
+				current[j] = -current[j]
+			}
+		}
+	}
+}
+
+// decomposeMatrix splits a glTF matrix into a translation, a rotation quaternion x, y, z, w and a scale
+func decomposeMatrix(m xfile.Matrix) (translation [3]float32, rotation [4]float32, scale [3]float32) {
+	translation = [3]float32{m[12], m[13], m[14]}
+
+	// The columns of the upper 3x3 part are the scaled axes
+	var axes [3][3]float64
+	for column := range axes {
+		for row := range axes[column] {
+			axes[column][row] = float64(m[column*4+row])
+		}
+	}
+	var scales [3]float64
+	for i, axis := range axes {
+		scales[i] = math.Sqrt(axis[0]*axis[0] + axis[1]*axis[1] + axis[2]*axis[2])
+	}
+	determinant := axes[0][0]*(axes[1][1]*axes[2][2]-axes[2][1]*axes[1][2]) -
+		axes[1][0]*(axes[0][1]*axes[2][2]-axes[2][1]*axes[0][2]) +
+		axes[2][0]*(axes[0][1]*axes[1][2]-axes[1][1]*axes[0][2])
+	if determinant < 0 {
+		scales[0] = -scales[0]
+	}
+	for i := range axes {
+		scale[i] = float32(scales[i])
+		if scales[i] != 0 {
+			for j := range axes[i] {
+				axes[i][j] /= scales[i]
+			}
+		}
+	}
+
+	// r(row, column) of the rotation matrix
+	r := func(row, column int) float64 { return axes[column][row] }
+	var x, y, z, w float64
+	switch trace := r(0, 0) + r(1, 1) + r(2, 2); {
+	case trace > 0:
+		s := 0.5 / math.Sqrt(trace+1)
+		w, x, y, z = 0.25/s, (r(2, 1)-r(1, 2))*s, (r(0, 2)-r(2, 0))*s, (r(1, 0)-r(0, 1))*s
+	case r(0, 0) > r(1, 1) && r(0, 0) > r(2, 2):
+		s := 2 * math.Sqrt(1+r(0, 0)-r(1, 1)-r(2, 2))
+		w, x, y, z = (r(2, 1)-r(1, 2))/s, 0.25*s, (r(0, 1)+r(1, 0))/s, (r(0, 2)+r(2, 0))/s
+	case r(1, 1) > r(2, 2):
+		s := 2 * math.Sqrt(1+r(1, 1)-r(0, 0)-r(2, 2))
+		w, x, y, z = (r(0, 2)-r(2, 0))/s, (r(0, 1)+r(1, 0))/s, 0.25*s, (r(1, 2)+r(2, 1))/s
+	default:
+		s := 2 * math.Sqrt(1+r(2, 2)-r(0, 0)-r(1, 1))
+		w, x, y, z = (r(1, 0)-r(0, 1))/s, (r(0, 2)+r(2, 0))/s, (r(1, 2)+r(2, 1))/s, 0.25*s
+	}
+	if length := math.Sqrt(x*x + y*y + z*z + w*w); length > 0 {
+		x, y, z, w = x/length, y/length, z/length, w/length
+	}
+	rotation = [4]float32{float32(x), float32(y), float32(z), float32(w)}
+	return translation, rotation, scale
+}
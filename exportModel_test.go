@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"BundleTools/gltf"
+)
+
+// testModel is a textured quad weighted to a bone rotated by an animation of 2 seconds at 30 ticks per second
+const testModel = `xof 0303txt 0032
+AnimTicksPerSecond {
+ 30;
+}
+
+Frame Root {
+ FrameTransformMatrix {
+  1.0,0.0,0.0,0.0,0.0,1.0,0.0,0.0,0.0,0.0,1.0,0.0,0.0,0.0,2.0,1.0;;
+ }
+
+ Frame Bone {
+ }
+
+ Mesh Quad {
+  4;
+  0.0;0.0;0.0;,
+  1.0;0.0;0.0;,
+  1.0;1.0;1.0;,
+  0.0;1.0;1.0;;
+  1;
+  4;0,1,2,3;;
+
+  MeshTextureCoords {
+   4;
+   0.0;0.0;,
+   1.0;0.0;,
+   1.0;1.0;,
+   0.0;1.0;;
+  }
+
+  MeshMaterialList {
+   1;
+   1;
+   0;;
+   Material {
+    1.0;1.0;1.0;1.0;;
+    0.0;
+    0.0;0.0;0.0;;
+    0.0;0.0;0.0;;
+    TextureFilename {
+     "..\\texture\\Face.bmp";
+    }
+   }
+  }
+
+  SkinWeights {
+   "Bone";
+   4;
+   0,1,2,3;
+   1.0,1.0,1.0,1.0;
+   1.0,0.0,0.0,0.0,0.0,1.0,0.0,0.0,0.0,0.0,1.0,0.0,0.0,0.0,-2.0,1.0;;
+  }
+ }
+}
+
+AnimationSet Turn {
+ Animation {
+  { Bone }
+  AnimationKey {
+   0;
+   2;
+   0;4;1.0,0.0,0.0,0.0;;,
+   60;4;0.707107,0.707107,0.0,0.0;;;
+  }
+ }
+}
+`
+
+// readGLTFFloats reads the float components of an accessor of an exported document
+func readGLTFFloats(t *testing.T, document *gltf.Document, data []byte, accessor int) []float32 {
+	t.Helper()
+	view := document.BufferViews[*document.Accessors[accessor].BufferView]
+	values := make([]float32, view.ByteLength/4)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[view.ByteOffset+i*4:]))
+	}
+	return values
+}
+
+func TestExportModel(t *testing.T) {
+	dir := t.TempDir()
+	datFilePath := filepath.Join(dir, "test.dat")
+	names := []string{`chara\model.x`, `texture\face.cnv`}
+	contents := [][]byte{[]byte(testModel), createTestCnvImage(32, 2, 2, 2)}
+	createTestBundle(t, datFilePath, names, contents)
+
+	outputPath := filepath.Join(dir, "model.gltf")
+	if err := exportModel(datFilePath, `chara\model.x`, outputPath); err != nil {
+		t.Fatal(err)
+	}
+
+	documentData, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document gltf.Document
+	if err := json.Unmarshal(documentData, &document); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, document.Buffers[0].URI))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The texture is found by its base name and exported as PNG
+	if len(document.Images) != 1 || document.Images[0].URI != "face.png" {
+		t.Fatalf("got images %+v", document.Images)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "face.png")); err != nil {
+		t.Error(err)
+	}
+	if mode := document.Materials[0].AlphaMode; mode != "MASK" {
+		t.Errorf("got alpha mode %q for a 32-bit texture, want MASK", mode)
+	}
+
+	// Root, Bone and the node of the quad, mirrored along Z
+	if len(document.Nodes) != 3 || document.Nodes[0].Name != "Root" || document.Nodes[1].Name != "Bone" {
+		t.Fatalf("got nodes %+v", document.Nodes)
+	}
+	if translation := document.Nodes[0].Translation; translation == nil || *translation != [3]float32{0, 0, -2} {
+		t.Errorf("got root translation %v, want [0 0 -2]", translation)
+	}
+	primitive := document.Meshes[0].Primitives[0]
+	if positions := document.Accessors[primitive.Attributes["POSITION"]]; positions.Min[2] != -1 || positions.Max[2] != 0 {
+		t.Errorf("got Z bounds %v to %v, want -1 to 0", positions.Min[2], positions.Max[2])
+	}
+
+	// The quad is split into two triangles with reversed winding
+	indices := document.Accessors[*primitive.Indices]
+	if indices.Count != 6 {
+		t.Errorf("got %d indices, want 6", indices.Count)
+	}
+
+	skin := document.Skins[0]
+	if len(skin.Joints) != 1 || skin.Joints[0] != 1 {
+		t.Errorf("got joints %v, want the Bone node", skin.Joints)
+	}
+	if inverseBind := readGLTFFloats(t, &document, data, *skin.InverseBindMatrices); inverseBind[14] != 2 {
+		t.Errorf("got inverse bind matrix %v, want Z translation 2", inverseBind)
+	}
+
+	// 60 ticks at 30 ticks per second end at 2 seconds
+	sampler := document.Animations[0].Samplers[0]
+	if times := document.Accessors[sampler.Input]; times.Max[0] != 2 {
+		t.Errorf("animation ends at %v seconds, want 2", times.Max[0])
+	}
+	rotations := readGLTFFloats(t, &document, data, sampler.Output)
+	want := []float32{0, 0, 0, 1, -0.70710677, 0, 0, 0.70710677}
+	for i := range want {
+		if math.Abs(float64(rotations[i]-want[i])) > 1e-6 {
+			t.Fatalf("got rotations %v, want %v", rotations, want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"BundleTools/bundle"
+)
+
+// extractedEntry describes how extractEntry exported an entry
+type extractedEntry struct {
+	outputPath string // Path of the written file, with the extension of the conversion
+	conversion string // One of the conversion constants, the name of a converter or conversionNone
+	dataKey    *uint8 // First byte of .cnv entries
+	template   []byte // First bytes of the entry needed to convert it back, nil when none are
+	hash       []byte // SHA-256 of the written file
+}
+
+// extractEntry writes the decrypted contents of an entry to outputPath.
+// Entries without a converter or only renamed are streamed from the bundle, converted ones are held in memory.
+// Converters are picked with options. The extension of outputPath is replaced to match the conversion.
+func extractEntry(reader *bundle.Reader, entry *bundle.Entry, outputPath string, options conversionOptions) (*extractedEntry, error) {
+	entryReader, err := reader.Open(entry)
+	if err != nil {
+		return nil, err
+	}
+	defer entryReader.Close()
+
+	extracted := &extractedEntry{outputPath: outputPath, conversion: conversionNone}
+	content := io.Reader(entryReader)
+
+	storedExtension := lowerExt(entry.Name)
+	if len(convertedExtensions(storedExtension)) > 0 {
+		// The first bytes of the entry select the converter
+		bufferedReader := bufio.NewReader(entryReader)
+		content = bufferedReader
+
+		header, err := bufferedReader.Peek(converterHeaderSize)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error extracting %s from bundle: %w", entry.Name, err)
+		}
+		if storedExtension == ".cnv" && len(header) > 0 {
+			dataKey := header[0]
+			extracted.dataKey = &dataKey
+		}
+
+		converter := detectConverter(entry.Name, header, options)
+		if converter != nil && isRawConverter(converter) {
+			// Only the name changes, the entry is streamed like unconverted ones
+			fmt.Printf("No known format in %s, saving as %s\n", entry.Name, converter.Extension())
+			extracted.setConversion(converter, storedExtension)
+		} else if converter != nil {
+			data, err := io.ReadAll(bufferedReader)
+			if err != nil {
+				return nil, fmt.Errorf("error extracting %s from bundle: %w", entry.Name, err)
+			}
+
+			// Keep the header to convert the entry back the same way
+			header := bytes.Clone(data[:min(len(data), converterTemplateSize(converter))])
+
+			if converter = convertEntry(entry, converter, &data); converter != nil {
+				extracted.setConversion(converter, storedExtension)
+				if converterTemplateSize(converter) > 0 {
+					extracted.template = header
+				}
+			}
+			content = bytes.NewReader(data)
+		}
+	}
+
+	extracted.hash, err = writeExtractedFile(extracted.outputPath, content)
+	if err != nil {
+		return nil, err
+	}
+	return extracted, nil
+}
+
+// setConversion records the converter applied to an entry with storedExtension and changes the extension to match it
+func (e *extractedEntry) setConversion(converter Converter, storedExtension string) {
+	e.conversion = converter.Name()
+	if strings.EqualFold(filepath.Ext(e.outputPath), storedExtension) {
+		e.outputPath = strings.TrimSuffix(e.outputPath, filepath.Ext(e.outputPath)) + converter.Extension()
+	}
+}
+
+// convertEntry converts the data of an entry in place and returns the converter applied, which must not be a raw one.
+// Conversion failures are not fatal, the data is then kept as it is by the catch-all converter,
+// or under its own name when there is none and nil is returned.
+func convertEntry(entry *bundle.Entry, converter Converter, data *[]byte) Converter {
+	// Conversion with panic recovery
+	converted, err := func() (converted []byte, convertErr error) {
+		defer func() {
+			if r := recover(); r != nil {
+				convertErr = fmt.Errorf("conversion panicked: %v", r)
+			}
+		}()
+		return converter.Decode(*data)
+	}()
+
+	if err != nil {
+		// Catch-all converters detect entries from any header
+		fallback := detectConverter(entry.Name, nil, conversionOptions{})
+		if fallback == nil || !isRawConverter(fallback) {
+			fmt.Printf("Error converting %s to %s: %v, saving it as it is\n", entry.Name, strings.ToUpper(converter.Name()), err)
+			return nil
+		}
+		fmt.Printf("Error converting %s to %s: %v, saving as %s\n", entry.Name, strings.ToUpper(converter.Name()), err, fallback.Extension())
+		return fallback
+	}
+
+	*data = converted
+	return converter
+}
+
+// writeExtractedFile copies r to a new file, creating directories as needed, and returns its SHA-256
+func writeExtractedFile(outputPath string, r io.Reader) ([]byte, error) {
+	// Create directories as needed for the output path
+	if err := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("error creating directory for %s: %w", outputPath, err)
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write %s: %w", outputPath, err)
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(outputFile, hash), r)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to write %s: %w", outputPath, err)
+	}
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,267 @@
+// Package gltf reads and writes glTF 2.0 models, the format used to exchange models with Blender and other editors.
+//
+// Only the parts of the format needed for the models of the game are described: nodes, meshes with
+// skins, materials with a base color texture and animations. A Document collects the binary data of
+// its accessors, Write stores it in a .bin file next to the .gltf file. Read loads .gltf files with
+// their buffers and binary .glb files, whose accessors are then read with ReadFloats and ReadUints.
+package gltf
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Component types of accessors
+const (
+	Byte          = 5120
+	UnsignedByte  = 5121
+	Short         = 5122
+	UnsignedShort = 5123
+	UnsignedInt   = 5125
+	Float         = 5126
+)
+
+// Targets of buffer views
+const (
+	ArrayBuffer        = 34962
+	ElementArrayBuffer = 34963
+)
+
+// Document is a glTF document
+type Document struct {
+	Asset       Asset         `json:"asset"`
+	Scene       *int          `json:"scene,omitempty"`
+	Scenes      []*Scene      `json:"scenes,omitempty"`
+	Nodes       []*Node       `json:"nodes,omitempty"`
+	Meshes      []*Mesh       `json:"meshes,omitempty"`
+	Skins       []*Skin       `json:"skins,omitempty"`
+	Animations  []*Animation  `json:"animations,omitempty"`
+	Materials   []*Material   `json:"materials,omitempty"`
+	Textures    []*Texture    `json:"textures,omitempty"`
+	Images      []*Image      `json:"images,omitempty"`
+	Accessors   []*Accessor   `json:"accessors,omitempty"`
+	BufferViews []*BufferView `json:"bufferViews,omitempty"`
+	Buffers     []*Buffer     `json:"buffers,omitempty"`
+
+	data    []byte   // Binary data of the accessors added
+	buffers [][]byte // Data of the buffers of a document read
+}
+
+type Asset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator,omitempty"`
+}
+
+type Scene struct {
+	Name  string `json:"name,omitempty"`
+	Nodes []int  `json:"nodes"`
+}
+
+// Node is a node of the scene, its transformation is given as translation, rotation and scale
+// since animated nodes cannot have a matrix. Documents read may give a matrix instead.
+type Node struct {
+	Name        string       `json:"name,omitempty"`
+	Children    []int        `json:"children,omitempty"`
+	Matrix      *[16]float32 `json:"matrix,omitempty"` // Column by column
+	Translation *[3]float32  `json:"translation,omitempty"`
+	Rotation    *[4]float32  `json:"rotation,omitempty"` // Quaternion x, y, z, w
+	Scale       *[3]float32  `json:"scale,omitempty"`
+	Mesh        *int         `json:"mesh,omitempty"`
+	Skin        *int         `json:"skin,omitempty"`
+}
+
+type Mesh struct {
+	Name       string       `json:"name,omitempty"`
+	Primitives []*Primitive `json:"primitives"`
+}
+
+// Primitive is a list of triangles of a mesh using one material
+type Primitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    *int           `json:"indices,omitempty"`
+	Material   *int           `json:"material,omitempty"`
+	Mode       *int           `json:"mode,omitempty"` // Triangles when missing
+}
+
+type Skin struct {
+	Name                string `json:"name,omitempty"`
+	InverseBindMatrices *int   `json:"inverseBindMatrices,omitempty"`
+	Joints              []int  `json:"joints"`
+}
+
+type Animation struct {
+	Name     string              `json:"name,omitempty"`
+	Channels []*Channel          `json:"channels"`
+	Samplers []*AnimationSampler `json:"samplers"`
+}
+
+// Channel animates the property Path (translation, rotation or scale) of the Node of its target
+type Channel struct {
+	Sampler int           `json:"sampler"`
+	Target  ChannelTarget `json:"target"`
+}
+
+type ChannelTarget struct {
+	Node *int   `json:"node,omitempty"`
+	Path string `json:"path"`
+}
+
+// AnimationSampler gives the values of Output at the times in seconds of Input
+type AnimationSampler struct {
+	Input         int    `json:"input"`
+	Output        int    `json:"output"`
+	Interpolation string `json:"interpolation,omitempty"`
+}
+
+type Material struct {
+	Name                 string                `json:"name,omitempty"`
+	PBRMetallicRoughness *PBRMetallicRoughness `json:"pbrMetallicRoughness,omitempty"`
+	EmissiveFactor       *[3]float32           `json:"emissiveFactor,omitempty"`
+	AlphaMode            string                `json:"alphaMode,omitempty"`
+	DoubleSided          bool                  `json:"doubleSided,omitempty"`
+}
+
+type PBRMetallicRoughness struct {
+	BaseColorFactor  *[4]float32  `json:"baseColorFactor,omitempty"`
+	BaseColorTexture *TextureInfo `json:"baseColorTexture,omitempty"`
+	MetallicFactor   *float32     `json:"metallicFactor,omitempty"`
+	RoughnessFactor  *float32     `json:"roughnessFactor,omitempty"`
+}
+
+type TextureInfo struct {
+	Index int `json:"index"`
+}
+
+type Texture struct {
+	Source *int `json:"source,omitempty"`
+}
+
+type Image struct {
+	Name       string `json:"name,omitempty"`
+	URI        string `json:"uri,omitempty"`
+	BufferView *int   `json:"bufferView,omitempty"` // Images embedded in .glb files
+	MimeType   string `json:"mimeType,omitempty"`
+}
+
+type Accessor struct {
+	BufferView    *int            `json:"bufferView,omitempty"`
+	ByteOffset    int             `json:"byteOffset,omitempty"`
+	ComponentType int             `json:"componentType"`
+	Normalized    bool            `json:"normalized,omitempty"`
+	Count         int             `json:"count"`
+	Type          string          `json:"type"` // SCALAR, VEC2, VEC3, VEC4 or MAT4
+	Min           []float32       `json:"min,omitempty"`
+	Max           []float32       `json:"max,omitempty"`
+	Sparse        json.RawMessage `json:"sparse,omitempty"` // Not supported by ReadFloats and ReadUints
+}
+
+type BufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset,omitempty"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride,omitempty"` // Elements are packed when 0
+	Target     int `json:"target,omitempty"`
+}
+
+type Buffer struct {
+	ByteLength int    `json:"byteLength"`
+	URI        string `json:"uri,omitempty"`
+}
+
+// New returns an empty document
+func New(generator string) *Document {
+	return &Document{Asset: Asset{Version: "2.0", Generator: generator}}
+}
+
+// Index returns a pointer to i, for the optional indices of the document
+func Index(i int) *int {
+	return &i
+}
+
+// componentCounts are the number of components of every accessor type
+var componentCounts = map[string]int{"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4, "MAT4": 16}
+
+// addAccessor appends data as a new buffer view and returns the index of an accessor reading it
+func (d *Document) addAccessor(data []byte, componentType, count int, accessorType string, target int) int {
+	// Buffer views start on 4 byte boundaries, the largest component size used
+	for len(d.data)%4 != 0 {
+		d.data = append(d.data, 0)
+	}
+	d.BufferViews = append(d.BufferViews, &BufferView{ByteOffset: len(d.data), ByteLength: len(data), Target: target})
+	d.data = append(d.data, data...)
+
+	d.Accessors = append(d.Accessors, &Accessor{
+		BufferView:    Index(len(d.BufferViews) - 1),
+		ComponentType: componentType,
+		Count:         count,
+		Type:          accessorType,
+	})
+	return len(d.Accessors) - 1
+}
+
+// AddFloats adds an accessor of float elements of the given type, values holds their components one element after
+// the other. The bounds of the elements are recorded when withBounds is set, as needed for positions and animation times.
+func (d *Document) AddFloats(values []float32, accessorType string, target int, withBounds bool) int {
+	size := componentCounts[accessorType]
+	data := make([]byte, 0, 4*len(values))
+	for _, value := range values {
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(value))
+	}
+	index := d.addAccessor(data, Float, len(values)/size, accessorType, target)
+
+	if withBounds && len(values) > 0 {
+		accessor := d.Accessors[index]
+		accessor.Min = append([]float32(nil), values[:size]...)
+		accessor.Max = append([]float32(nil), values[:size]...)
+		for i, value := range values {
+			accessor.Min[i%size] = min(accessor.Min[i%size], value)
+			accessor.Max[i%size] = max(accessor.Max[i%size], value)
+		}
+	}
+	return index
+}
+
+// AddUint16s adds an accessor of unsigned short elements of the given type, like the joints of skinned vertices
+func (d *Document) AddUint16s(values []uint16, accessorType string, target int) int {
+	data := make([]byte, 0, 2*len(values))
+	for _, value := range values {
+		data = binary.LittleEndian.AppendUint16(data, value)
+	}
+	return d.addAccessor(data, UnsignedShort, len(values)/componentCounts[accessorType], accessorType, target)
+}
+
+// AddIndices adds an accessor of triangle vertex indices
+func (d *Document) AddIndices(indices []uint32) int {
+	data := make([]byte, 0, 4*len(indices))
+	for _, index := range indices {
+		data = binary.LittleEndian.AppendUint32(data, index)
+	}
+	return d.addAccessor(data, UnsignedInt, len(indices), "SCALAR", ElementArrayBuffer)
+}
+
+// Write writes the document to path and its binary data to a .bin file with the same base name
+func (d *Document) Write(path string) error {
+	binPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".bin"
+	d.Buffers = []*Buffer{{ByteLength: len(d.data), URI: url.PathEscape(filepath.Base(binPath))}}
+	for _, view := range d.BufferViews {
+		view.Buffer = 0
+	}
+
+	document, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding glTF: %w", err)
+	}
+	if err := os.WriteFile(binPath, d.data, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", binPath, err)
+	}
+	if err := os.WriteFile(path, append(document, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package gltf
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Chunk types of .glb files
+const (
+	glbJSON = 0x4e4f534a
+	glbBIN  = 0x004e4942
+)
+
+// componentSizes are the sizes in bytes of the component types
+var componentSizes = map[int]int{Byte: 1, UnsignedByte: 1, Short: 2, UnsignedShort: 2, UnsignedInt: 4, Float: 4}
+
+// Read reads a .gltf document with the buffers it refers to, or a binary .glb document.
+// Buffers are external files relative to the document, data URIs or the binary chunk of a .glb file.
+func Read(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", path, err)
+	}
+
+	var chunk []byte
+	if len(data) >= 12 && string(data[0:4]) == "glTF" {
+		data, chunk, err = splitGLB(data)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+	}
+
+	document := &Document{}
+	if err := json.Unmarshal(data, document); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if !strings.HasPrefix(document.Asset.Version, "2.") {
+		return nil, fmt.Errorf("%s is a glTF %s document, only 2.0 is supported", path, document.Asset.Version)
+	}
+
+	document.buffers = make([][]byte, len(document.Buffers))
+	for i, buffer := range document.Buffers {
+		var bufferData []byte
+		switch {
+		case buffer.URI == "":
+			if chunk == nil || i != 0 {
+				return nil, fmt.Errorf("buffer %d of %s has no data", i, path)
+			}
+			bufferData = chunk
+		case strings.HasPrefix(buffer.URI, "data:"):
+			comma := strings.IndexByte(buffer.URI, ',')
+			if comma < 0 || !strings.HasSuffix(buffer.URI[:comma], ";base64") {
+				return nil, fmt.Errorf("buffer %d of %s is not a base64 data URI", i, path)
+			}
+			bufferData, err = base64.StdEncoding.DecodeString(buffer.URI[comma+1:])
+			if err != nil {
+				return nil, fmt.Errorf("error decoding buffer %d of %s: %w", i, path, err)
+			}
+		default:
+			name, err := url.PathUnescape(buffer.URI)
+			if err != nil {
+				name = buffer.URI
+			}
+			bufferPath := filepath.Join(filepath.Dir(path), filepath.FromSlash(name))
+			bufferData, err = os.ReadFile(bufferPath)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read buffer %d of %s: %w", i, path, err)
+			}
+		}
+
+		if buffer.ByteLength < 0 || len(bufferData) < buffer.ByteLength {
+			return nil, fmt.Errorf("buffer %d of %s holds %d bytes instead of %d", i, path, len(bufferData), buffer.ByteLength)
+		}
+		document.buffers[i] = bufferData
+	}
+	return document, nil
+}
+
+// splitGLB returns the JSON chunk and the binary chunk of a .glb file, the binary chunk is nil when there is none
+func splitGLB(data []byte) ([]byte, []byte, error) {
+	if version := binary.LittleEndian.Uint32(data[4:8]); version != 2 {
+		return nil, nil, fmt.Errorf("unsupported .glb version %d", version)
+	}
+	length := min(int(binary.LittleEndian.Uint32(data[8:12])), len(data))
+
+	var jsonChunk, binChunk []byte
+	for pos := 12; pos+8 <= length; {
+		chunkLength := int(binary.LittleEndian.Uint32(data[pos:]))
+		chunkType := binary.LittleEndian.Uint32(data[pos+4:])
+		pos += 8
+		if chunkLength > length-pos {
+			return nil, nil, fmt.Errorf("chunk at byte %d is %d bytes, %d left", pos-8, chunkLength, length-pos)
+		}
+
+		switch chunkType {
+		case glbJSON:
+			jsonChunk = data[pos : pos+chunkLength]
+		case glbBIN:
+			binChunk = data[pos : pos+chunkLength]
+		}
+		pos += chunkLength
+	}
+
+	if jsonChunk == nil {
+		return nil, nil, errors.New("no JSON chunk in .glb file")
+	}
+	return jsonChunk, binChunk, nil
+}
+
+// components returns the components of the elements of an accessor one element after the other.
+// Accessors without buffer view are filled with zeros.
+func (d *Document) components(index int) ([]float64, *Accessor, error) {
+	if index < 0 || index >= len(d.Accessors) {
+		return nil, nil, fmt.Errorf("accessor %d not found", index)
+	}
+	accessor := d.Accessors[index]
+	if accessor.Sparse != nil {
+		return nil, nil, fmt.Errorf("accessor %d is sparse, which is not supported", index)
+	}
+	count, ok := componentCounts[accessor.Type]
+	componentSize := componentSizes[accessor.ComponentType]
+	if !ok || componentSize == 0 {
+		return nil, nil, fmt.Errorf("accessor %d has unsupported type %s of component %d", index, accessor.Type, accessor.ComponentType)
+	}
+
+	if accessor.Count < 0 || accessor.ByteOffset < 0 {
+		return nil, nil, fmt.Errorf("accessor %d has a negative count or offset", index)
+	}
+
+	if accessor.BufferView == nil {
+		return make([]float64, accessor.Count*count), accessor, nil
+	}
+	if *accessor.BufferView < 0 || *accessor.BufferView >= len(d.BufferViews) {
+		return nil, nil, fmt.Errorf("accessor %d refers to the unknown buffer view %d", index, *accessor.BufferView)
+	}
+	view := d.BufferViews[*accessor.BufferView]
+	if view.Buffer < 0 || view.Buffer >= len(d.buffers) {
+		return nil, nil, fmt.Errorf("buffer view %d refers to the unknown buffer %d", *accessor.BufferView, view.Buffer)
+	}
+	if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteStride < 0 {
+		return nil, nil, fmt.Errorf("buffer view %d has a negative offset, length or stride", *accessor.BufferView)
+	}
+
+	stride := view.ByteStride
+	if stride == 0 {
+		stride = count * componentSize
+	}
+	data := d.buffers[view.Buffer]
+	// Offsets are checked one by one first so that adding them up cannot overflow
+	if view.ByteOffset > len(data) || accessor.ByteOffset > len(data) {
+		return nil, nil, fmt.Errorf("accessor %d starts past the end of buffer view %d", index, *accessor.BufferView)
+	}
+	start := view.ByteOffset + accessor.ByteOffset
+	if accessor.Count > 0 {
+		end := min(view.ByteOffset+min(view.ByteLength, len(data)), len(data))
+		if end-start < count*componentSize || accessor.Count-1 > (end-start-count*componentSize)/stride {
+			return nil, nil, fmt.Errorf("accessor %d reads past the end of buffer view %d", index, *accessor.BufferView)
+		}
+	}
+
+	values := make([]float64, accessor.Count*count)
+	for i := range accessor.Count {
+		for j := range count {
+			offset := start + i*stride + j*componentSize
+			var value float64
+			switch accessor.ComponentType {
+			case Byte:
+				value = float64(int8(data[offset]))
+			case UnsignedByte:
+				value = float64(data[offset])
+			case Short:
+				value = float64(int16(binary.LittleEndian.Uint16(data[offset:])))
+			case UnsignedShort:
+				value = float64(binary.LittleEndian.Uint16(data[offset:]))
+			case UnsignedInt:
+				value = float64(binary.LittleEndian.Uint32(data[offset:]))
+			case Float:
+				value = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[offset:])))
+			}
+			values[i*count+j] = value
+		}
+	}
+	return values, accessor, nil
+}
+
+// ReadFloats returns the components of the elements of an accessor one element after the other.
+// Normalized integers are scaled to 0 to 1, or -1 to 1 when signed.
+func (d *Document) ReadFloats(index int) ([]float32, error) {
+	values, accessor, err := d.components(index)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := 1.0
+	if accessor.Normalized {
+		switch accessor.ComponentType {
+		case Byte:
+			scale = 1.0 / 127
+		case UnsignedByte:
+			scale = 1.0 / 255
+		case Short:
+			scale = 1.0 / 32767
+		case UnsignedShort:
+			scale = 1.0 / 65535
+		}
+	}
+
+	floats := make([]float32, len(values))
+	for i, value := range values {
+		if accessor.Normalized {
+			// The smallest signed value is one step below -1
+			value = max(value*scale, -1)
+		}
+		floats[i] = float32(value)
+	}
+	return floats, nil
+}
+
+// ReadUints returns the components of an accessor of unsigned integers, like vertex indices and joints
+func (d *Document) ReadUints(index int) ([]uint32, error) {
+	values, accessor, err := d.components(index)
+	if err != nil {
+		return nil, err
+	}
+	if accessor.ComponentType != UnsignedByte && accessor.ComponentType != UnsignedShort && accessor.ComponentType != UnsignedInt {
+		return nil, fmt.Errorf("accessor %d does not hold unsigned integers", index)
+	}
+
+	uints := make([]uint32, len(values))
+	for i, value := range values {
+		uints[i] = uint32(value)
+	}
+	return uints, nil
+}
@@ -0,0 +1,86 @@
+package gltf
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readTestDocument reads a document with one 24-byte buffer holding two VEC3 floats and the given accessor and buffer view
+func readTestDocument(t *testing.T, accessor, view string) (*Document, error) {
+	t.Helper()
+	var buffer []byte
+	for i := range 6 {
+		buffer = binary.LittleEndian.AppendUint32(buffer, math.Float32bits(float32(i)))
+	}
+
+	document := fmt.Sprintf(`{"asset": {"version": "2.0"},
+		"accessors": [%s], "bufferViews": [%s],
+		"buffers": [{"byteLength": 24, "uri": "data:application/octet-stream;base64,%s"}]}`,
+		accessor, view, base64.StdEncoding.EncodeToString(buffer))
+	path := filepath.Join(t.TempDir(), "model.gltf")
+	if err := os.WriteFile(path, []byte(document), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Read(path)
+}
+
+func TestReadFloats(t *testing.T) {
+	document, err := readTestDocument(t,
+		`{"bufferView": 0, "byteOffset": 12, "componentType": 5126, "count": 1, "type": "VEC3"}`,
+		`{"buffer": 0, "byteLength": 24}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	floats, err := document.ReadFloats(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(floats) != "[3 4 5]" {
+		t.Errorf("got %v, want [3 4 5]", floats)
+	}
+}
+
+func TestReadMalformed(t *testing.T) {
+	for name, test := range map[string]struct{ accessor, view string }{
+		"negative count": {
+			`{"bufferView": 0, "componentType": 5126, "count": -1, "type": "VEC3"}`,
+			`{"buffer": 0, "byteLength": 24}`},
+		"negative count without buffer view": {
+			`{"componentType": 5126, "count": -1, "type": "VEC3"}`,
+			`{"buffer": 0, "byteLength": 24}`},
+		"negative accessor offset": {
+			`{"bufferView": 0, "byteOffset": -12, "componentType": 5126, "count": 1, "type": "VEC3"}`,
+			`{"buffer": 0, "byteOffset": 12, "byteLength": 12}`},
+		"negative view offset": {
+			`{"bufferView": 0, "componentType": 5126, "count": 1, "type": "VEC3"}`,
+			`{"buffer": 0, "byteOffset": -4, "byteLength": 24}`},
+		"negative stride": {
+			`{"bufferView": 0, "byteOffset": 12, "componentType": 5126, "count": 2, "type": "VEC3"}`,
+			`{"buffer": 0, "byteLength": 24, "byteStride": -12}`},
+		"negative length": {
+			`{"bufferView": 0, "componentType": 5126, "count": 1, "type": "VEC3"}`,
+			`{"buffer": 0, "byteLength": -24}`},
+		"huge offset": {
+			`{"bufferView": 0, "byteOffset": 9223372036854775800, "componentType": 5126, "count": 1, "type": "VEC3"}`,
+			`{"buffer": 0, "byteOffset": 16, "byteLength": 8}`},
+		"huge count": {
+			`{"bufferView": 0, "componentType": 5126, "count": 768614336404564650, "type": "VEC3"}`,
+			`{"buffer": 0, "byteLength": 24}`},
+		"past the view": {
+			`{"bufferView": 0, "componentType": 5126, "count": 2, "type": "VEC3"}`,
+			`{"buffer": 0, "byteLength": 20}`},
+	} {
+		document, err := readTestDocument(t, test.accessor, test.view)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := document.ReadFloats(0); err == nil {
+			t.Errorf("%s: read the accessor", name)
+		}
+	}
+}
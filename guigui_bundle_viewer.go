@@ -217,12 +217,15 @@ func (h *bundleHeader) Build(context *guigui.Context, appender *guigui.ChildWidg
 type fileDetails struct {
 	guigui.DefaultWidget
 
-	form          basicwidget.Form
-	nameText      basicwidget.Text
-	sizeText      basicwidget.Text
-	offsetText    basicwidget.Text
-	extractButton basicwidget.Button
-	handlerSet    bool // Track if button handler has been set
+	form           basicwidget.Form
+	nameText       basicwidget.Text
+	sizeText       basicwidget.Text
+	offsetText     basicwidget.Text
+	formatText     basicwidget.Text
+	formatDropdown basicwidget.DropdownList[string]
+	extractButton  basicwidget.Button
+	replaceButton  basicwidget.Button
+	handlerSet     bool // Track if button handler has been set
 
 	model *Model
 }
@@ -272,7 +275,7 @@ func (d *fileDetails) Build(context *guigui.Context, appender *guigui.ChildWidge
 				}
 
 				// Extract the file
-				err = extractSingleFile(d.model.datFilePath, selectedIndex, filename)
+				err = extractSingleFile(d.model.datFilePath, selectedIndex, filename, d.model.ConversionOptions())
 				if err != nil {
 					fmt.Printf("Error extracting file: %v\n", err)
 				} else {
@@ -318,7 +321,7 @@ func (d *fileDetails) Build(context *guigui.Context, appender *guigui.ChildWidge
 				}
 
 				// Extract the file
-				err = extractSingleFile(d.model.datFilePath, selectedIndex, filename)
+				err = extractSingleFile(d.model.datFilePath, selectedIndex, filename, d.model.ConversionOptions())
 				if err != nil {
 					fmt.Printf("Error extracting file: %v\n", err)
 				} else {
@@ -329,6 +332,50 @@ func (d *fileDetails) Build(context *guigui.Context, appender *guigui.ChildWidge
 		}
 	}
 
+	// Image format used when the extracted file is an image CNV
+	d.formatText.SetValue("Image format:")
+	formatItems := []basicwidget.DropdownListItem[string]{
+		{Text: "BMP", ID: conversionBmp},
+		{Text: "PNG (keeps transparency)", ID: conversionPng},
+		{Text: "TGA", ID: conversionTga},
+	}
+	d.formatDropdown.SetItems(formatItems)
+	d.formatDropdown.SetOnItemSelected(func(index int) {
+		if item, ok := d.formatDropdown.ItemByIndex(index); ok {
+			d.model.SetImageFormat(item.ID)
+		}
+	})
+
+	// Replace the selected entry, converted files like images and sounds are converted back
+	d.replaceButton.SetText("Replace File")
+	d.replaceButton.SetOnUp(func() {
+		selectedIndex := d.model.SelectedFileIndex()
+		if selectedIndex < 0 || d.model.bundle == nil || selectedIndex >= len(d.model.bundle.fileEntries) {
+			fmt.Printf("No file selected for replacement\n")
+			return
+		}
+
+		// Files of the registered converters are converted back to the format of the entry
+		var extensions []string
+		for _, ext := range convertedExtensions("") {
+			extensions = append(extensions, strings.TrimPrefix(ext, "."))
+		}
+		filename, err := dialog.File().Filter("Converted files", extensions...).Filter("All files", "*").Load()
+		if err != nil {
+			// User cancelled or error occurred
+			if err.Error() != "Cancelled" {
+				fmt.Printf("Error selecting replacement file: %v\n", err)
+			}
+			return
+		}
+
+		if err := d.model.ReplaceFile(selectedIndex, filename); err != nil {
+			fmt.Printf("Error replacing file: %v\n", err)
+		} else {
+			fmt.Printf("File at index %d replaced with: %s\n", selectedIndex, filename)
+		}
+	})
+
 	d.form.SetItems([]basicwidget.FormItem{
 		{
 			PrimaryWidget: &d.nameText,
@@ -339,9 +386,16 @@ func (d *fileDetails) Build(context *guigui.Context, appender *guigui.ChildWidge
 		{
 			PrimaryWidget: &d.offsetText,
 		},
+		{
+			PrimaryWidget:   &d.formatText,
+			SecondaryWidget: &d.formatDropdown,
+		},
 		{
 			PrimaryWidget: &d.extractButton,
 		},
+		{
+			PrimaryWidget: &d.replaceButton,
+		},
 	})
 
 	appender.AppendChildWidgetWithBounds(&d.form, context.Bounds(d))
@@ -1,36 +1,41 @@
 package main
 
 import (
+	"fmt"
 	"os"
+
+	"BundleTools/bundle"
 )
 
 // Bundle represents a loaded .DAT bundle file
 type Bundle struct {
-	filePath     string
-	fileEntries  []*FileEntry
-	fileEntryMap map[string]*FileEntry
+	filePath    string
+	file        *os.File
+	reader      *bundle.Reader
+	fileEntries []*bundle.Entry
 }
 
 // LoadBundle loads a .DAT bundle file and returns a Bundle struct
+// The file stays open until Close is called so entries can be read from the reader
 func LoadBundle(filePath string) (*Bundle, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	fileEntryMap, fileEntries, err := getTableData(file)
+	file, reader, err := openBundle(filePath)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Bundle{
-		filePath:     filePath,
-		fileEntries:  fileEntries,
-		fileEntryMap: fileEntryMap,
+		filePath:    filePath,
+		file:        file,
+		reader:      reader,
+		fileEntries: reader.Entries(),
 	}, nil
 }
 
+// Close closes the underlying bundle file
+func (b *Bundle) Close() error {
+	return b.file.Close()
+}
+
 // Model holds the application state for the guigui interface
 type Model struct {
 	mode              string
@@ -47,6 +52,7 @@ type Model struct {
 	autoExtractBmp    bool
 	preserveStructure bool
 	fileFilter        string // "all", "bmp", "txt", "dat", "other"
+	imageFormat       string // Format image CNVs are extracted to, one of imageConversions
 
 	// Callback for UI updates
 	onUpdate func()
@@ -66,20 +72,52 @@ func (m *Model) SetMode(mode string) {
 func (m *Model) LoadDatFile(filePath string) error {
 	m.datFilePath = filePath
 
-	bundle, err := LoadBundle(filePath)
+	loaded, err := LoadBundle(filePath)
 	if err != nil {
 		m.status = "Failed to load file: " + err.Error()
 		m.triggerUpdate()
 		return err
 	}
 
-	m.bundle = bundle
+	// Release the previously loaded bundle
+	if m.bundle != nil {
+		m.bundle.Close()
+	}
+
+	m.bundle = loaded
 	m.selectedFileIndex = -1
 	m.status = "File loaded successfully"
 	m.triggerUpdate()
 	return nil
 }
 
+// ReplaceFile patches the entry at index with the contents of inputFilePath and reloads the bundle.
+// Files handled by a converter, like BMP or WAV files replacing a .cnv entry, are converted back.
+func (m *Model) ReplaceFile(index int, inputFilePath string) error {
+	if m.bundle == nil {
+		return fmt.Errorf("no DAT file loaded")
+	}
+
+	// The bundle is rewritten in place, release it first
+	m.bundle.Close()
+	m.bundle = nil
+
+	patchErr := patchSingleFile(m.datFilePath, inputFilePath, index)
+	if err := m.LoadDatFile(m.datFilePath); err != nil {
+		return err
+	}
+	if patchErr != nil {
+		m.status = "Failed to replace file: " + patchErr.Error()
+		m.triggerUpdate()
+		return patchErr
+	}
+
+	m.selectedFileIndex = index
+	m.status = "File replaced successfully"
+	m.triggerUpdate()
+	return nil
+}
+
 func (m *Model) SelectedFileIndex() int {
 	return m.selectedFileIndex
 }
@@ -174,6 +212,23 @@ func (m *Model) SetFileFilter(filter string) {
 	m.triggerUpdate()
 }
 
+func (m *Model) ImageFormat() string {
+	if m.imageFormat == "" {
+		return conversionBmp
+	}
+	return m.imageFormat
+}
+
+func (m *Model) SetImageFormat(format string) {
+	m.imageFormat = format
+	m.triggerUpdate()
+}
+
+// ConversionOptions returns the options entries are extracted with, models are extracted as they are
+func (m *Model) ConversionOptions() conversionOptions {
+	return conversionOptions{imageFormat: m.ImageFormat()}
+}
+
 // NewModel creates a new model with default settings
 func NewModel() *Model {
 	return &Model{
@@ -184,5 +239,6 @@ func NewModel() *Model {
 		autoExtractBmp:    true,
 		preserveStructure: true,
 		fileFilter:        "all",
+		imageFormat:       conversionBmp,
 	}
 }
@@ -0,0 +1,912 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"BundleTools/bundle"
+	"BundleTools/gltf"
+	"BundleTools/xfile"
+
+	"golang.org/x/text/encoding"
+)
+
+// optionalTemplates are the standard templates the importer writes only when the original model uses them
+var optionalTemplates = []string{"AnimTicksPerSecond", "MeshNormals", "MeshTextureCoords", "XSkinMeshHeader"}
+
+// importModel converts a glTF model to a text .x model replacing a .x entry of a bundle. The entry is the
+// template of the output: its version, float size, template declarations, tick rate and kind of animation
+// keys are kept, and the optional templates it does not use are left out.
+func importModel(bundlePath, inputPath, indexOrName, outputPath string) error {
+	file, reader, err := openBundle(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entry, err := findEntry(reader, indexOrName)
+	if err != nil {
+		return err
+	}
+	if lowerExt(entry.Name) != ".x" {
+		return fmt.Errorf("%s is not a .x model", entry.Name)
+	}
+
+	data, err := readEntry(reader, entry)
+	if err != nil {
+		return err
+	}
+	original, err := xfile.Parse(data)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", entry.Name, err)
+	}
+	originalScene, err := original.Scene()
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", entry.Name, err)
+	}
+
+	document, err := gltf.Read(inputPath)
+	if err != nil {
+		return err
+	}
+
+	names := reader.Layout().NameEncoding
+	importer := newGLTFImporter(document, names, originalScene)
+	importer.textureFilename = importTextureNames(reader, entry, originalScene, names)
+	scene, err := importer.importScene()
+	if err != nil {
+		return fmt.Errorf("error importing %s: %w", inputPath, err)
+	}
+
+	model := &xfile.File{
+		Header:    xfile.Header{Version: original.Header.Version, Encoding: xfile.EncodingText, FloatSize: original.Header.FloatSize},
+		Templates: original.Templates,
+	}
+	for _, object := range original.Objects {
+		if object.Type == "Header" {
+			model.Objects = append(model.Objects, object)
+		}
+	}
+
+	originalTypes := objectTypes(original.Objects, make(map[string]bool))
+	objects, leftOut := removeObjectTypes(scene.Objects(), func(typeName string) bool {
+		return slices.Contains(optionalTemplates, typeName) && !originalTypes[typeName]
+	})
+	model.Objects = append(model.Objects, objects...)
+	for _, typeName := range leftOut {
+		fmt.Printf("Warning: the original model has no %s, they are left out\n", typeName)
+	}
+
+	var notImported []string
+	importedTypes := objectTypes(model.Objects, make(map[string]bool))
+	for typeName := range originalTypes {
+		if typeName != "" && !importedTypes[typeName] {
+			notImported = append(notImported, typeName)
+		}
+	}
+	if len(notImported) > 0 {
+		slices.Sort(notImported)
+		fmt.Printf("Warning: the original model has objects the import does not write: %s\n", strings.Join(notImported, ", "))
+	}
+
+	for _, warning := range compareModelBones(originalScene, scene, names) {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+
+	var text bytes.Buffer
+	if err := model.WriteText(&text); err != nil {
+		return fmt.Errorf("error writing %s: %w", outputPath, err)
+	}
+	if err := os.WriteFile(outputPath, text.Bytes(), 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %w", outputPath, err)
+	}
+
+	fmt.Printf("Imported %s to %s for %s: %d meshes, %d bones, %d animation sets, %d ticks per second\n",
+		inputPath, outputPath, entry.Name, len(sceneMeshes(scene)), len(modelBones(scene)), len(scene.AnimationSets), scene.TicksPerSecond)
+	fmt.Printf("Patch it into the bundle with -single-patch %s:%d\n", outputPath, entry.Index)
+	return nil
+}
+
+// objectTypes adds the templates of objects and of their children to types and returns it, references count as ""
+func objectTypes(objects []*xfile.Object, types map[string]bool) map[string]bool {
+	for _, object := range objects {
+		types[object.Type] = true
+		objectTypes(object.Children, types)
+	}
+	return types
+}
+
+// removeObjectTypes returns objects without the objects, children included, whose template is removed,
+// and the templates of the objects removed
+func removeObjectTypes(objects []*xfile.Object, removed func(typeName string) bool) ([]*xfile.Object, []string) {
+	var kept []*xfile.Object
+	var removedTypes []string
+	for _, object := range objects {
+		if removed(object.Type) {
+			if !slices.Contains(removedTypes, object.Type) {
+				removedTypes = append(removedTypes, object.Type)
+			}
+			continue
+		}
+
+		var childTypes []string
+		object.Children, childTypes = removeObjectTypes(object.Children, removed)
+		for _, typeName := range childTypes {
+			if !slices.Contains(removedTypes, typeName) {
+				removedTypes = append(removedTypes, typeName)
+			}
+		}
+		kept = append(kept, object)
+	}
+	return kept, removedTypes
+}
+
+// importTextureNames returns a function giving the TextureFilename of an image of an imported model.
+// Images exported by -export-model get the texture names of the original model back, others are named
+// after the image file, in the directory and with the extension of the textures of the original model.
+func importTextureNames(reader *bundle.Reader, model *bundle.Entry, scene *xfile.Scene, names encoding.Encoding) func(image string) string {
+	known := make(map[string]string)
+	directory, extension := "", ".bmp"
+	for _, material := range sceneMaterials(scene) {
+		textureName := material.TextureFilename
+		if textureName == "" {
+			continue
+		}
+		decoded := decodeModelText(names, textureName)
+		if len(known) == 0 {
+			directory = decoded[:strings.LastIndexAny(decoded, `\/`)+1]
+			if ext := entryExt(decoded); ext != "" {
+				extension = ext
+			}
+		}
+
+		if entry := textureEntry(reader.Entries(), model.Name, decoded); entry != nil {
+			known[strings.ToLower(texturePNGName(entry))] = textureName
+		}
+		if base := strings.ToLower(textureBaseName(decoded)); known[base] == "" {
+			known[base] = textureName
+		}
+	}
+
+	return func(image string) string {
+		if textureName, ok := known[strings.ToLower(image)]; ok {
+			return textureName
+		}
+		if textureName, ok := known[strings.ToLower(textureBaseName(image))]; ok {
+			return textureName
+		}
+
+		textureName := directory + textureBaseName(image) + extension
+		if textureEntry(reader.Entries(), model.Name, textureName) == nil {
+			fmt.Printf("Warning: texture %s is not in the bundle, add it as %s.cnv\n", textureName, textureBaseName(textureName))
+		}
+		return encodeModelText(names, textureName)
+	}
+}
+
+// encodeModelText converts UTF-8 text to the name encoding of the bundle, for the strings of a model.
+// Text that does not encode is returned as it is.
+func encodeModelText(names encoding.Encoding, text string) string {
+	encoded, err := names.NewEncoder().String(text)
+	if err != nil {
+		return text
+	}
+	return encoded
+}
+
+// modelName converts a name of a glTF document to a name of a .x model in the name encoding of the bundle.
+// Characters that end names in text .x files are replaced with underscores, like the spaces and dots of
+// Blender names, and names starting with a digit get an underscore first so they do not read as numbers.
+func modelName(names encoding.Encoding, name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-':
+			b.WriteRune(c)
+		case c < 0x80:
+			b.WriteByte('_')
+		default:
+			// Second bytes of Shift JIS characters can be delimiters too
+			encoded, err := names.NewEncoder().String(string(c))
+			if err != nil || strings.ContainsAny(encoded, " \t\r\n{}[]<>;,\"") {
+				b.WriteByte('_')
+			} else {
+				b.WriteString(encoded)
+			}
+		}
+	}
+
+	result := b.String()
+	if result != "" && result[0] >= '0' && result[0] <= '9' {
+		result = "_" + result
+	}
+	return result
+}
+
+// modelBones returns the names of the frames the skinned meshes of a scene are weighted to, in order of first use
+func modelBones(scene *xfile.Scene) []string {
+	var bones []string
+	for _, mesh := range sceneMeshes(scene) {
+		for _, skin := range mesh.Skins {
+			if !slices.Contains(bones, skin.Frame) {
+				bones = append(bones, skin.Frame)
+			}
+		}
+	}
+	return bones
+}
+
+// compareModelBones returns warnings about the bones of a model that differ from the ones of the model it replaces.
+// The game animates models with the bone names of the original, other bones stay in their bind pose.
+func compareModelBones(original, replacement *xfile.Scene, names encoding.Encoding) []string {
+	originalBones, bones := modelBones(original), modelBones(replacement)
+	var warnings []string
+	if len(bones) != len(originalBones) {
+		warnings = append(warnings, fmt.Sprintf("the model has %d bones, the original has %d", len(bones), len(originalBones)))
+	}
+
+	var missing, added []string
+	for _, bone := range originalBones {
+		if !slices.Contains(bones, bone) {
+			missing = append(missing, decodeModelText(names, bone))
+		}
+	}
+	for _, bone := range bones {
+		if !slices.Contains(originalBones, bone) {
+			added = append(added, decodeModelText(names, bone))
+		}
+	}
+	if len(missing) > 0 {
+		warnings = append(warnings, fmt.Sprintf("bones of the original missing from the model: %s", strings.Join(missing, ", ")))
+	}
+	if len(added) > 0 {
+		warnings = append(warnings, fmt.Sprintf("bones of the model not in the original: %s", strings.Join(added, ", ")))
+	}
+	return warnings
+}
+
+// gltfImporter converts a glTF document to a scene, the reverse of gltfExporter.
+//
+// Nodes become frames, except the nodes holding only a mesh, which are added to the frame of their parent
+// like the exporter writes them. The scene is mirrored back along Z and the winding of the faces reversed.
+type gltfImporter struct {
+	document        *gltf.Document
+	names           encoding.Encoding
+	ticksPerSecond  int
+	matrixKeys      bool                       // Whether animations are written as matrix keys, like the ones of the original
+	materials       map[string]*xfile.Material // Materials of the original model by name, for the values glTF does not have
+	textureFilename func(image string) string  // TextureFilename of an image file
+	frames          map[int]bool               // Nodes imported as frames
+	visited         map[int]bool
+}
+
+func newGLTFImporter(document *gltf.Document, names encoding.Encoding, original *xfile.Scene) *gltfImporter {
+	importer := &gltfImporter{
+		document:        document,
+		names:           names,
+		ticksPerSecond:  original.TicksPerSecond,
+		materials:       make(map[string]*xfile.Material),
+		textureFilename: func(image string) string { return encodeModelText(names, image) },
+		frames:          make(map[int]bool),
+		visited:         make(map[int]bool),
+	}
+	for _, material := range sceneMaterials(original) {
+		if _, ok := importer.materials[material.Name]; !ok && material.Name != "" {
+			importer.materials[material.Name] = material
+		}
+	}
+
+	// Matrix keys are kept when the original has no other kind
+	for _, set := range original.AnimationSets {
+		for _, animation := range set.Animations {
+			for _, key := range animation.Keys {
+				if key.Type != xfile.KeyMatrix {
+					return importer
+				}
+				importer.matrixKeys = true
+			}
+		}
+	}
+	return importer
+}
+
+func (im *gltfImporter) importScene() (*xfile.Scene, error) {
+	scene := &xfile.Scene{TicksPerSecond: im.ticksPerSecond}
+	for _, root := range im.rootNodes() {
+		if err := im.addNode(root, nil, scene); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range im.document.Animations {
+		set, err := im.animationSet(i)
+		if err != nil {
+			return nil, err
+		}
+		if len(set.Animations) > 0 {
+			scene.AnimationSets = append(scene.AnimationSets, set)
+		}
+	}
+	return scene, nil
+}
+
+// rootNodes returns the nodes of the scene of the document, or the nodes no other node has as child without scene
+func (im *gltfImporter) rootNodes() []int {
+	if len(im.document.Scenes) > 0 {
+		scene := 0
+		if im.document.Scene != nil && *im.document.Scene >= 0 && *im.document.Scene < len(im.document.Scenes) {
+			scene = *im.document.Scene
+		}
+		return im.document.Scenes[scene].Nodes
+	}
+
+	children := make(map[int]bool)
+	for _, node := range im.document.Nodes {
+		for _, child := range node.Children {
+			children[child] = true
+		}
+	}
+	var roots []int
+	for i := range im.document.Nodes {
+		if !children[i] {
+			roots = append(roots, i)
+		}
+	}
+	return roots
+}
+
+// frameName returns the name of the frame of a node
+func (im *gltfImporter) frameName(node int) string {
+	name := ""
+	if node >= 0 && node < len(im.document.Nodes) {
+		name = modelName(im.names, im.document.Nodes[node].Name)
+	}
+	if name == "" {
+		name = fmt.Sprintf("Frame_%d", node)
+	}
+	return name
+}
+
+// addNode adds a node and its children to parent, or to the scene for root nodes
+func (im *gltfImporter) addNode(index int, parent *xfile.Frame, scene *xfile.Scene) error {
+	if index < 0 || index >= len(im.document.Nodes) {
+		return fmt.Errorf("node %d not found", index)
+	}
+	if im.visited[index] {
+		return fmt.Errorf("node %d is used twice", index)
+	}
+	im.visited[index] = true
+	node := im.document.Nodes[index]
+
+	// Skinned meshes ignore the transformation of their node
+	if node.Mesh != nil && len(node.Children) == 0 && (node.Skin != nil || nodeMatrix(node) == xfile.Identity) {
+		mesh, err := im.mesh(index)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			scene.Meshes = append(scene.Meshes, mesh)
+		} else {
+			parent.Meshes = append(parent.Meshes, mesh)
+		}
+		return nil
+	}
+
+	frame := &xfile.Frame{Name: im.frameName(index), Transform: mirrorMatrix(nodeMatrix(node))}
+	im.frames[index] = true
+	if node.Mesh != nil {
+		mesh, err := im.mesh(index)
+		if err != nil {
+			return err
+		}
+		frame.Meshes = append(frame.Meshes, mesh)
+	}
+	for _, child := range node.Children {
+		if err := im.addNode(child, frame, scene); err != nil {
+			return err
+		}
+	}
+
+	if parent == nil {
+		scene.Frames = append(scene.Frames, frame)
+	} else {
+		parent.Children = append(parent.Children, frame)
+	}
+	return nil
+}
+
+// mesh converts the mesh of a node, with the skin of the node. Primitives sharing their positions share
+// their vertices, as in the documents of the exporter.
+func (im *gltfImporter) mesh(nodeIndex int) (*xfile.Mesh, error) {
+	node := im.document.Nodes[nodeIndex]
+	if *node.Mesh < 0 || *node.Mesh >= len(im.document.Meshes) {
+		return nil, fmt.Errorf("node %d refers to the unknown mesh %d", nodeIndex, *node.Mesh)
+	}
+	gltfMesh := im.document.Meshes[*node.Mesh]
+	name := gltfMesh.Name
+	if name == "" {
+		name = node.Name
+	}
+	mesh := &xfile.Mesh{Name: modelName(im.names, name)}
+
+	var skin *gltf.Skin
+	if node.Skin != nil {
+		if *node.Skin < 0 || *node.Skin >= len(im.document.Skins) {
+			return nil, fmt.Errorf("node %d refers to the unknown skin %d", nodeIndex, *node.Skin)
+		}
+		skin = im.document.Skins[*node.Skin]
+	}
+
+	var normals [][3]float32
+	var vertexJoints []uint32
+	var vertexWeights []float32
+	bases := make(map[int]uint32) // First vertex of the positions of every POSITION accessor
+	materials := make(map[int]uint32)
+	hasMaterials := false
+
+	for i, primitive := range gltfMesh.Primitives {
+		if primitive.Mode != nil && *primitive.Mode != 4 {
+			fmt.Printf("Warning: primitive %d of mesh %s is not made of triangles, it is left out\n", i, name)
+			continue
+		}
+		positionAccessor, ok := primitive.Attributes["POSITION"]
+		if !ok {
+			fmt.Printf("Warning: primitive %d of mesh %s has no positions, it is left out\n", i, name)
+			continue
+		}
+
+		base, seen := bases[positionAccessor]
+		if !seen {
+			base = uint32(len(mesh.Positions))
+			bases[positionAccessor] = base
+			positions, err := im.document.ReadFloats(positionAccessor)
+			if err != nil {
+				return nil, fmt.Errorf("mesh %s: %w", name, err)
+			}
+			count := len(positions) / 3
+			for j := 0; j+3 <= len(positions); j += 3 {
+				mesh.Positions = append(mesh.Positions, [3]float32{positions[j], positions[j+1], -positions[j+2]})
+			}
+
+			attribute := func(attributeName string, size int) ([]float32, error) {
+				accessor, ok := primitive.Attributes[attributeName]
+				if !ok {
+					return nil, nil
+				}
+				values, err := im.document.ReadFloats(accessor)
+				if err == nil && len(values) != count*size {
+					err = fmt.Errorf("%s has %d values for %d vertices", attributeName, len(values)/size, count)
+				}
+				return values, err
+			}
+			primitiveNormals, err := attribute("NORMAL", 3)
+			if err != nil {
+				return nil, fmt.Errorf("mesh %s: %w", name, err)
+			}
+			for j := 0; j+3 <= len(primitiveNormals); j += 3 {
+				normals = append(normals, [3]float32{primitiveNormals[j], primitiveNormals[j+1], -primitiveNormals[j+2]})
+			}
+			texCoords, err := attribute("TEXCOORD_0", 2)
+			if err != nil {
+				return nil, fmt.Errorf("mesh %s: %w", name, err)
+			}
+			for j := 0; j+2 <= len(texCoords); j += 2 {
+				mesh.TexCoords = append(mesh.TexCoords, [2]float32{texCoords[j], texCoords[j+1]})
+			}
+
+			if skin != nil {
+				weights, err := attribute("WEIGHTS_0", 4)
+				if err != nil {
+					return nil, fmt.Errorf("mesh %s: %w", name, err)
+				}
+				joints := make([]uint32, count*4)
+				if accessor, ok := primitive.Attributes["JOINTS_0"]; ok {
+					if joints, err = im.document.ReadUints(accessor); err == nil && len(joints) != count*4 {
+						err = fmt.Errorf("JOINTS_0 has %d values for %d vertices", len(joints)/4, count)
+					}
+					if err != nil {
+						return nil, fmt.Errorf("mesh %s: %w", name, err)
+					}
+				}
+				if weights == nil {
+					weights = make([]float32, count*4)
+				}
+				vertexJoints = append(vertexJoints, joints...)
+				vertexWeights = append(vertexWeights, weights...)
+			}
+		}
+
+		vertexCount := uint32(im.document.Accessors[positionAccessor].Count)
+		var indices []uint32
+		if primitive.Indices != nil {
+			var err error
+			if indices, err = im.document.ReadUints(*primitive.Indices); err != nil {
+				return nil, fmt.Errorf("mesh %s: %w", name, err)
+			}
+		} else {
+			for j := range vertexCount {
+				indices = append(indices, j)
+			}
+		}
+
+		material := uint32(0)
+		materialIndex := -1
+		if primitive.Material != nil {
+			materialIndex = *primitive.Material
+			hasMaterials = true
+		}
+		if index, ok := materials[materialIndex]; ok {
+			material = index
+		} else {
+			gltfMaterial, err := im.material(materialIndex)
+			if err != nil {
+				return nil, fmt.Errorf("mesh %s: %w", name, err)
+			}
+			material = uint32(len(mesh.Materials))
+			materials[materialIndex] = material
+			mesh.Materials = append(mesh.Materials, gltfMaterial)
+		}
+
+		// Winding is reversed along with the mirroring
+		for j := 0; j+3 <= len(indices); j += 3 {
+			a, b, c := indices[j], indices[j+1], indices[j+2]
+			if a >= vertexCount || b >= vertexCount || c >= vertexCount {
+				return nil, fmt.Errorf("mesh %s: triangle %d of primitive %d refers to vertices past %d", name, j/3, i, vertexCount)
+			}
+			mesh.Faces = append(mesh.Faces, []uint32{base + a, base + c, base + b})
+			mesh.FaceMaterials = append(mesh.FaceMaterials, material)
+		}
+	}
+
+	if len(normals) == len(mesh.Positions) {
+		mesh.Normals = normals
+		mesh.NormalFaces = mesh.Faces
+	} else if len(normals) > 0 {
+		fmt.Printf("Warning: some primitives of mesh %s have no normals, they are left out\n", name)
+	}
+	if len(mesh.TexCoords) != len(mesh.Positions) {
+		if len(mesh.TexCoords) > 0 {
+			fmt.Printf("Warning: some primitives of mesh %s have no texture coordinates, they are left out\n", name)
+		}
+		mesh.TexCoords = nil
+	}
+	if !hasMaterials {
+		mesh.Materials, mesh.FaceMaterials = nil, nil
+	}
+
+	if skin != nil {
+		if err := im.meshSkin(mesh, skin, vertexJoints, vertexWeights); err != nil {
+			return nil, fmt.Errorf("mesh %s: %w", name, err)
+		}
+	}
+	return mesh, nil
+}
+
+// meshSkin adds the skin weights of a mesh, a SkinWeights for every joint of the skin
+func (im *gltfImporter) meshSkin(mesh *xfile.Mesh, skin *gltf.Skin, joints []uint32, weights []float32) error {
+	var inverseBindMatrices []float32
+	if skin.InverseBindMatrices != nil {
+		var err error
+		inverseBindMatrices, err = im.document.ReadFloats(*skin.InverseBindMatrices)
+		if err != nil {
+			return err
+		}
+		if len(inverseBindMatrices) != 16*len(skin.Joints) {
+			return fmt.Errorf("skin %s has %d inverse bind matrices for %d joints", skin.Name, len(inverseBindMatrices)/16, len(skin.Joints))
+		}
+	}
+
+	for i, joint := range skin.Joints {
+		offset := xfile.Identity
+		if inverseBindMatrices != nil {
+			copy(offset[:], inverseBindMatrices[i*16:])
+		}
+		if !im.frames[joint] {
+			fmt.Printf("Warning: joint %s of skin %s is not a frame\n", im.frameName(joint), skin.Name)
+		}
+		mesh.Skins = append(mesh.Skins, &xfile.SkinWeights{Frame: im.frameName(joint), Offset: mirrorMatrix(offset)})
+	}
+
+	for i, weight := range weights {
+		if weight <= 0 {
+			continue
+		}
+		joint := joints[i]
+		if int(joint) >= len(mesh.Skins) {
+			return fmt.Errorf("vertex %d uses joint %d of %d", i/4, joint, len(mesh.Skins))
+		}
+		mesh.Skins[joint].Vertices = append(mesh.Skins[joint].Vertices, uint32(i/4))
+		mesh.Skins[joint].Weights = append(mesh.Skins[joint].Weights, weight)
+	}
+	return nil
+}
+
+// material converts a glTF material, -1 gives the white material of primitives without material.
+// The specular power and color glTF does not have are taken from the original material of the same name.
+func (im *gltfImporter) material(index int) (*xfile.Material, error) {
+	material := &xfile.Material{FaceColor: [4]float32{1, 1, 1, 1}}
+	if index < 0 {
+		return material, nil
+	}
+	if index >= len(im.document.Materials) {
+		return nil, fmt.Errorf("material %d not found", index)
+	}
+
+	gltfMaterial := im.document.Materials[index]
+	material.Name = modelName(im.names, gltfMaterial.Name)
+	if original := im.materials[material.Name]; original != nil {
+		material.Power, material.Specular = original.Power, original.Specular
+	}
+	if gltfMaterial.EmissiveFactor != nil {
+		material.Emissive = *gltfMaterial.EmissiveFactor
+	}
+
+	if pbr := gltfMaterial.PBRMetallicRoughness; pbr != nil {
+		if pbr.BaseColorFactor != nil {
+			material.FaceColor = *pbr.BaseColorFactor
+		}
+		if pbr.BaseColorTexture != nil {
+			image := im.imageName(pbr.BaseColorTexture.Index)
+			if image == "" {
+				fmt.Printf("Warning: the texture of material %s has no image file name, it is left out\n", gltfMaterial.Name)
+			} else {
+				material.TextureFilename = im.textureFilename(image)
+			}
+		}
+	}
+	return material, nil
+}
+
+// imageName returns the file name of the image of a texture, or the name of embedded images
+func (im *gltfImporter) imageName(texture int) string {
+	if texture < 0 || texture >= len(im.document.Textures) {
+		return ""
+	}
+	source := im.document.Textures[texture].Source
+	if source == nil || *source < 0 || *source >= len(im.document.Images) {
+		return ""
+	}
+
+	image := im.document.Images[*source]
+	if image.URI != "" && !strings.HasPrefix(image.URI, "data:") {
+		name, err := url.PathUnescape(image.URI)
+		if err != nil {
+			name = image.URI
+		}
+		return path.Base(name)
+	}
+	return image.Name
+}
+
+// channelKeys are the keys of an animation channel, with cubic spline tangents left out
+type channelKeys struct {
+	path   string // translation, rotation or scale
+	times  []float32
+	values []float32
+	step   bool
+}
+
+// size returns the number of values of every key
+func (k *channelKeys) size() int {
+	if k.path == "rotation" {
+		return 4
+	}
+	return 3
+}
+
+// animationSet converts a glTF animation, every animated frame gets an Animation
+func (im *gltfImporter) animationSet(index int) (*xfile.AnimationSet, error) {
+	animation := im.document.Animations[index]
+	set := &xfile.AnimationSet{Name: modelName(im.names, animation.Name)}
+	if set.Name == "" {
+		set.Name = fmt.Sprintf("Animation_%d", index)
+	}
+
+	channels := make(map[int][]*channelKeys)
+	var nodes []int
+	for _, channel := range animation.Channels {
+		property := channel.Target.Path
+		if channel.Target.Node == nil || (property != "translation" && property != "rotation" && property != "scale") {
+			continue
+		}
+		node := *channel.Target.Node
+		if !im.frames[node] {
+			fmt.Printf("Warning: animation %s animates %s, which is not a frame, it is left out\n", animation.Name, im.frameName(node))
+			continue
+		}
+		if channel.Sampler < 0 || channel.Sampler >= len(animation.Samplers) {
+			return nil, fmt.Errorf("animation %s: sampler %d not found", animation.Name, channel.Sampler)
+		}
+
+		sampler := animation.Samplers[channel.Sampler]
+		keys := &channelKeys{path: property, step: sampler.Interpolation == "STEP"}
+		var err error
+		if keys.times, err = im.document.ReadFloats(sampler.Input); err != nil {
+			return nil, fmt.Errorf("animation %s: %w", animation.Name, err)
+		}
+		if keys.values, err = im.document.ReadFloats(sampler.Output); err != nil {
+			return nil, fmt.Errorf("animation %s: %w", animation.Name, err)
+		}
+
+		size := keys.size()
+		if sampler.Interpolation == "CUBICSPLINE" {
+			// Every key is an in tangent, a value and an out tangent
+			var values []float32
+			for i := 0; i+3*size <= len(keys.values); i += 3 * size {
+				values = append(values, keys.values[i+size:i+2*size]...)
+			}
+			keys.values = values
+		}
+		if len(keys.values) != len(keys.times)*size || len(keys.times) == 0 {
+			return nil, fmt.Errorf("animation %s: %s of %s has %d values for %d keys",
+				animation.Name, property, im.frameName(node), len(keys.values)/size, len(keys.times))
+		}
+
+		if _, ok := channels[node]; !ok {
+			nodes = append(nodes, node)
+		}
+		channels[node] = append(channels[node], keys)
+	}
+
+	for _, node := range nodes {
+		frameAnimation := &xfile.Animation{Frame: im.frameName(node)}
+		if im.matrixKeys {
+			frameAnimation.Keys = []*xfile.AnimationKey{im.matrixKey(im.document.Nodes[node], channels[node])}
+		} else {
+			for _, keys := range channels[node] {
+				frameAnimation.Keys = append(frameAnimation.Keys, im.animationKey(keys))
+			}
+		}
+		set.Animations = append(set.Animations, frameAnimation)
+	}
+	return set, nil
+}
+
+// tick converts a key time in seconds to ticks
+func (im *gltfImporter) tick(seconds float32) uint32 {
+	return uint32(max(math.Round(float64(seconds)*float64(im.ticksPerSecond)), 0))
+}
+
+// animationKey converts the keys of a channel to an AnimationKey of the same kind, mirrored back along Z
+func (im *gltfImporter) animationKey(keys *channelKeys) *xfile.AnimationKey {
+	key := &xfile.AnimationKey{}
+	size := keys.size()
+	for i, seconds := range keys.times {
+		value := keys.values[i*size : (i+1)*size]
+		switch keys.path {
+		case "translation":
+			key.Type = xfile.KeyPosition
+			value = []float32{value[0], value[1], -value[2]}
+		case "rotation":
+			key.Type = xfile.KeyRotation
+			q := mirrorQuaternion([4]float32(value))
+			value = []float32{q[3], q[0], q[1], q[2]}
+		case "scale":
+			key.Type = xfile.KeyScale
+			value = slices.Clone(value)
+		}
+		key.Times = append(key.Times, im.tick(seconds))
+		key.Values = append(key.Values, value)
+	}
+	return key
+}
+
+// matrixKey converts the channels of a node to a matrix key, sampling every channel at the times of all of them.
+// Properties without channel keep the value of the node.
+func (im *gltfImporter) matrixKey(node *gltf.Node, channels []*channelKeys) *xfile.AnimationKey {
+	var times []float32
+	for _, keys := range channels {
+		times = append(times, keys.times...)
+	}
+	slices.Sort(times)
+	times = slices.Compact(times)
+
+	key := &xfile.AnimationKey{Type: xfile.KeyMatrix}
+	for _, seconds := range times {
+		translation, rotation, scale := nodeTRS(node)
+		for _, keys := range channels {
+			value := sampleKeys(keys, seconds)
+			switch keys.path {
+			case "translation":
+				translation = [3]float32(value)
+			case "rotation":
+				rotation = [4]float32(value)
+			case "scale":
+				scale = [3]float32(value)
+			}
+		}
+		matrix := mirrorMatrix(composeMatrix(translation, rotation, scale))
+		key.Times = append(key.Times, im.tick(seconds))
+		key.Values = append(key.Values, matrix[:])
+	}
+	return key
+}
+
+// sampleKeys returns the value of a channel at a time, rotations are interpolated linearly and normalized
+func sampleKeys(keys *channelKeys, seconds float32) []float32 {
+	size := keys.size()
+	next, found := slices.BinarySearch(keys.times, seconds)
+	switch {
+	case found:
+		return keys.values[next*size : (next+1)*size]
+	case next == 0:
+		return keys.values[:size]
+	case next == len(keys.times) || keys.step:
+		return keys.values[(next-1)*size : next*size]
+	}
+
+	previous, following := keys.values[(next-1)*size:next*size], keys.values[next*size:(next+1)*size]
+	t := (seconds - keys.times[next-1]) / (keys.times[next] - keys.times[next-1])
+	sign := float32(1)
+	if keys.path == "rotation" && previous[0]*following[0]+previous[1]*following[1]+previous[2]*following[2]+previous[3]*following[3] < 0 {
+		sign = -1
+	}
+	value := make([]float32, size)
+	var length float32
+	for i := range value {
+		value[i] = previous[i]*(1-t) + sign*following[i]*t
+		length += value[i] * value[i]
+	}
+	if keys.path == "rotation" && length > 0 {
+		length = float32(math.Sqrt(float64(length)))
+		for i := range value {
+			value[i] /= length
+		}
+	}
+	return value
+}
+
+// nodeTRS returns the translation, rotation and scale of a node
+func nodeTRS(node *gltf.Node) (translation [3]float32, rotation [4]float32, scale [3]float32) {
+	if node.Matrix != nil {
+		return decomposeMatrix(xfile.Matrix(*node.Matrix))
+	}
+	rotation, scale = [4]float32{0, 0, 0, 1}, [3]float32{1, 1, 1}
+	if node.Translation != nil {
+		translation = *node.Translation
+	}
+	if node.Rotation != nil {
+		rotation = *node.Rotation
+	}
+	if node.Scale != nil {
+		scale = *node.Scale
+	}
+	return translation, rotation, scale
+}
+
+// nodeMatrix returns the transformation of a node as a glTF matrix
+func nodeMatrix(node *gltf.Node) xfile.Matrix {
+	if node.Matrix != nil {
+		return xfile.Matrix(*node.Matrix)
+	}
+	return composeMatrix(nodeTRS(node))
+}
+
+// composeMatrix returns the glTF matrix of a translation, a rotation quaternion x, y, z, w and a scale, the reverse of decomposeMatrix
+func composeMatrix(translation [3]float32, rotation [4]float32, scale [3]float32) xfile.Matrix {
+	x, y, z, w := float64(rotation[0]), float64(rotation[1]), float64(rotation[2]), float64(rotation[3])
+	if length := math.Sqrt(x*x + y*y + z*z + w*w); length > 0 {
+		x, y, z, w = x/length, y/length, z/length, w/length
+	}
+
+	// r[row][column] of the rotation matrix
+	r := [3][3]float64{
+		{1 - 2*(y*y+z*z), 2 * (x*y - z*w), 2 * (x*z + y*w)},
+		{2 * (x*y + z*w), 1 - 2*(x*x+z*z), 2 * (y*z - x*w)},
+		{2 * (x*z - y*w), 2 * (y*z + x*w), 1 - 2*(x*x+y*y)},
+	}
+	var m xfile.Matrix
+	for column := range 3 {
+		for row := range 3 {
+			m[column*4+row] = float32(r[row][column] * float64(scale[column]))
+		}
+	}
+	m[12], m[13], m[14], m[15] = translation[0], translation[1], translation[2], 1
+	return m
+}
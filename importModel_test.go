@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"BundleTools/xfile"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestImportModel(t *testing.T) {
+	dir := t.TempDir()
+	datFilePath := filepath.Join(dir, "test.dat")
+	names := []string{`chara\model.x`, `texture\face.cnv`}
+	contents := [][]byte{[]byte(testModel), createTestCnvImage(32, 2, 2, 2)}
+	createTestBundle(t, datFilePath, names, contents)
+
+	gltfPath := filepath.Join(dir, "model.gltf")
+	if err := exportModel(datFilePath, `chara\model.x`, gltfPath); err != nil {
+		t.Fatal(err)
+	}
+	outputPath := filepath.Join(dir, "model.x")
+	if err := importModel(datFilePath, gltfPath, "0", outputPath); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := xfile.Parse(data)
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, data)
+	}
+	scene, err := model.Scene()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The exported model comes back in the coordinates of Direct3D, with the templates of the original
+	if scene.TicksPerSecond != 30 {
+		t.Errorf("got %d ticks per second, want 30", scene.TicksPerSecond)
+	}
+	if model.Find("MeshNormals", "") != nil || model.Find("XSkinMeshHeader", "") != nil {
+		t.Errorf("optional templates missing from the original are written:\n%s", data)
+	}
+	root := scene.Frames[0]
+	if root.Name != "Root" || root.Transform[14] != 2 || len(root.Children) != 1 || root.Children[0].Name != "Bone" {
+		t.Fatalf("got frame %+v", root)
+	}
+
+	mesh := root.Meshes[0]
+	corner := slices.Index(mesh.Positions, [3]float32{1, 1, 1})
+	if mesh.Name != "Quad" || len(mesh.Positions) != 4 || corner < 0 || len(mesh.Faces) != 2 {
+		t.Fatalf("got mesh %+v", mesh)
+	}
+	if len(mesh.TexCoords) != 4 || mesh.TexCoords[corner] != [2]float32{1, 1} {
+		t.Errorf("got texture coordinates %v", mesh.TexCoords)
+	}
+	// The texture name is the one of the original, which the test model stores with doubled backslashes
+	if len(mesh.Materials) != 1 || mesh.Materials[0].TextureFilename != `..\\texture\\Face.bmp` {
+		t.Errorf("got materials %+v", mesh.Materials)
+	}
+
+	// The first triangle keeps the clockwise winding of the quad
+	if face := mesh.Faces[0]; mesh.Positions[face[0]] != [3]float32{0, 0, 0} || mesh.Positions[face[1]] != [3]float32{1, 0, 0} {
+		t.Errorf("got face %v of positions %v", face, mesh.Positions)
+	}
+
+	skin := mesh.Skins[0]
+	if len(mesh.Skins) != 1 || skin.Frame != "Bone" || len(skin.Vertices) != 4 || skin.Offset[14] != -2 {
+		t.Errorf("got skin weights %+v", mesh.Skins)
+	}
+
+	key := scene.AnimationSets[0].Animations[0].Keys[0]
+	if key.Type != xfile.KeyRotation || len(key.Times) != 2 || key.Times[1] != 60 {
+		t.Fatalf("got key %+v", key)
+	}
+	want := []float32{0.707107, 0.707107, 0, 0}
+	for i := range want {
+		if math.Abs(float64(key.Values[1][i]-want[i])) > 1e-5 {
+			t.Errorf("got rotation %v, want %v", key.Values[1], want)
+		}
+	}
+}
+
+func TestCompareModelBones(t *testing.T) {
+	original, err := xfile.Parse([]byte(testModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed, err := xfile.Parse([]byte(strings.ReplaceAll(testModel, "Bone", "Arm")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	originalScene, _ := original.Scene()
+	renamedScene, _ := renamed.Scene()
+
+	if warnings := compareModelBones(originalScene, originalScene, japanese.ShiftJIS); len(warnings) != 0 {
+		t.Errorf("got warnings %v for the same model", warnings)
+	}
+	warnings := compareModelBones(originalScene, renamedScene, japanese.ShiftJIS)
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "Bone") || !strings.Contains(warnings[1], "Arm") {
+		t.Errorf("got warnings %v", warnings)
+	}
+}
+
+func TestModelName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Bip01 L Thigh": "Bip01_L_Thigh",
+		"Bone.001":      "Bone_001",
+		"1st":           "_1st",
+		"顔":             "\x8a\xe7",
+	} {
+		if got := modelName(japanese.ShiftJIS, name); got != want {
+			t.Errorf("modelName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"BundleTools/bundle"
+)
+
+// layoutOption is the layout selected with -layout, nil to detect the layout of every bundle
+var layoutOption *bundle.Layout
+
+// loadLayout returns the built-in layout with the given name, or reads a layout from a JSON file
+func loadLayout(nameOrPath string) (*bundle.Layout, error) {
+	if layout, ok := bundle.LookupLayout(nameOrPath); ok {
+		return layout, nil
+	}
+
+	data, err := os.ReadFile(nameOrPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s is neither a built-in layout nor a layout file", nameOrPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read layout file %s: %w", nameOrPath, err)
+	}
+
+	layout, err := bundle.ParseLayout(data)
+	if err != nil {
+		return nil, fmt.Errorf("error in layout file %s: %w", nameOrPath, err)
+	}
+	return layout, nil
+}
+
+// detectLayout returns the layout and table seed of a bundle, only the seed is detected when -layout was given
+func detectLayout(r io.ReaderAt, size int64) (*bundle.Layout, int, error) {
+	if layoutOption != nil {
+		seed, err := layoutOption.DetectSeed(r, size)
+		return layoutOption, seed, err
+	}
+	return bundle.DetectLayout(r, size, bundle.Layouts())
+}
+
+// defaultLayout returns the layout of new bundles that have no reference to copy it from
+func defaultLayout() *bundle.Layout {
+	if layoutOption != nil {
+		return layoutOption
+	}
+	return bundle.Daybreak
+}
+
+// findLayout returns the layout recorded in a manifest under the given name
+func findLayout(name string) (*bundle.Layout, error) {
+	// Manifests written before layouts were recorded are Daybreak bundles
+	if name == "" {
+		return bundle.Daybreak, nil
+	}
+	if layoutOption != nil && layoutOption.Name == name {
+		return layoutOption, nil
+	}
+	if layout, ok := bundle.LookupLayout(name); ok {
+		return layout, nil
+	}
+	return nil, fmt.Errorf("unknown layout %s, pass its layout file with -layout", name)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenDamagedBundle(t *testing.T) {
+	datFilePath := filepath.Join(t.TempDir(), "test.dat")
+	names := []string{`bgm\title.ogg`, `data\script.txt`, `se\click.ogg`}
+	contents := [][]byte{[]byte("OggS"), []byte("script"), []byte("OggS click")}
+	createTestBundle(t, datFilePath, names, contents)
+
+	// With its last entry cut short, no seed scores high enough to be detected
+	info, err := os.Stat(datFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(datFilePath, info.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	file, reader, err := openBundle(datFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if len(reader.Entries()) != 3 || reader.Seed() != 0 {
+		t.Fatalf("got %d entries with seed %d", len(reader.Entries()), reader.Seed())
+	}
+	data, err := readEntry(reader, reader.Entries()[1])
+	if err != nil || string(data) != "script" {
+		t.Errorf("got %q, %v for an intact entry", data, err)
+	}
+}
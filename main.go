@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -18,15 +19,53 @@ func main() {
 		fmt.Printf("  %s <datfile>                       (Launch GUI mode with DAT file loaded)\n", filepath.Base(os.Args[0]))
 		fmt.Printf("  %s -gui                            (Launch GUI mode)\n", filepath.Base(os.Args[0]))
 		fmt.Printf("  %s <datfile> -list                 (Command line: List content)\n", filepath.Base(os.Args[0]))
-		fmt.Printf("  %s <datfile> -extract <output_folder> [-pattern <files_pattern>] (Command line: Extract images as BMP)\n", filepath.Base(os.Args[0]))
-		fmt.Printf("  %s <datfile> -extract-single <index> <output_file> (Command line: Extract single file by index)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s <datfile> -extract <output_folder> [-pattern <files_pattern>] [-format bmp|png|tga] (Command line: Extract images as BMP, PNG or TGA)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s <datfile> -extract-single <index> <output_file> [-format bmp|png|tga] (Command line: Extract single file by index)\n", filepath.Base(os.Args[0]))
 		fmt.Printf("  %s <datfile> -update <source_files_path> (Command line: Update)\n", filepath.Base(os.Args[0]))
 		fmt.Printf("  %s <datfile> -single-patch <input_file>:<index> (Command line: Patch single file)\n", filepath.Base(os.Args[0]))
-		fmt.Println("  (Note: update and patch operations create backups of the original .DAT file before patching)")
+		fmt.Printf("  %s <datfile> -detect                  (Command line: Detect the table seed and check the payload key)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s <datfile> -verify [-json]          (Command line: Check the archive for problems)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s <datfile> -compact                 (Command line: Remove unused space between entries)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s <datfile> -roundtrip-check [-format bmp|png|tga] (Command line: Check that every converted entry converts back to the same bytes)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s <datfile> -export-model <index|entry_name> <output.gltf> (Command line: Convert a .x model to glTF with its textures as PNG)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s <datfile> -import-model <input.gltf|input.glb> <index|entry_name> <output.x> (Command line: Convert a glTF model to a .x model replacing an entry)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s <datfile> -deps [-json|-dot] (Command line: Report missing and orphaned model textures, or export the model-texture graph)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s <datfile> -add <input_file> <entry_name> (Command line: Add a new entry)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s <datfile> -remove <index> (Command line: Remove an entry)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s <datfile> -rename <index> <new_entry_name> (Command line: Rename an entry)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s -pack <input_folder> <output_datfile> [-reference <original_datfile>] (Command line: Rebuild a DAT from an extracted folder)\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s -x-to-text <input.x> <output.x> (Command line: Convert a binary or compressed .x model to text)\n", filepath.Base(os.Args[0]))
+		fmt.Println("  Any command accepts -layout <layout_name|layout_file.json> to force the archive layout instead of detecting it")
+		fmt.Println("  Extraction commands accept -text-models to convert binary and compressed .x models to text")
+		fmt.Println("  (Note: update, patch, compact, add, remove and rename operations create backups of the original .DAT file before patching)")
 	}
 	// Handle arguments manually for the correct syntax
 	args := os.Args[1:]
 
+	// The layout option applies to every command, take it out of the arguments first
+	if i := slices.Index(args, "-layout"); i >= 0 {
+		if i+1 >= len(args) {
+			fmt.Println("Error: -layout requires a layout name or a layout file")
+			usage()
+			os.Exit(1)
+		}
+
+		layout, err := loadLayout(args[i+1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		layoutOption = layout
+		args = slices.Delete(args, i, i+2)
+	}
+
+	// Like the layout, the model option applies to every extraction command
+	textModels := false
+	if i := slices.Index(args, "-text-models"); i >= 0 {
+		textModels = true
+		args = slices.Delete(args, i, i+1)
+	}
+
 	// Check for GUI mode first
 	if len(args) == 0 {
 		// No arguments - launch GUI
@@ -58,6 +97,44 @@ func main() {
 		return
 	}
 
+	// Packing creates a new DAT file, so it does not take one as first argument
+	if args[0] == "-pack" {
+		if len(args) < 3 {
+			fmt.Println("Error: -pack requires an input folder and an output DAT file")
+			usage()
+			os.Exit(1)
+		}
+		referencePath := ""
+
+		// Check for optional -reference flag
+		if len(args) >= 5 && args[3] == "-reference" {
+			referencePath = args[4]
+		}
+
+		err := packBundle(args[1], args[2], referencePath, conversionOptions{textModels: textModels})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Converting a model works on files outside of any DAT file
+	if args[0] == "-x-to-text" {
+		if len(args) < 3 {
+			fmt.Println("Error: -x-to-text requires an input and an output .x file")
+			usage()
+			os.Exit(1)
+		}
+
+		err := convertModelToText(args[1], args[2])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Command line mode - expect: <datfile> <command> [options]
 	if len(args) < 2 {
 		fmt.Println("Error: You must provide a DAT file and a command for command line operations")
@@ -92,13 +169,23 @@ func main() {
 		}
 		outputFolder := commandArgs[0]
 		pattern := ""
+		imageFormat := conversionBmp
 
-		// Check for optional -pattern flag
-		if len(commandArgs) >= 3 && commandArgs[1] == "-pattern" {
-			pattern = commandArgs[2]
+		// Check for optional -pattern and -format flags
+		for i := 1; i+1 < len(commandArgs); i += 2 {
+			switch commandArgs[i] {
+			case "-pattern":
+				pattern = commandArgs[i+1]
+			case "-format":
+				imageFormat = commandArgs[i+1]
+			}
+		}
+		if !slices.Contains(imageConversions, imageFormat) {
+			fmt.Printf("Error: Invalid format '%s'. Must be one of %s.\n", imageFormat, strings.Join(imageConversions, ", "))
+			os.Exit(1)
 		}
 
-		err := extractBundle(datFile, outputFolder, pattern)
+		err := extractBundle(datFile, outputFolder, pattern, conversionOptions{imageFormat: imageFormat, textModels: textModels})
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -119,9 +206,19 @@ func main() {
 		}
 
 		outputFile := commandArgs[1]
+		imageFormat := conversionBmp
+
+		// Check for optional -format flag
+		if len(commandArgs) >= 4 && commandArgs[2] == "-format" {
+			imageFormat = commandArgs[3]
+		}
+		if !slices.Contains(imageConversions, imageFormat) {
+			fmt.Printf("Error: Invalid format '%s'. Must be one of %s.\n", imageFormat, strings.Join(imageConversions, ", "))
+			os.Exit(1)
+		}
 
 		// Extract the single file
-		err = extractSingleFile(datFile, index, outputFile)
+		err = extractSingleFile(datFile, index, outputFile, conversionOptions{imageFormat: imageFormat, textModels: textModels})
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -168,8 +265,163 @@ func main() {
 			os.Exit(1)
 		}
 
+	case "-detect":
+		err := detectBundle(datFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+	case "-verify":
+		report, err := verifyBundle(datFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		// Check for optional -json flag
+		if len(commandArgs) >= 1 && commandArgs[0] == "-json" {
+			err = writeVerifyReportJSON(os.Stdout, report)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		} else {
+			printVerifyReport(report)
+		}
+
+		if report.Errors > 0 {
+			os.Exit(1)
+		}
+
+	case "-compact":
+		err := compactBundle(datFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+	case "-roundtrip-check":
+		imageFormat := conversionBmp
+
+		// Check for optional -format flag
+		if len(commandArgs) >= 2 && commandArgs[0] == "-format" {
+			imageFormat = commandArgs[1]
+		}
+		if !slices.Contains(imageConversions, imageFormat) {
+			fmt.Printf("Error: Invalid format '%s'. Must be one of %s.\n", imageFormat, strings.Join(imageConversions, ", "))
+			os.Exit(1)
+		}
+
+		failed, err := roundtripBundle(datFile, conversionOptions{imageFormat: imageFormat, textModels: textModels})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+
+	case "-export-model":
+		if len(commandArgs) < 2 {
+			fmt.Println("Error: -export-model requires an entry index or name and an output glTF file")
+			usage()
+			os.Exit(1)
+		}
+
+		err := exportModel(datFile, commandArgs[0], commandArgs[1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+	case "-import-model":
+		if len(commandArgs) < 3 {
+			fmt.Println("Error: -import-model requires an input glTF file, an entry index or name and an output .x file")
+			usage()
+			os.Exit(1)
+		}
+
+		err := importModel(datFile, commandArgs[0], commandArgs[1], commandArgs[2])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+	case "-deps":
+		report, err := bundleDependencies(datFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		// Check for optional -json or -dot flag
+		switch {
+		case len(commandArgs) >= 1 && commandArgs[0] == "-json":
+			err = writeDependencyReportJSON(os.Stdout, report)
+		case len(commandArgs) >= 1 && commandArgs[0] == "-dot":
+			err = writeDependencyGraph(os.Stdout, report)
+		default:
+			printDependencyReport(report)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+	case "-add":
+		if len(commandArgs) < 2 {
+			fmt.Println("Error: -add requires an input file and an entry name")
+			usage()
+			os.Exit(1)
+		}
+
+		err := addEntry(datFile, commandArgs[0], commandArgs[1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+	case "-remove":
+		if len(commandArgs) < 1 {
+			fmt.Println("Error: -remove requires an index")
+			usage()
+			os.Exit(1)
+		}
+
+		index, err := strconv.Atoi(commandArgs[0])
+		if err != nil {
+			fmt.Printf("Error: Invalid index '%s'. Must be a number.\n", commandArgs[0])
+			os.Exit(1)
+		}
+
+		err = removeEntry(datFile, index)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+	case "-rename":
+		if len(commandArgs) < 2 {
+			fmt.Println("Error: -rename requires an index and a new entry name")
+			usage()
+			os.Exit(1)
+		}
+
+		index, err := strconv.Atoi(commandArgs[0])
+		if err != nil {
+			fmt.Printf("Error: Invalid index '%s'. Must be a number.\n", commandArgs[0])
+			os.Exit(1)
+		}
+
+		err = renameEntry(datFile, index, commandArgs[1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
 	default:
-		fmt.Printf("Error: Unknown command '%s'. Must be one of -list, -extract, -update, or -single-patch\n", command)
+		fmt.Printf("Error: Unknown command '%s'. Must be one of -list, -extract, -extract-single, -update, -single-patch, -detect, -verify, -compact, -roundtrip-check, -export-model, -import-model, -deps, -add, -remove or -rename\n", command)
 		usage()
 		os.Exit(1)
 	}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"BundleTools/bundle"
+)
+
+// manifestFileName is the name of the manifest written at the root of an extracted folder
+const manifestFileName = "bundle_manifest.json"
+
+// Conversions applied to entries on extraction, every conversion but conversionNone is the name of a converter
+const (
+	conversionNone    = "none"    // Stored as is
+	conversionWav     = "wav"     // Audio CNV converted to RIFF WAV
+	conversionBmp     = "bmp"     // Image CNV converted to BMP
+	conversionPng     = "png"     // Image CNV converted to PNG
+	conversionTga     = "tga"     // Image CNV converted to TGA
+	conversionJSON    = "json"    // SFL converted to JSON
+	conversionXText   = "xtext"   // Binary or compressed .x model decoded to text, with -text-models
+	conversionUnknown = "unknown" // CNV that could not be converted, stored as is with a .unknown extension
+)
+
+// imageConversions are the formats image CNVs can be extracted to, selected with -format
+var imageConversions = []string{conversionBmp, conversionPng, conversionTga}
+
+// extractManifest records how every entry of a bundle was exported by extractBundle
+type extractManifest struct {
+	Bundle     string           `json:"bundle"`     // Path of the extracted bundle
+	EntryCount int              `json:"entryCount"` // Number of entries in the bundle, Entries may only hold a subset
+	Layout     string           `json:"layout"`     // Name of the bundle layout
+	Seed       int              `json:"seed"`       // Seed the file table is encrypted with
+	Entries    []*manifestEntry `json:"entries"`
+}
+
+// manifestEntry describes a single extracted entry
+type manifestEntry struct {
+	Index       int    `json:"index"`                 // Index of the entry in the file table
+	Name        string `json:"name"`                  // Original name of the entry
+	Offset      uint32 `json:"offset"`                // Offset of the entry in the bundle
+	Length      uint32 `json:"length"`                // Length of the stored entry data
+	DataKey     *uint8 `json:"dataKey,omitempty"`     // First byte of .cnv entries, selects the conversion
+	Conversion  string `json:"conversion"`            // One of the conversion constants
+	OutputPath  string `json:"outputPath"`            // Path of the extracted file, relative to the manifest and slash separated
+	SHA256      string `json:"sha256"`                // Hash of the extracted file contents
+	ImageHeader string `json:"imageHeader,omitempty"` // Hex encoded header of the entries whose converter needs it to convert them back, like image CNVs
+}
+
+// newManifestEntry creates the manifest entry of an extracted file
+func newManifestEntry(entry *bundle.Entry, extractPath string, extracted *extractedEntry) (*manifestEntry, error) {
+	relPath, err := filepath.Rel(extractPath, extracted.outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("error getting relative path of %s: %w", extracted.outputPath, err)
+	}
+
+	return &manifestEntry{
+		Index:       entry.Index,
+		Name:        entry.Name,
+		Offset:      entry.Offset,
+		Length:      entry.Length,
+		DataKey:     extracted.dataKey,
+		Conversion:  extracted.conversion,
+		OutputPath:  filepath.ToSlash(relPath),
+		SHA256:      hex.EncodeToString(extracted.hash),
+		ImageHeader: hex.EncodeToString(extracted.template),
+	}, nil
+}
+
+// writeManifest writes the manifest at the root of an extracted folder
+func writeManifest(extractPath string, manifest *extractManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %w", err)
+	}
+
+	manifestPath := filepath.Join(extractPath, manifestFileName)
+	if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %w", manifestPath, err)
+	}
+	return nil
+}
+
+// readManifest reads the manifest of an extracted folder, it returns nil if there is none
+func readManifest(extractPath string) (*extractManifest, error) {
+	manifestPath := filepath.Join(extractPath, manifestFileName)
+	data, err := os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", manifestPath, err)
+	}
+
+	var manifest extractManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", manifestPath, err)
+	}
+	return &manifest, nil
+}
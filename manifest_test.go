@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"BundleTools/bundle"
+)
+
+// createTestBundle writes a bundle holding contents under names
+func createTestBundle(t *testing.T, datFilePath string, names []string, contents [][]byte) {
+	t.Helper()
+
+	file, err := os.Create(datFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer, err := bundle.NewWriter(file, len(names))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range names {
+		if _, err := writer.Add(name, bytes.NewReader(contents[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractManifest(t *testing.T) {
+	dir := t.TempDir()
+	datFilePath := filepath.Join(dir, "test.dat")
+	names := []string{`bgm\title.ogg`, `image\face.cnv`}
+	// A CNV with a data key no converter handles is extracted as it is
+	contents := [][]byte{[]byte("OggS data"), {7, 1, 2, 3}}
+	createTestBundle(t, datFilePath, names, contents)
+
+	extractPath := filepath.Join(dir, "extracted")
+	if err := extractBundle(datFilePath, extractPath, ".", conversionOptions{imageFormat: conversionBmp}); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readManifest(extractPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest == nil || manifest.EntryCount != 2 || len(manifest.Entries) != 2 {
+		t.Fatalf("got manifest %+v", manifest)
+	}
+
+	file, reader, err := openBundle(datFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// Entry names are joined to the output folder as they are, their backslashes only separate folders on Windows
+	for i, want := range []struct {
+		conversion, outputPath string
+	}{
+		{conversionNone, filepath.ToSlash(`bgm\title.ogg`)},
+		{conversionUnknown, filepath.ToSlash(`image\face.unknown`)},
+	} {
+		manifestEntry, entry := manifest.Entries[i], reader.Entries()[i]
+		if manifestEntry.Index != i || manifestEntry.Name != names[i] || manifestEntry.Offset != entry.Offset || manifestEntry.Length != entry.Length {
+			t.Errorf("entry %d: got %+v, want the position of %+v", i, manifestEntry, entry)
+		}
+		if manifestEntry.Conversion != want.conversion || manifestEntry.OutputPath != want.outputPath {
+			t.Errorf("entry %d: got conversion %s to %s, want %s to %s", i, manifestEntry.Conversion, manifestEntry.OutputPath, want.conversion, want.outputPath)
+		}
+
+		// The hash is the one of the extracted file, so edited files can be told apart
+		extracted, err := os.ReadFile(filepath.Join(extractPath, filepath.FromSlash(manifestEntry.OutputPath)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash := sha256.Sum256(extracted); manifestEntry.SHA256 != hex.EncodeToString(hash[:]) {
+			t.Errorf("entry %d: hash %s does not match the extracted file", i, manifestEntry.SHA256)
+		}
+	}
+
+	if dataKey := manifest.Entries[1].DataKey; dataKey == nil || *dataKey != 7 {
+		t.Errorf("got data key %v, want 7", dataKey)
+	}
+	if manifest.Entries[0].DataKey != nil {
+		t.Errorf("got data key %d for an entry that is not a CNV", *manifest.Entries[0].DataKey)
+	}
+}
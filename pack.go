@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"BundleTools/bundle"
+)
+
+// packItem is a file of an extracted folder together with the entry it restores
+type packItem struct {
+	sourcePath    string    // Path of the extracted file
+	extractedName string    // Path relative to the extracted folder, using backslashes
+	entryName     string    // Name of the entry in the bundle
+	converter     Converter // Converter the file was extracted with, nil when it was stored as is
+	template      []byte    // Header of the original entry for converters needing it, nil when unknown
+}
+
+// packBundle rebuilds a complete bundle from a folder created by extractBundle.
+// The entry names, order and conversions are taken from the reference bundle when
+// one is given, then from the extraction manifest, otherwise entries are stored in path order.
+// The layout and table seed are taken from the same source, the default layout and seed 0 are used when there is none.
+// Without a manifest, options select the converters files are encoded back with.
+func packBundle(inputFolder, outputPath, referencePath string, options conversionOptions) error {
+	items, err := collectPackItems(inputFolder, options)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := readManifest(inputFolder)
+	if err != nil {
+		return err
+	}
+
+	layout, seed := defaultLayout(), 0
+	if referencePath != "" {
+		items, layout, seed, err = orderPackItems(items, referencePath)
+	} else if manifest != nil {
+		items, err = orderPackItemsByManifest(items, manifest)
+		if err == nil {
+			layout, err = findLayout(manifest.Layout)
+		}
+		seed = manifest.Seed
+	} else {
+		fmt.Printf("Warning: no manifest in %s and no -reference, entries are packed in path order with the %s layout, "+
+			"the bundle will not be byte-identical to the original\n", inputFolder, layout.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", outputPath, err)
+	}
+
+	err = writePackItems(outputFile, items, layout, seed)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+
+	fmt.Printf("Successfully packed %d files from %s into %s\n", len(items), inputFolder, outputPath)
+	return nil
+}
+
+// collectPackItems walks an extracted folder and maps every file back to its entry name with the converters options enable
+func collectPackItems(inputFolder string, options conversionOptions) ([]*packItem, error) {
+	var items []*packItem
+	err := filepath.WalkDir(inputFolder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(inputFolder, path)
+		if err != nil {
+			return err
+		}
+		if relPath == manifestFileName {
+			return nil
+		}
+
+		// Entry names use backslashes, extracted names may contain them already
+		extractedName := strings.ReplaceAll(filepath.ToSlash(relPath), "/", `\`)
+
+		item := &packItem{sourcePath: path, extractedName: extractedName, entryName: extractedName}
+		if converter := converterByExtension(path, options); converter != nil {
+			// These are what extractBundle turns entries like .cnv ones into
+			item.entryName = extractedName[:len(extractedName)-len(converter.Extension())] + converter.StoredExtension()
+			item.converter = converter
+		}
+
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", inputFolder, err)
+	}
+	return items, nil
+}
+
+// orderPackItems restores the entry names and order of a reference bundle and returns its layout and table seed.
+// Files that are not part of the reference are appended at the end.
+func orderPackItems(items []*packItem, referencePath string) ([]*packItem, *bundle.Layout, int, error) {
+	file, reader, err := openBundle(referencePath)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer file.Close()
+
+	byName := make(map[string]*packItem, len(items))
+	for _, item := range items {
+		byName[strings.ToLower(item.extractedName)] = item
+	}
+
+	ordered := make([]*packItem, 0, len(items))
+	var missing []string
+	for _, entry := range reader.Entries() {
+		item := findPackItem(byName, entry.Name)
+		if item == nil {
+			missing = append(missing, entry.Name)
+			continue
+		}
+
+		// Only entries handled by the converter were converted on extraction
+		item.entryName = entry.Name
+		if item.converter != nil && item.converter.StoredExtension() != lowerExt(entry.Name) {
+			item.converter = nil
+		}
+		if size := converterTemplateSize(item.converter); size > 0 {
+			// Images are converted back with the bit depth and row stride of the reference
+			item.template, err = readEntryHeader(reader, entry, size)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		ordered = append(ordered, item)
+		delete(byName, strings.ToLower(item.extractedName))
+	}
+
+	if len(missing) > 0 {
+		return nil, nil, 0, fmt.Errorf("%d entries of %s have no matching file: %s",
+			len(missing), referencePath, strings.Join(missing, ", "))
+	}
+
+	return appendExtraPackItems(ordered, items, byName, referencePath), reader.Layout(), reader.Seed(), nil
+}
+
+// orderPackItemsByManifest restores the entry names, order and conversions recorded on extraction.
+// Files that are not part of the manifest are appended at the end.
+func orderPackItemsByManifest(items []*packItem, manifest *extractManifest) ([]*packItem, error) {
+	byName := make(map[string]*packItem, len(items))
+	for _, item := range items {
+		byName[strings.ToLower(item.extractedName)] = item
+	}
+
+	if len(manifest.Entries) < manifest.EntryCount {
+		fmt.Printf("Warning: the manifest only covers %d of the %d entries of %s\n",
+			len(manifest.Entries), manifest.EntryCount, manifest.Bundle)
+	}
+
+	ordered := make([]*packItem, 0, len(items))
+	var missing []string
+	for _, manifestEntry := range manifest.Entries {
+		key := strings.ToLower(strings.ReplaceAll(manifestEntry.OutputPath, "/", `\`))
+		item, ok := byName[key]
+		if !ok {
+			missing = append(missing, manifestEntry.OutputPath)
+			continue
+		}
+
+		item.entryName = manifestEntry.Name
+		// Entries stored as they are have no converter
+		item.converter = converterByName(manifestEntry.Conversion)
+		// Manifests written before image headers were recorded leave it empty
+		template, err := hex.DecodeString(manifestEntry.ImageHeader)
+		if err != nil {
+			return nil, fmt.Errorf("bad image header for %s in manifest: %w", manifestEntry.OutputPath, err)
+		}
+		item.template = template
+		ordered = append(ordered, item)
+		delete(byName, key)
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%d files listed in the manifest are missing: %s",
+			len(missing), strings.Join(missing, ", "))
+	}
+
+	return appendExtraPackItems(ordered, items, byName, manifestFileName), nil
+}
+
+// appendExtraPackItems appends the items left in byName in path order
+func appendExtraPackItems(ordered, items []*packItem, byName map[string]*packItem, source string) []*packItem {
+	for _, item := range items {
+		if _, ok := byName[strings.ToLower(item.extractedName)]; ok {
+			fmt.Printf("Warning: %s is not part of %s, appending it as %s\n",
+				item.sourcePath, source, item.entryName)
+			ordered = append(ordered, item)
+		}
+	}
+	return ordered
+}
+
+// findPackItem looks up the file extracted from an entry, trying the extensions it may have been converted to first
+func findPackItem(byName map[string]*packItem, entryName string) *packItem {
+	key := strings.ToLower(strings.ReplaceAll(entryName, "/", `\`))
+	storedExtension := lowerExt(key)
+	base := strings.TrimSuffix(key, storedExtension)
+	for _, ext := range convertedExtensions(storedExtension) {
+		if item, ok := byName[base+ext]; ok {
+			return item
+		}
+	}
+	return byName[key]
+}
+
+// readEntryHeader reads the first size bytes of an entry, shorter entries are returned whole
+func readEntryHeader(reader *bundle.Reader, entry *bundle.Entry, size int) ([]byte, error) {
+	entryReader, err := reader.Open(entry)
+	if err != nil {
+		return nil, err
+	}
+	defer entryReader.Close()
+
+	header := make([]byte, size)
+	n, err := io.ReadFull(entryReader, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("error reading %s from bundle: %w", entry.Name, err)
+	}
+	return header[:n], nil
+}
+
+// writePackItems converts every item back to its stored format and writes the bundle
+func writePackItems(outputFile *os.File, items []*packItem, layout *bundle.Layout, seed int) error {
+	writer, err := bundle.NewLayoutWriter(outputFile, len(items), layout, seed)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		content, err := openPackItem(item)
+		if err != nil {
+			return err
+		}
+
+		entry, err := writer.Add(item.entryName, content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("   index: %d, offset: %d, length: %d, name: %s\n",
+			entry.Index, entry.Offset, entry.Length, entry.Name)
+	}
+
+	return writer.Close()
+}
+
+// openPackItem returns the contents of an item in the format stored in the bundle
+func openPackItem(item *packItem) (io.ReadCloser, error) {
+	if item.converter == nil || isRawConverter(item.converter) {
+		// Unconverted files and .unknown files are stored as they are
+		file, err := os.Open(item.sourcePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open %s: %w", item.sourcePath, err)
+		}
+		return file, nil
+	}
+
+	fileData, err := os.ReadFile(item.sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", item.sourcePath, err)
+	}
+	data, err := item.converter.Encode(fileData, item.template)
+	if err != nil {
+		return nil, fmt.Errorf("error converting %s back to %s: %w", item.sourcePath, item.converter.StoredExtension(), err)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
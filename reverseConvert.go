@@ -1,24 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"image"
 	"image/color"
-	"os"
-	"path/filepath"
-	"strings"
+	"image/png"
 )
 
-func detectImageFormat(filePath string, data []byte) (image.Image, error) {
-	ext := strings.ToLower(filepath.Ext(filePath))
-
-	if ext != ".bmp" {
-		return nil, fmt.Errorf("unsupported image format: %s (only BMP is supported)", ext)
+// decodeImage decodes BMP, PNG or TGA data, format is one of the image conversion constants
+func decodeImage(format string, data []byte) (image.Image, error) {
+	switch format {
+	case conversionBmp:
+		// Decode BMP using our custom decoder
+		return decodeBMP(data)
+	case conversionPng:
+		return png.Decode(bytes.NewReader(data))
+	case conversionTga:
+		return decodeTGA(data)
+	default:
+		return nil, fmt.Errorf("unsupported image format: %s (only BMP, PNG and TGA are supported)", format)
 	}
-
-	// Decode BMP using our custom decoder
-	return decodeBMP(data)
 }
 
 // decodeBMP decodes a BMP image file into an image.Image
@@ -53,8 +56,8 @@ func decodeBMP(data []byte) (image.Image, error) {
 		height = -height
 	}
 
-	// Create image
-	img := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	// Create image, BMP alpha is not premultiplied
+	img := image.NewNRGBA(image.Rect(0, 0, int(width), int(height)))
 
 	// Calculate row size (BMP rows are padded to 4-byte boundaries)
 	bytesPerPixel := int(bpp) / 8
@@ -94,73 +97,252 @@ func decodeBMP(data []byte) (image.Image, error) {
 				a = pixelData[pos+3]
 			}
 
-			img.SetRGBA(x, yPos, color.RGBA{r, g, b, a})
+			img.SetNRGBA(x, yPos, color.NRGBA{r, g, b, a})
 		}
 	}
 
 	return img, nil
 }
 
-// convertImageToCnv converts a BMP file back to the proprietary CNV format
-func convertImageToCnv(filePath string) ([]byte, error) {
-	// Read the image file
-	fileData, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("error reading image file: %w", err)
+// decodeTGA decodes an uncompressed or RLE compressed 24-bit or 32-bit true color TGA file
+func decodeTGA(data []byte) (image.Image, error) {
+	if len(data) < 18 {
+		return nil, fmt.Errorf("TGA data too short (need at least 18 bytes)")
 	}
 
-	// Verify this is a BMP file
-	ext := strings.ToLower(filepath.Ext(filePath))
-	if ext != ".bmp" {
-		return nil, fmt.Errorf("only BMP files are supported, got: %s", ext)
+	idLength := int(data[0])
+	colorMapType := data[1]
+	imageType := data[2]
+	colorMapLength := int(binary.LittleEndian.Uint16(data[5:7]))
+	colorMapEntrySize := int(data[7])
+	width := int(binary.LittleEndian.Uint16(data[12:14]))
+	height := int(binary.LittleEndian.Uint16(data[14:16]))
+	bpp := int(data[16])
+	descriptor := data[17]
+
+	fmt.Printf("TGA info: %dx%d, %d bpp, image type: %d\n", width, height, bpp, imageType)
+
+	// Type 2 is uncompressed true color, type 10 the same with RLE packets
+	if imageType != 2 && imageType != 10 {
+		return nil, fmt.Errorf("unsupported TGA image type: %d (only true color images are supported)", imageType)
+	}
+	if bpp != 24 && bpp != 32 {
+		return nil, fmt.Errorf("unsupported TGA bit depth: %d (only 24 and 32 bit supported)", bpp)
 	}
 
-	fmt.Printf("Converting BMP image to CNV format\n")
+	// Skip the image ID and the color map, true color images do not use it
+	pos := 18 + idLength
+	if colorMapType != 0 {
+		pos += colorMapLength * ((colorMapEntrySize + 7) / 8)
+	}
+	if pos > len(data) {
+		return nil, fmt.Errorf("TGA data truncated in header")
+	}
 
-	// Decode the BMP image
-	img, err := detectImageFormat(filePath, fileData)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding BMP image: %w", err)
+	bytesPerPixel := bpp / 8
+	pixelCount := width * height
+	pixelData := data[pos:]
+	if imageType == 10 {
+		var err error
+		pixelData, err = decodeTGARLE(pixelData, pixelCount, bytesPerPixel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(pixelData) < pixelCount*bytesPerPixel {
+		return nil, fmt.Errorf("TGA data truncated (need %d bytes of pixels, got %d)", pixelCount*bytesPerPixel, len(pixelData))
 	}
 
+	// Bit 5 of the descriptor is set for top-down images, bit 4 for right-to-left ones
+	topDown := descriptor&0x20 != 0
+	rightToLeft := descriptor&0x10 != 0
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		yPos := y
+		if !topDown {
+			yPos = height - 1 - y // TGA is normally bottom-up
+		}
+		for x := 0; x < width; x++ {
+			xPos := x
+			if rightToLeft {
+				xPos = width - 1 - x
+			}
+
+			// TGA stores in BGR(A) format
+			p := pixelData[(y*width+x)*bytesPerPixel:]
+			a := uint8(255) // Full opacity for 24-bit
+			if bytesPerPixel == 4 {
+				a = p[3]
+			}
+			img.SetNRGBA(xPos, yPos, color.NRGBA{p[2], p[1], p[0], a})
+		}
+	}
+	return img, nil
+}
+
+// decodeTGARLE expands the RLE packets of a TGA file into pixelCount raw pixels
+func decodeTGARLE(data []byte, pixelCount, bytesPerPixel int) ([]byte, error) {
+	pixels := make([]byte, 0, pixelCount*bytesPerPixel)
+	pos := 0
+	for len(pixels) < pixelCount*bytesPerPixel {
+		if pos >= len(data) {
+			return nil, fmt.Errorf("TGA RLE data truncated at pixel %d", len(pixels)/bytesPerPixel)
+		}
+		packet := data[pos]
+		pos++
+		count := int(packet&0x7f) + 1
+
+		if packet&0x80 != 0 {
+			// Run-length packet, one pixel repeated count times
+			if pos+bytesPerPixel > len(data) {
+				return nil, fmt.Errorf("TGA RLE data truncated at pixel %d", len(pixels)/bytesPerPixel)
+			}
+			for range count {
+				pixels = append(pixels, data[pos:pos+bytesPerPixel]...)
+			}
+			pos += bytesPerPixel
+		} else {
+			// Raw packet, count pixels stored as they are
+			if pos+count*bytesPerPixel > len(data) {
+				return nil, fmt.Errorf("TGA RLE data truncated at pixel %d", len(pixels)/bytesPerPixel)
+			}
+			pixels = append(pixels, data[pos:pos+count*bytesPerPixel]...)
+			pos += count * bytesPerPixel
+		}
+	}
+	// A packet may cross the end of the image
+	return pixels[:pixelCount*bytesPerPixel], nil
+}
+
+// encodeCnvImage builds an image CNV, the 17 byte header followed by BGR or BGRA pixels in top-down order.
+// The bit depth, row stride and reserved header bytes are taken from template when it holds a valid
+// image CNV header, so re-encoding an extracted image gives the original entry back. When template is
+// the whole original CNV, the padding pixels past the width are kept as well. Without template, or
+// when the image size changed, a 32-bit image without padding is written.
+func encodeCnvImage(img image.Image, template []byte) []byte {
 	// Get image dimensions
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
 
 	// Create CNV header (17 bytes)
-	cnvData := make([]byte, 17)
-	cnvData[0] = 32 // BPP - we always use 32-bit
-
-	// Write dimensions
-	binary.LittleEndian.PutUint32(cnvData[1:5], uint32(width))
-	binary.LittleEndian.PutUint32(cnvData[5:9], uint32(height))
-	binary.LittleEndian.PutUint32(cnvData[9:13], uint32(width))
+	header := &cnvImageHeader{bpp: 32, width: uint32(width), height: uint32(height), width2: uint32(width)}
+	headerData := make([]byte, cnvImageHeaderSize)
 	// Last 4 bytes are reserved (already zero-initialized)
-	// Convert pixel data to CNV format (BGRA, top-down order)
-	pixelData := make([]byte, width*height*4)
 
+	var original []byte
+	if len(template) >= cnvImageHeaderSize && (template[0] == 24 || template[0] == 32) {
+		copy(headerData, template[:cnvImageHeaderSize])
+		headerData[cnvImageHeaderSize-1] = 0
+		header.bpp = template[0]
+
+		templateWidth := binary.LittleEndian.Uint32(template[1:5])
+		templateHeight := binary.LittleEndian.Uint32(template[5:9])
+		templateWidth2 := binary.LittleEndian.Uint32(template[9:13])
+		if templateWidth == header.width && templateHeight == header.height && templateWidth2 >= templateWidth {
+			header.width2 = templateWidth2
+			if len(template) == header.dataSize() {
+				original = template
+			}
+		} else {
+			fmt.Printf("Image size changed from %dx%d to %dx%d, the row stride is reset\n",
+				templateWidth, templateHeight, width, height)
+		}
+	}
+
+	headerData[0] = header.bpp
+	binary.LittleEndian.PutUint32(headerData[1:5], header.width)
+	binary.LittleEndian.PutUint32(headerData[5:9], header.height)
+	binary.LittleEndian.PutUint32(headerData[9:13], header.width2)
+
+	// Start from the original pixels so the padding of every row is kept
+	cnvData := make([]byte, header.dataSize())
+	if original != nil {
+		copy(cnvData, original)
+	}
+	copy(cnvData, headerData)
+
+	bytesPerPixel := header.bytesPerPixel()
+	stride := int(header.width2) * bytesPerPixel
+	droppedAlpha := false
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
-			r, g, b, a := img.At(x, y).RGBA()
+			// CNV alpha is straight like PNG alpha, so colors of translucent pixels are kept as they are
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
 
-			// Calculate position in CNV data (top-down, left-to-right)
-			pos := 4 * (y*width + x)
-			if pos+3 >= len(pixelData) {
-				return nil, fmt.Errorf("pixel data buffer overflow at position (%d,%d)", x, y)
+			// CNV format stores pixels as BGR(A) (Blue, Green, Red, Alpha)
+			pos := cnvImageHeaderSize + y*stride + x*bytesPerPixel
+			cnvData[pos] = c.B
+			cnvData[pos+1] = c.G
+			cnvData[pos+2] = c.R
+			if bytesPerPixel == 4 {
+				cnvData[pos+3] = c.A
+			} else if c.A != 255 {
+				droppedAlpha = true
 			}
+		}
+	}
+
+	if droppedAlpha {
+		fmt.Printf("Warning: the original CNV is 24-bit, the transparency of the image is dropped\n")
+	}
+	return cnvData
+}
+
+// wavToCnv is the inverse of convertWav, it builds the 22 byte CNV audio header
+// from the fmt chunk and appends the samples of the data chunk. The last two bytes of the header
+// are not part of the WAV file, they are taken from template, the entry being replaced, or left zero without one.
+func wavToCnv(data, template []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a RIFF WAVE file")
+	}
+
+	var fmtChunk, dataChunk []byte
+	var dataSize uint32
+	for pos := 12; pos+8 <= len(data); {
+		chunkID := string(data[pos : pos+4])
+		chunkSize := binary.LittleEndian.Uint32(data[pos+4 : pos+8])
+		chunkStart := pos + 8
+		chunkEnd := chunkStart + int(chunkSize)
+		if chunkEnd > len(data) || chunkEnd < chunkStart {
+			// Tolerate a truncated final chunk, the data chunk is often written last
+			chunkEnd = len(data)
+		}
 
-			// CNV format stores pixels as BGRA (Blue, Green, Red, Alpha)
-			pixelData[pos] = byte(b >> 8)   // Blue
-			pixelData[pos+1] = byte(g >> 8) // Green
-			pixelData[pos+2] = byte(r >> 8) // Red
-			pixelData[pos+3] = byte(a >> 8) // Alpha
+		switch chunkID {
+		case "fmt ":
+			fmtChunk = data[chunkStart:chunkEnd]
+		case "data":
+			dataChunk = data[chunkStart:chunkEnd]
+			dataSize = chunkSize
 		}
+
+		// Chunks are padded to an even size
+		pos = chunkEnd + int(chunkSize&1)
 	}
 
-	// Combine header and pixel data
-	cnvData = append(cnvData, pixelData...)
+	if len(fmtChunk) < 16 {
+		return nil, fmt.Errorf("missing or short fmt chunk")
+	}
+	// CNV audio only holds plain PCM, extensible WAVs have to be saved as PCM first
+	if formatTag := binary.LittleEndian.Uint16(fmtChunk[0:2]); formatTag != 1 {
+		return nil, fmt.Errorf("unsupported WAV format 0x%04X, only PCM (1) can be converted", formatTag)
+	}
+	if dataChunk == nil {
+		return nil, fmt.Errorf("missing data chunk")
+	}
+
+	// The CNV header is the 16 byte PCM format block followed by the data size
+	cnvData := make([]byte, cnvAudioHeaderSize, cnvAudioHeaderSize+len(dataChunk))
+	copy(cnvData[0:16], fmtChunk[:16])
+	binary.LittleEndian.PutUint32(cnvData[16:20], dataSize)
+	if len(template) >= cnvAudioHeaderSize {
+		copy(cnvData[20:22], template[20:22])
+	}
+	cnvData = append(cnvData, dataChunk...)
 
-	fmt.Printf("Successfully converted image: %dx%d pixels, %d bytes\n", width, height, len(cnvData))
+	fmt.Printf("Successfully converted WAV: %d channels, %d Hz, %d bytes\n",
+		binary.LittleEndian.Uint16(cnvData[2:4]), binary.LittleEndian.Uint32(cnvData[4:8]), len(cnvData))
 	return cnvData, nil
 }
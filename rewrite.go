@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"BundleTools/bundle"
+)
+
+// rewriteItem is an entry of a bundle being rewritten. It is either copied from the
+// source bundle or replaced by new data.
+type rewriteItem struct {
+	name   string
+	source *bundle.Entry // Entry of the source bundle to copy, nil when data is used
+	data   []byte        // Replacement data, already in the format stored in the bundle
+}
+
+// keepEntries returns rewrite items copying every entry of the source bundle unchanged
+func keepEntries(fileEntries []*bundle.Entry) []*rewriteItem {
+	items := make([]*rewriteItem, len(fileEntries))
+	for i, entry := range fileEntries {
+		items[i] = &rewriteItem{name: entry.Name, source: entry}
+	}
+	return items
+}
+
+// rewriteBundle replaces datFilePath with a bundle holding items in order.
+// Offsets are recomputed and every entry is encrypted again for its new offset,
+// so entries can grow, shrink, move, appear or disappear in a single pass.
+// A timestamped backup of the original file is kept.
+func rewriteBundle(datFilePath string, items []*rewriteItem) error {
+	if _, err := backupBundle(datFilePath); err != nil {
+		return err
+	}
+
+	sourceFile, reader, err := openBundle(datFilePath)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	// Write the new version next to the original first
+	patchedFileName := fmt.Sprintf("%s.patched", datFilePath)
+	patchedFile, err := os.Create(patchedFileName)
+	if err != nil {
+		return fmt.Errorf("unable to create patched file %s: %v", patchedFileName, err)
+	}
+
+	err = writeRewriteItems(patchedFile, reader, items)
+	if closeErr := patchedFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(patchedFileName)
+		return err
+	}
+
+	// Close the source to be able to replace it
+	sourceFile.Close()
+	if err := os.Rename(patchedFileName, datFilePath); err != nil {
+		return fmt.Errorf("error replacing original file with patched version: %v", err)
+	}
+	return nil
+}
+
+// writeRewriteItems writes a complete bundle holding items to outputFile, with the layout and table seed of the source bundle
+func writeRewriteItems(outputFile *os.File, reader *bundle.Reader, items []*rewriteItem) error {
+	writer, err := bundle.NewLayoutWriter(outputFile, len(items), reader.Layout(), reader.Seed())
+	if err != nil {
+		return err
+	}
+
+	for i, item := range items {
+		content := io.NopCloser(bytes.NewReader(item.data))
+		if item.source != nil {
+			content, err = reader.Open(item.source)
+			if err != nil {
+				return fmt.Errorf("error reading entry %d: %w", item.source.Index, err)
+			}
+		}
+
+		_, err = writer.Add(item.name, content)
+		content.Close()
+		if err != nil {
+			return fmt.Errorf("error writing entry %d: %w", i, err)
+		}
+	}
+
+	return writer.Close()
+}
+
+// backupBundle copies a bundle to a timestamped backup file and returns its name
+func backupBundle(datFilePath string) (string, error) {
+	// Create a backup filename by adding a timestamp
+	timeStamp := time.Now().Format("20060102-150405")
+	backupFileName := fmt.Sprintf("%s.%s.bak", datFilePath, timeStamp)
+
+	sourceFile, err := os.Open(datFilePath)
+	if err != nil {
+		return "", fmt.Errorf("unable to read source DAT file %s: %v", datFilePath, err)
+	}
+	defer sourceFile.Close()
+
+	backupFile, err := os.Create(backupFileName)
+	if err != nil {
+		return "", fmt.Errorf("unable to create backup file %s: %v", backupFileName, err)
+	}
+	_, err = io.Copy(backupFile, sourceFile)
+	if closeErr := backupFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to write backup file %s: %v", backupFileName, err)
+	}
+
+	fmt.Printf("Created backup of original file: %s\n", backupFileName)
+	return backupFileName, nil
+}
+
+// loadReplacementData reads a file that replaces an entry, converting it to the stored format when a
+// converter handles its extension and the entry, like images and WAV files replacing a .cnv entry.
+// reader is the bundle holding entry, converters like the image one encode with the entry they replace
+// as template. It is nil for new entries.
+func loadReplacementData(inputFilePath string, entry *bundle.Entry, reader *bundle.Reader) ([]byte, error) {
+	fileData, err := os.ReadFile(inputFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading input file %s: %v", inputFilePath, err)
+	}
+
+	converter := replacementConverter(entry.Name, inputFilePath)
+	if converter == nil {
+		// Other files are stored as they are
+		return fileData, nil
+	}
+
+	var template []byte
+	if reader != nil && converterTemplateSize(converter) > 0 {
+		template, err = readEntry(reader, entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Printf("Converting %s back to %s format...\n", filepath.Base(inputFilePath), strings.ToUpper(strings.TrimPrefix(converter.StoredExtension(), ".")))
+	convertedData, err := converter.Encode(fileData, template)
+	if err != nil {
+		return nil, fmt.Errorf("error converting %s back to %s: %w", filepath.Base(inputFilePath), converter.StoredExtension(), err)
+	}
+	fmt.Printf("Successfully converted %s (%d bytes)\n", filepath.Base(inputFilePath), len(convertedData))
+
+	if checker, ok := converter.(replacementChecker); ok && reader != nil {
+		original, err := readEntry(reader, entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, warning := range checker.CheckReplacement(convertedData, original, reader.Layout().NameEncoding) {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+	}
+	return convertedData, nil
+}
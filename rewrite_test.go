@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPatchRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	datFilePath := filepath.Join(dir, "test.dat")
+	names := []string{`bgm\title.ogg`, `bgm\title.sfl`, `data\script.txt`}
+	contents := [][]byte{[]byte("OggS short"), []byte("RIFF sfl"), []byte("script")}
+	createTestBundle(t, datFilePath, names, contents)
+
+	// -single-patch with a larger file moves every following entry
+	inputFilePath := filepath.Join(dir, "title.ogg")
+	contents[0] = bytes.Repeat([]byte("OggS longer"), 100)
+	if err := os.WriteFile(inputFilePath, contents[0], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := patchSingleFile(datFilePath, inputFilePath, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkBundleContents(t, datFilePath, names, contents)
+
+	// -update with a smaller and a larger file
+	sourcePath := filepath.Join(dir, "source")
+	contents[1] = []byte("RIFF")
+	contents[2] = bytes.Repeat([]byte("script "), 50)
+	for i := 1; i < 3; i++ {
+		path := filepath.Join(sourcePath, filepath.FromSlash(strings.ReplaceAll(names[i], `\`, "/")))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, contents[i], 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	patchBundle(datFilePath, sourcePath)
+	checkBundleContents(t, datFilePath, names, contents)
+
+	// Extraction decrypts what the patch paths encrypted
+	outputPath := filepath.Join(dir, "script.txt")
+	if err := extractSingleFile(datFilePath, 2, outputPath, conversionOptions{imageFormat: conversionBmp}); err != nil {
+		t.Fatal(err)
+	}
+	extracted, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(extracted, contents[2]) {
+		t.Errorf("extracted %q, want %q", extracted, contents[2])
+	}
+}
+
+func TestUpdateModelWithoutManifest(t *testing.T) {
+	dir := t.TempDir()
+	datFilePath := filepath.Join(dir, "test.dat")
+	names := []string{`chara\model.x`, `chara\other.x`}
+	contents := [][]byte{[]byte(testModel), []byte(testModel)}
+	createTestBundle(t, datFilePath, names, contents)
+
+	// A .x keeps its extension when extracted, so it must match its entry only once
+	sourcePath := filepath.Join(dir, "source")
+	path := filepath.Join(sourcePath, "chara", "model.x")
+	contents[0] = []byte(strings.ReplaceAll(testModel, "Bone", "Arm"))
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, contents[0], 0644); err != nil {
+		t.Fatal(err)
+	}
+	patchBundle(datFilePath, sourcePath)
+	checkBundleContents(t, datFilePath, names, contents)
+}
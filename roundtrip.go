@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxRoundtripDiffs bounds the differing fields and pixels printed per entry
+const maxRoundtripDiffs = 8
+
+// errNotConverted is returned by roundtripEntry for entries that extraction stores as they are,
+// or that are stored back in their converted form like text models
+var errNotConverted = errors.New("not converted on extraction")
+
+// cnvField is a field of a CNV header, ending before byte end
+type cnvField struct {
+	end  int
+	name string
+}
+
+// Header fields of image and audio CNVs, in order
+var (
+	cnvImageFields = []cnvField{{1, "bpp"}, {5, "width"}, {9, "height"}, {13, "width2"}, {17, "reserved"}}
+	cnvAudioFields = []cnvField{{2, "format"}, {4, "channels"}, {8, "sample rate"}, {12, "byte rate"},
+		{14, "block align"}, {16, "bits per sample"}, {20, "data size"}, {22, "reserved"}}
+)
+
+// roundtripDiff is a run of differing bytes belonging to the same header field or pixel
+type roundtripDiff struct {
+	offset    int    // Offset of the first differing byte in the entry
+	part      string // Header field, pixel or sample data the bytes belong to
+	original  []byte
+	converted []byte
+}
+
+// roundtripBundle converts every entry handled by a converter the way extraction does and back the way
+// patching does, and reports the entries that do not come back byte for byte. Converters are picked with options.
+// It returns the number of entries that differ or could not be converted.
+func roundtripBundle(bundlePath string, options conversionOptions) (int, error) {
+	file, reader, err := openBundle(bundlePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var checked, identical, failed, unconverted int
+	for _, entry := range reader.Entries() {
+		if len(convertedExtensions(lowerExt(entry.Name))) == 0 || entry.Length == 0 {
+			unconverted++
+			continue
+		}
+
+		original, err := readEntry(reader, entry)
+		if err != nil {
+			return failed, err
+		}
+
+		converted, err := roundtripEntry(entry.Name, original, options)
+		if errors.Is(err, errNotConverted) {
+			unconverted++
+			continue
+		}
+		checked++
+		if err != nil {
+			fmt.Printf("   index: %d, name: %s: %v\n", entry.Index, entry.Name, err)
+			failed++
+			continue
+		}
+
+		diffs, count := diffEntry(entry.Name, original, converted)
+		if count == 0 {
+			identical++
+			continue
+		}
+
+		failed++
+		fmt.Printf("   index: %d, name: %s: %d bytes differ\n", entry.Index, entry.Name, count)
+		if len(original) != len(converted) {
+			fmt.Printf("      length: %d, round trip gave %d\n", len(original), len(converted))
+		}
+		for i, diff := range diffs {
+			if i == maxRoundtripDiffs {
+				fmt.Printf("      ... and %d more differences\n", len(diffs)-maxRoundtripDiffs)
+				break
+			}
+			fmt.Printf("      byte %d, %s: % x, round trip gave % x\n", diff.offset, diff.part, diff.original, diff.converted)
+		}
+	}
+
+	fmt.Printf("Checked %d converted entries of %s: %d identical, %d different or failing, %d other entries are stored as they are\n",
+		checked, bundlePath, identical, failed, unconverted)
+	return failed, nil
+}
+
+// roundtripEntry converts an entry as extraction does, then back as patching does
+func roundtripEntry(entryName string, original []byte, options conversionOptions) (converted []byte, err error) {
+	converter := detectConverter(entryName, original[:min(len(original), converterHeaderSize)], options)
+	if converter == nil || isRawConverter(converter) || isLossyConverter(converter) {
+		return nil, errNotConverted
+	}
+
+	// The converters are not trusted with broken data, as on extraction
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("conversion panicked: %v", r)
+		}
+	}()
+
+	decoded, err := converter.Decode(original)
+	if err != nil {
+		return nil, fmt.Errorf("error converting to %s: %w", strings.ToUpper(converter.Name()), err)
+	}
+	// Only the header is used as template, like -pack does, so that the check covers the bytes it writes
+	var template []byte
+	if size := converterTemplateSize(converter); size > 0 {
+		template = original[:min(len(original), size)]
+	}
+	return converter.Encode(decoded, template)
+}
+
+// diffEntry compares an entry with its round trip and returns the differing runs and the number of differing bytes.
+// Bytes past the end of the shorter one are not compared.
+func diffEntry(entryName string, original, converted []byte) ([]*roundtripDiff, int) {
+	var diffs []*roundtripDiff
+	count := abs(len(original) - len(converted))
+
+	var current *roundtripDiff
+	for i := range min(len(original), len(converted)) {
+		if original[i] == converted[i] {
+			current = nil
+			continue
+		}
+		count++
+
+		part := "data"
+		if lowerExt(entryName) == ".cnv" {
+			part = cnvPart(original, i)
+		}
+		if current == nil || current.part != part {
+			current = &roundtripDiff{offset: i, part: part}
+			diffs = append(diffs, current)
+		}
+		current.original = append(current.original, original[i])
+		current.converted = append(current.converted, converted[i])
+	}
+	return diffs, count
+}
+
+// cnvPart names the header field, pixel or sample data holding the byte at offset of a CNV
+func cnvPart(data []byte, offset int) string {
+	fields := cnvAudioFields
+	if data[0] != 1 {
+		fields = cnvImageFields
+	}
+	for _, field := range fields {
+		if offset < field.end {
+			return field.name
+		}
+	}
+
+	if data[0] == 1 {
+		return "sample data"
+	}
+
+	// Pixels are BGR or BGRA, in rows of width2 pixels
+	bytesPerPixel := int(data[0]) / 8
+	width := int(binary.LittleEndian.Uint32(data[1:5]))
+	stride := int(binary.LittleEndian.Uint32(data[9:13])) * bytesPerPixel
+	if stride == 0 {
+		return "pixel data"
+	}
+	pos := offset - cnvImageHeaderSize
+	x, y := pos%stride/bytesPerPixel, pos/stride
+	if x >= width {
+		return fmt.Sprintf("padding of row %d", y)
+	}
+	return fmt.Sprintf("pixel (%d,%d)", x, y)
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDiffCnv(t *testing.T) {
+	// 24-bit 3x2 image with one padding pixel per row
+	original := createTestCnvImage(24, 3, 2, 4)
+	converted := bytes.Clone(original)
+	converted[13]++                          // Reserved header byte
+	converted[cnvImageHeaderSize+12+2*3+1]++ // Green of pixel (2,1)
+	converted[cnvImageHeaderSize+12+3*3]++   // Padding of row 1
+
+	diffs, count := diffEntry(`image\rgb.cnv`, original, converted)
+	if count != 3 {
+		t.Errorf("got %d differing bytes, want 3", count)
+	}
+
+	want := []struct {
+		offset int
+		part   string
+	}{{13, "reserved"}, {36, "pixel (2,1)"}, {38, "padding of row 1"}}
+	if len(diffs) != len(want) {
+		t.Fatalf("got %d differences, want %d", len(diffs), len(want))
+	}
+	for i, diff := range diffs {
+		if diff.offset != want[i].offset || diff.part != want[i].part {
+			t.Errorf("difference %d: got byte %d, %s, want byte %d, %s", i, diff.offset, diff.part, want[i].offset, want[i].part)
+		}
+	}
+}
+
+func TestRoundtripTextModels(t *testing.T) {
+	// Binary models are decoded with -text-models but stored back as text, they cannot come back byte for byte
+	model := []byte("xof 0303bin 0032")
+	options := conversionOptions{imageFormat: conversionBmp, textModels: true}
+	if converter := detectConverter(`chara\body.x`, model, options); converter == nil {
+		t.Fatal("binary model not detected with text models enabled")
+	}
+	if _, err := roundtripEntry(`chara\body.x`, model, options); !errors.Is(err, errNotConverted) {
+		t.Errorf("got %v, want %v", err, errNotConverted)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+const (
+	// sflForm is the form type of the RIFF files stored in .sfl entries next to the .ogg music
+	sflForm = "SFPL"
+	// riffLoopID is the chunk holding the loop start and end sample of the music as two little endian uint32 values
+	riffLoopID = "loop"
+)
+
+// riffFile is the JSON form of a RIFF file, chunks are listed in file order
+type riffFile struct {
+	Form   string       `json:"form"`
+	Chunks []*riffChunk `json:"chunks"`
+}
+
+// riffChunk is the JSON form of a RIFF chunk. LIST chunks hold Form and Chunks, text chunks of
+// INFO lists hold Text, loop chunks hold LoopStart and LoopEnd. The other chunks hold their data as
+// little endian Uint32 values when their size is a multiple of 4, as Hex otherwise.
+type riffChunk struct {
+	ID        string       `json:"id"`
+	Form      string       `json:"form,omitempty"`
+	Chunks    []*riffChunk `json:"chunks,omitempty"`
+	Text      *string      `json:"text,omitempty"`
+	LoopStart *uint32      `json:"loopStart,omitempty"`
+	LoopEnd   *uint32      `json:"loopEnd,omitempty"`
+	Uint32    []uint32     `json:"uint32,omitempty"`
+	Hex       *string      `json:"hex,omitempty"`
+}
+
+// errRIFFNotLossless is returned for RIFF files whose JSON form would not give them back byte for byte
+var errRIFFNotLossless = errors.New("RIFF file cannot be converted losslessly")
+
+// decodeRIFF parses a RIFF file of the given form into its JSON form
+func decodeRIFF(data []byte, form string) (*riffFile, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" {
+		return nil, errors.New("not a RIFF file")
+	}
+	if string(data[8:12]) != form {
+		return nil, fmt.Errorf("RIFF form is %q, not %q", data[8:12], form)
+	}
+
+	size := binary.LittleEndian.Uint32(data[4:8])
+	if int64(size)+8 != int64(len(data)) {
+		return nil, fmt.Errorf("%w: RIFF size %d does not match the file size %d", errRIFFNotLossless, size, len(data))
+	}
+
+	chunks, err := decodeRIFFChunks(data[12:], form)
+	if err != nil {
+		return nil, err
+	}
+	return &riffFile{Form: form, Chunks: chunks}, nil
+}
+
+// decodeRIFFChunks parses the chunks of a RIFF file or LIST chunk, listForm is the form of the parent
+func decodeRIFFChunks(data []byte, listForm string) ([]*riffChunk, error) {
+	chunks := []*riffChunk{}
+	for pos := 0; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, fmt.Errorf("%w: %d trailing bytes after the last chunk", errRIFFNotLossless, len(data)-pos)
+		}
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		start := pos + 8
+		if size > len(data)-start {
+			return nil, fmt.Errorf("chunk %q at byte %d is truncated: %d bytes, %d left", id, pos, size, len(data)-start)
+		}
+		chunkData := data[start : start+size]
+
+		chunk := &riffChunk{ID: id}
+		switch {
+		case id == "LIST" && size >= 4:
+			chunk.Form = string(chunkData[0:4])
+			var err error
+			chunk.Chunks, err = decodeRIFFChunks(chunkData[4:], chunk.Form)
+			if err != nil {
+				return nil, err
+			}
+		case listForm == "INFO" && decodeRIFFText(chunkData) != nil:
+			chunk.Text = decodeRIFFText(chunkData)
+		case id == riffLoopID && size == 8:
+			loopStart, loopEnd := binary.LittleEndian.Uint32(chunkData[0:4]), binary.LittleEndian.Uint32(chunkData[4:8])
+			chunk.LoopStart, chunk.LoopEnd = &loopStart, &loopEnd
+		case size > 0 && size%4 == 0:
+			chunk.Uint32 = make([]uint32, size/4)
+			for i := range chunk.Uint32 {
+				chunk.Uint32[i] = binary.LittleEndian.Uint32(chunkData[i*4:])
+			}
+		default:
+			text := hex.EncodeToString(chunkData)
+			chunk.Hex = &text
+		}
+		chunks = append(chunks, chunk)
+
+		// Chunks are padded to an even size
+		pos = start + size + size&1
+	}
+	return chunks, nil
+}
+
+// decodeRIFFText returns the Shift-JIS text of a zero terminated string chunk, nil when it is not printable text
+func decodeRIFFText(data []byte) *string {
+	text, padding, found := bytes.Cut(data, []byte{0})
+	if !found || len(padding) != 0 {
+		return nil
+	}
+
+	// Invalid bytes are decoded to U+FFFD, and a few characters have several encodings
+	decoded, err := japanese.ShiftJIS.NewDecoder().Bytes(text)
+	if err != nil {
+		return nil
+	}
+	for _, r := range string(decoded) {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			return nil
+		}
+	}
+	if encoded, err := japanese.ShiftJIS.NewEncoder().Bytes(decoded); err != nil || !bytes.Equal(encoded, text) {
+		return nil
+	}
+	result := string(decoded)
+	return &result
+}
+
+// encodeRIFF builds a RIFF file from its JSON form
+func encodeRIFF(file *riffFile) ([]byte, error) {
+	if len(file.Form) != 4 {
+		return nil, fmt.Errorf("RIFF form %q must be 4 characters long", file.Form)
+	}
+
+	body, err := encodeRIFFChunks(file.Chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 12, 12+len(body))
+	copy(data[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(data[4:8], uint32(4+len(body)))
+	copy(data[8:12], file.Form)
+	return append(data, body...), nil
+}
+
+// encodeRIFFChunks builds the chunks of a RIFF file or LIST chunk
+func encodeRIFFChunks(chunks []*riffChunk) ([]byte, error) {
+	var data []byte
+	for _, chunk := range chunks {
+		if len(chunk.ID) != 4 {
+			return nil, fmt.Errorf("chunk id %q must be 4 characters long", chunk.ID)
+		}
+
+		var chunkData []byte
+		switch {
+		case chunk.Form != "":
+			if len(chunk.Form) != 4 {
+				return nil, fmt.Errorf("%s form %q must be 4 characters long", chunk.ID, chunk.Form)
+			}
+			subchunks, err := encodeRIFFChunks(chunk.Chunks)
+			if err != nil {
+				return nil, err
+			}
+			chunkData = append([]byte(chunk.Form), subchunks...)
+		case chunk.Text != nil:
+			text, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte(*chunk.Text))
+			if err != nil {
+				return nil, fmt.Errorf("text of chunk %s cannot be encoded to Shift-JIS: %w", chunk.ID, err)
+			}
+			chunkData = append(text, 0)
+		case chunk.LoopStart != nil || chunk.LoopEnd != nil:
+			if chunk.LoopStart == nil || chunk.LoopEnd == nil {
+				return nil, fmt.Errorf("chunk %s needs both loopStart and loopEnd", chunk.ID)
+			}
+			chunkData = binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, *chunk.LoopStart), *chunk.LoopEnd)
+		case chunk.Uint32 != nil:
+			chunkData = make([]byte, 4*len(chunk.Uint32))
+			for i, value := range chunk.Uint32 {
+				binary.LittleEndian.PutUint32(chunkData[i*4:], value)
+			}
+		case chunk.Hex != nil:
+			var err error
+			chunkData, err = hex.DecodeString(*chunk.Hex)
+			if err != nil {
+				return nil, fmt.Errorf("bad hex data in chunk %s: %w", chunk.ID, err)
+			}
+		}
+
+		var header [8]byte
+		copy(header[0:4], chunk.ID)
+		binary.LittleEndian.PutUint32(header[4:8], uint32(len(chunkData)))
+		data = append(data, header[:]...)
+		data = append(data, chunkData...)
+		if len(chunkData)&1 != 0 {
+			data = append(data, 0)
+		}
+	}
+	return data, nil
+}
+
+// sflConverter converts .sfl entries to an editable JSON file and back
+type sflConverter struct{}
+
+func (sflConverter) Name() string            { return conversionJSON }
+func (sflConverter) Extension() string       { return ".sfl.json" }
+func (sflConverter) StoredExtension() string { return ".sfl" }
+
+func (sflConverter) Detect(header []byte) bool {
+	return len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == sflForm
+}
+
+func (sflConverter) Decode(data []byte) ([]byte, error) {
+	file, err := decodeRIFF(data, sflForm)
+	if err != nil {
+		return nil, err
+	}
+
+	converted, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding SFL as JSON: %w", err)
+	}
+
+	// Nonzero padding bytes and ids that are not text are lost on the way, check that the JSON gives the entry back
+	if back, err := sflFromJSON(converted); err != nil || !bytes.Equal(back, data) {
+		return nil, errRIFFNotLossless
+	}
+	return append(converted, '\n'), nil
+}
+
+func (sflConverter) Encode(data, template []byte) ([]byte, error) {
+	sflData, err := sflFromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Successfully converted SFL: %d bytes\n", len(sflData))
+	return sflData, nil
+}
+
+// sflFromJSON builds an SFL file from its JSON form
+func sflFromJSON(data []byte) ([]byte, error) {
+	var file riffFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error reading SFL JSON: %w", err)
+	}
+	if file.Form != sflForm {
+		return nil, fmt.Errorf("RIFF form is %q, not %q", file.Form, sflForm)
+	}
+	return encodeRIFF(&file)
+}
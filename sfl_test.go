@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// appendRIFFChunk appends a chunk and its padding byte to data
+func appendRIFFChunk(data []byte, id string, chunkData []byte) []byte {
+	data = append(data, id...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(chunkData)))
+	data = append(data, chunkData...)
+	if len(chunkData)&1 != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// createTestSfl builds an SFL with an INFO list, a chunk of two loop points and an odd sized chunk
+func createTestSfl(t *testing.T) []byte {
+	t.Helper()
+
+	title, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte("タイトル"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := appendRIFFChunk([]byte("INFO"), "INAM", append(title, 0))
+
+	var body []byte
+	body = appendRIFFChunk(body, "LIST", info)
+	body = appendRIFFChunk(body, "loop", binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, 1000), 50000))
+	body = appendRIFFChunk(body, "flag", []byte{1, 2, 3})
+
+	data := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+len(body)))...)
+	data = append(data, sflForm...)
+	return append(data, body...)
+}
+
+func TestSflRoundTrip(t *testing.T) {
+	original := createTestSfl(t)
+	converter := sflConverter{}
+	if !converter.Detect(original[:converterHeaderSize]) {
+		t.Fatal("SFL not detected")
+	}
+
+	converted, err := converter.Decode(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var file riffFile
+	if err := json.Unmarshal(converted, &file); err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(file.Chunks))
+	}
+	if info := file.Chunks[0]; info.Form != "INFO" || len(info.Chunks) != 1 || info.Chunks[0].Text == nil || *info.Chunks[0].Text != "タイトル" {
+		t.Errorf("INFO list not decoded: %s", converted)
+	}
+	if loop := file.Chunks[1]; loop.LoopStart == nil || *loop.LoopStart != 1000 || loop.LoopEnd == nil || *loop.LoopEnd != 50000 || loop.Uint32 != nil {
+		t.Errorf("loop chunk not decoded: %s", converted)
+	}
+	if flag := file.Chunks[2]; flag.Hex == nil || *flag.Hex != "010203" {
+		t.Errorf("unknown chunk not kept as hex: %s", converted)
+	}
+
+	// The unedited JSON gives the original back
+	encoded, err := converter.Encode(converted, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, original) {
+		t.Errorf("got %x, want %x", encoded, original)
+	}
+
+	// Edited loop points are stored in place
+	*file.Chunks[1].LoopEnd = 60000
+	edited, err := json.Marshal(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err = converter.Encode(edited, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Replace(original, binary.LittleEndian.AppendUint32(nil, 50000), binary.LittleEndian.AppendUint32(nil, 60000), 1)
+	if !bytes.Equal(encoded, want) {
+		t.Errorf("got %x, want %x", encoded, want)
+	}
+
+	// A loop needs both of its ends
+	file.Chunks[1].LoopEnd = nil
+	if edited, err = json.Marshal(&file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := converter.Encode(edited, nil); err == nil {
+		t.Error("loop chunk without loopEnd encoded")
+	}
+
+	// Files the JSON form cannot hold exactly are refused, extraction keeps them as they are
+	padded := bytes.Clone(original)
+	padded[len(padded)-1] = 0xff
+	if _, err := converter.Decode(padded); !errors.Is(err, errRIFFNotLossless) {
+		t.Errorf("got %v for a nonzero padding byte, want %v", err, errRIFFNotLossless)
+	}
+}
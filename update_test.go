@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"BundleTools/bundle"
+)
+
+func TestEntryResolver(t *testing.T) {
+	var fileEntries []*bundle.Entry
+	for i, name := range []string{`bgm\bgm_a.ogg`, `bgm\a.ogg`, `image\face.cnv`, `se\face.cnv`, `data\x.txt`, `DATA\X.TXT`} {
+		fileEntries = append(fileEntries, &bundle.Entry{Index: i, Name: name})
+	}
+	resolver := newEntryResolver(fileEntries, nil)
+
+	for relPath, want := range map[string]int{
+		"BGM/A.OGG":      1,
+		"bgm/bgm_a.ogg":  0,
+		"image/face.bmp": 2,
+		"se/FACE.wav":    3,
+	} {
+		entry, err := resolver.resolve(filepath.FromSlash(relPath))
+		if err != nil || entry.Index != want {
+			t.Errorf("%s: got %+v, %v, want entry %d", relPath, entry, err, want)
+		}
+	}
+
+	// Names are compared whole, with their folder, and never guessed
+	for relPath, want := range map[string]error{
+		"a.ogg":      errNoMatchingEntry,
+		"bgm/_a.ogg": errNoMatchingEntry,
+		"face.bmp":   errNoMatchingEntry,
+		"data/x.txt": errAmbiguousEntry,
+	} {
+		if entry, err := resolver.resolve(filepath.FromSlash(relPath)); !errors.Is(err, want) {
+			t.Errorf("%s: got %+v, %v, want %v", relPath, entry, err, want)
+		}
+	}
+
+	// A .bmp and a .cnv for the same entry are both left out
+	sourcePath := t.TempDir()
+	for _, relPath := range []string{"image/face.bmp", "image/face.cnv", "bgm/a.ogg"} {
+		path := filepath.Join(sourcePath, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	updateFiles, err := collectUpdateFiles(sourcePath, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updateFiles) != 1 || updateFiles[0].entry.Index != 1 {
+		t.Errorf("got %d update files, want only bgm/a.ogg", len(updateFiles))
+	}
+}
+
+func TestEntryResolverManifest(t *testing.T) {
+	var fileEntries []*bundle.Entry
+	for i, name := range []string{`bgm\a.ogg`, `image\face.cnv`} {
+		fileEntries = append(fileEntries, &bundle.Entry{Index: i, Name: name})
+	}
+	resolver := newEntryResolver(fileEntries, &extractManifest{Entries: []*manifestEntry{
+		{Index: 1, Name: `image\face.cnv`, OutputPath: "renamed/face.png"},
+		{Index: -1, Name: `bgm\a.ogg`, OutputPath: "bgm/negative.ogg"},
+		{Index: 2, Name: `bgm\a.ogg`, OutputPath: "bgm/past.ogg"},
+		{Index: 0, Name: `bgm\b.ogg`, OutputPath: "bgm/b.ogg"},
+	}})
+
+	if entry, err := resolver.resolve(filepath.FromSlash("renamed/face.png")); err != nil || entry.Index != 1 {
+		t.Errorf("renamed/face.png: got %+v, %v, want entry 1", entry, err)
+	}
+	// Edited manifests may hold indices and names that are not in the bundle
+	for _, relPath := range []string{"bgm/negative.ogg", "bgm/past.ogg", "bgm/b.ogg"} {
+		if entry, err := resolver.resolve(filepath.FromSlash(relPath)); !errors.Is(err, errNoMatchingEntry) {
+			t.Errorf("%s: got %+v, %v, want %v", relPath, entry, err, errNoMatchingEntry)
+		}
+	}
+}
+
+// checkBundleContents decrypts every entry of a bundle and compares it with contents
+func checkBundleContents(t *testing.T, datFilePath string, names []string, contents [][]byte) {
+	t.Helper()
+
+	file, reader, err := openBundle(datFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	entries := reader.Entries()
+	if len(entries) != len(names) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(names))
+	}
+	for i, entry := range entries {
+		if entry.Name != names[i] {
+			t.Errorf("entry %d: got name %q, want %q", i, entry.Name, names[i])
+		}
+		data, err := readEntry(reader, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, contents[i]) {
+			t.Errorf("entry %d: got %q, want %q", i, data, contents[i])
+		}
+	}
+}
+
+func TestUpdateGrowsEntries(t *testing.T) {
+	dir := t.TempDir()
+	datFilePath := filepath.Join(dir, "test.dat")
+	names := []string{`bgm\title.ogg`, `data\script.txt`, `se\click.ogg`}
+	contents := [][]byte{[]byte("OggS"), []byte("script"), []byte("OggS click")}
+	createTestBundle(t, datFilePath, names, contents)
+
+	// Two replacements larger than the entries they replace
+	sourcePath := filepath.Join(dir, "source")
+	contents[0] = bytes.Repeat([]byte("OggS longer"), 100)
+	contents[1] = bytes.Repeat([]byte("script "), 100)
+	for i := range 2 {
+		path := filepath.Join(sourcePath, filepath.FromSlash(strings.ReplaceAll(names[i], `\`, "/")))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, contents[i], 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	patchBundle(datFilePath, sourcePath)
+	checkBundleContents(t, datFilePath, names, contents)
+
+	// Every following entry moved and the bundle was written once
+	file, reader, err := openBundle(datFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	entries := reader.Entries()
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Offset != entries[i-1].Offset+entries[i-1].Length {
+			t.Errorf("entry %d starts at %d, not right after entry %d", i, entries[i].Offset, i-1)
+		}
+	}
+	backups, err := filepath.Glob(datFilePath + ".*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Errorf("got %d backups, want 1", len(backups))
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"BundleTools/bundle"
+)
+
+// Severities of verification issues, only errors make -verify fail
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// verifyReport lists the problems found in a bundle by verifyBundle
+type verifyReport struct {
+	Bundle     string         `json:"bundle"`     // Path of the verified bundle
+	Size       int64          `json:"size"`       // Size of the bundle in bytes
+	EntryCount int            `json:"entryCount"` // Entry count stored in the bundle header
+	Layout     string         `json:"layout"`     // Name of the layout the bundle was read with
+	Seed       int            `json:"seed"`       // Seed the file table was decrypted with
+	Errors     int            `json:"errors"`     // Number of issues with the error severity
+	Warnings   int            `json:"warnings"`   // Number of issues with the warning severity
+	Issues     []*verifyIssue `json:"issues"`
+}
+
+// verifyIssue is a single problem found in a bundle
+type verifyIssue struct {
+	Severity string `json:"severity"`        // One of the severity constants
+	Index    *int   `json:"index,omitempty"` // Index of the entry, nil for problems of the whole bundle
+	Name     string `json:"name,omitempty"`  // Name of the entry
+	Message  string `json:"message"`
+}
+
+// add records an issue, entry may be nil for problems of the whole bundle
+func (r *verifyReport) add(severity string, entry *bundle.Entry, format string, args ...any) {
+	issue := &verifyIssue{Severity: severity, Message: fmt.Sprintf(format, args...)}
+	if entry != nil {
+		issue.Index = &entry.Index
+		issue.Name = entry.Name
+	}
+
+	if severity == severityError {
+		r.Errors++
+	} else {
+		r.Warnings++
+	}
+	r.Issues = append(r.Issues, issue)
+}
+
+// verifyBundle checks the layout of a bundle and the headers of its CNV entries.
+// Problems are collected in the report, the error is only set when the bundle cannot be read.
+func verifyBundle(bundlePath string) (*verifyReport, error) {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", bundlePath, err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat %s: %w", bundlePath, err)
+	}
+	report := &verifyReport{Bundle: bundlePath, Size: fileInfo.Size(), Issues: []*verifyIssue{}}
+
+	// Without a plausible layout and seed the table is checked as the default layout stores it,
+	// a table that does not fit the bundle is reported below
+	layout, seed, err := detectLayout(file, report.Size)
+	if err != nil {
+		if errors.Is(err, bundle.ErrNoSeed) {
+			report.add(severityError, nil, "%v, reading the table with the %s layout and seed 0", err, defaultLayout().Name)
+		}
+		layout, seed = defaultLayout(), 0
+	}
+	report.Layout = layout.Name
+	report.Seed = seed
+
+	// The entry count must leave room for the whole table
+	if report.Size < layout.TableOffset() {
+		report.add(severityError, nil, "bundle is %d bytes long, too short to hold the entry count", report.Size)
+		return report, nil
+	}
+	report.EntryCount, err = layout.ReadCount(file)
+	if err != nil {
+		return nil, err
+	}
+
+	tableEnd := layout.TableEnd(report.EntryCount)
+	if tableEnd > report.Size {
+		report.add(severityError, nil, "entry count %d needs a %d bytes table, the bundle is only %d bytes long",
+			report.EntryCount, tableEnd, report.Size)
+		return report, nil
+	}
+
+	table := make([]byte, tableEnd-layout.TableOffset())
+	if _, err := file.ReadAt(table, layout.TableOffset()); err != nil {
+		return nil, fmt.Errorf("error reading table: %w", err)
+	}
+	table = layout.TableCipher(seed, table)
+
+	fileEntries := make([]*bundle.Entry, 0, report.EntryCount)
+	for i := range report.EntryCount {
+		nameField, length, offset := layout.SplitEntry(table[i*layout.EntrySize : (i+1)*layout.EntrySize])
+		entry := &bundle.Entry{
+			Index:  i,
+			Offset: offset,
+			Length: length,
+		}
+		verifyEntryName(report, layout, entry, nameField)
+		fileEntries = append(fileEntries, entry)
+	}
+
+	inBounds := verifyEntryBounds(report, fileEntries, tableEnd)
+	verifyLayout(report, inBounds, tableEnd)
+
+	for _, entry := range inBounds {
+		if lowerExt(entry.Name) == ".cnv" {
+			if err := verifyCnvHeader(report, layout, file, entry); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// verifyEntryName decodes the name field of an entry into entry.Name and checks that it decodes cleanly
+func verifyEntryName(report *verifyReport, layout *bundle.Layout, entry *bundle.Entry, field []byte) {
+	name, padding, _ := bytes.Cut(field, []byte{0})
+	decoded, err := layout.DecodeName(name)
+	if err != nil {
+		decoded = string(name)
+	}
+	entry.Name = decoded
+
+	switch {
+	case len(name) == 0:
+		report.add(severityError, entry, "entry name is empty")
+	case err != nil || strings.ContainsRune(decoded, utf8.RuneError):
+		report.add(severityError, entry, "entry name %q is not valid in the %s layout encoding", name, layout.Name)
+	default:
+		// The name must be written back unchanged when the bundle is rebuilt
+		encoded, err := layout.EncodeName(decoded)
+		if err != nil || !bytes.Equal(encoded, name) {
+			report.add(severityError, entry, "entry name %q does not encode back to the same bytes", name)
+		}
+	}
+
+	if len(bytes.Trim(padding, "\x00")) != 0 {
+		report.add(severityWarning, entry, "name field holds non-zero bytes after the end of the name")
+	}
+}
+
+// verifyEntryBounds checks that every entry lies in the data area and returns the entries that do
+func verifyEntryBounds(report *verifyReport, fileEntries []*bundle.Entry, tableEnd int64) []*bundle.Entry {
+	inBounds := make([]*bundle.Entry, 0, len(fileEntries))
+	for _, entry := range fileEntries {
+		end := int64(entry.Offset) + int64(entry.Length)
+		switch {
+		case end > report.Size:
+			report.add(severityError, entry, "data ends at %d, past the end of the bundle (%d bytes)", end, report.Size)
+		case entry.Length > 0 && int64(entry.Offset) < tableEnd:
+			report.add(severityError, entry, "data at offset %d overlaps the file table, which ends at %d", entry.Offset, tableEnd)
+		default:
+			inBounds = append(inBounds, entry)
+		}
+	}
+	return inBounds
+}
+
+// verifyLayout looks for overlapping entries, gaps between entries and trailing bytes
+func verifyLayout(report *verifyReport, fileEntries []*bundle.Entry, tableEnd int64) {
+	// Walk the entries in data order, empty entries take no room
+	sorted := slices.Clone(fileEntries)
+	sorted = slices.DeleteFunc(sorted, func(entry *bundle.Entry) bool { return entry.Length == 0 })
+	slices.SortStableFunc(sorted, func(a, b *bundle.Entry) int { return cmp.Compare(a.Offset, b.Offset) })
+
+	position := tableEnd
+	var previous *bundle.Entry
+	for _, entry := range sorted {
+		offset := int64(entry.Offset)
+		switch {
+		case offset < position && previous != nil:
+			report.add(severityError, entry, "data at offset %d overlaps entry %d (%s), which ends at %d",
+				offset, previous.Index, previous.Name, position)
+		case offset > position:
+			report.add(severityWarning, entry, "%d unused bytes before the data at offset %d", offset-position, offset)
+		}
+
+		end := offset + int64(entry.Length)
+		if end > position {
+			position = end
+			previous = entry
+		}
+	}
+
+	if position < report.Size {
+		report.add(severityWarning, nil, "%d trailing bytes after the last entry, which ends at %d", report.Size-position, position)
+	}
+}
+
+// verifyCnvHeader checks the header of a CNV entry with the rules used to convert it on extraction
+func verifyCnvHeader(report *verifyReport, layout *bundle.Layout, r io.ReaderAt, entry *bundle.Entry) error {
+	header := make([]byte, min(int(entry.Length), max(cnvImageHeaderSize, cnvAudioHeaderSize)))
+	if _, err := r.ReadAt(header, int64(entry.Offset)); err != nil {
+		return fmt.Errorf("error reading %s from bundle: %w", entry.Name, err)
+	}
+	layout.NewCipher(int64(entry.Offset)).XORKeyStream(header, header)
+
+	if len(header) == 0 {
+		report.add(severityWarning, entry, "empty CNV entry")
+		return nil
+	}
+
+	switch dataKey := header[0]; dataKey {
+	case 1:
+		audioHeader, err := parseCnvAudioHeader(header)
+		if err != nil {
+			report.add(severityError, entry, "bad CNV audio header: %v", err)
+			return nil
+		}
+		if int64(audioHeader.dataSize) != int64(entry.Length)-cnvAudioHeaderSize {
+			report.add(severityWarning, entry, "CNV audio data size mismatch: %d vs %d",
+				audioHeader.dataSize, int64(entry.Length)-cnvAudioHeaderSize)
+		}
+	case 24, 32:
+		imageHeader, err := parseCnvImageHeader(header, int(entry.Length))
+		if err != nil {
+			report.add(severityError, entry, "bad CNV image header: %v", err)
+			return nil
+		}
+		if imageHeader.width > imageHeader.width2 {
+			report.add(severityError, entry, "CNV image width %d is larger than the row stride %d", imageHeader.width, imageHeader.width2)
+		} else if imageHeader.width != imageHeader.width2 {
+			report.add(severityWarning, entry, "CNV image widths disagree: %d %d", imageHeader.width, imageHeader.width2)
+		}
+	default:
+		report.add(severityWarning, entry, "unknown CNV data key %d", dataKey)
+	}
+	return nil
+}
+
+// printVerifyReport prints a verification report for humans
+func printVerifyReport(report *verifyReport) {
+	fmt.Printf("Verified %s: %d bytes, %d entries, %s layout with table seed %d\n",
+		report.Bundle, report.Size, report.EntryCount, report.Layout, report.Seed)
+	for _, issue := range report.Issues {
+		if issue.Index != nil {
+			fmt.Printf("   %s: index %d (%s): %s\n", issue.Severity, *issue.Index, issue.Name, issue.Message)
+		} else {
+			fmt.Printf("   %s: %s\n", issue.Severity, issue.Message)
+		}
+	}
+	fmt.Printf("%d errors, %d warnings\n", report.Errors, report.Warnings)
+}
+
+// writeVerifyReportJSON writes a verification report as JSON
+func writeVerifyReportJSON(w io.Writer, report *verifyReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
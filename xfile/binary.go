@@ -0,0 +1,139 @@
+package xfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Token identifiers of the binary encoding
+const (
+	binaryName        = 0x01
+	binaryString      = 0x02
+	binaryInteger     = 0x03
+	binaryGUID        = 0x05
+	binaryIntegerList = 0x06
+	binaryFloatList   = 0x07
+)
+
+// binaryPunctuation maps the punctuation tokens of the binary encoding to the tokens of the parser
+var binaryPunctuation = map[uint16]tokenKind{
+	0x0a: tokenOpenBrace, 0x0b: tokenCloseBrace, 0x0e: tokenOpenBracket, 0x0f: tokenCloseBracket,
+	0x12: tokenDot, 0x13: tokenComma, 0x14: tokenSemicolon,
+}
+
+// binaryKeywords maps the keyword tokens of template declarations to their text
+var binaryKeywords = map[uint16]string{
+	0x1f: "template", 0x28: "WORD", 0x29: "DWORD", 0x2a: "FLOAT", 0x2b: "DOUBLE", 0x2c: "CHAR", 0x2d: "UCHAR",
+	0x2e: "SWORD", 0x2f: "SDWORD", 0x30: "VOID", 0x31: "STRING", 0x32: "UNICODE", 0x33: "CSTRING", 0x34: "array",
+}
+
+// binaryTokenizer splits the binary encoding of a .x file into tokens. Integer and float lists
+// are returned one value at a time, like the values of the text encoding.
+type binaryTokenizer struct {
+	data      []byte
+	pos       int
+	floatSize int // 32 or 64
+
+	list      tokenKind // tokenInteger or tokenFloat while a list is being returned
+	listCount int       // Values of the list left
+}
+
+func newBinaryTokenizer(data []byte, floatSize int) *binaryTokenizer {
+	return &binaryTokenizer{data: data, floatSize: floatSize}
+}
+
+func (t *binaryTokenizer) position() string {
+	return fmt.Sprintf("byte %d", HeaderSize+t.pos)
+}
+
+// read returns the next n bytes
+func (t *binaryTokenizer) read(n int) ([]byte, error) {
+	if n < 0 || n > len(t.data)-t.pos {
+		return nil, fmt.Errorf("truncated token at %s", t.position())
+	}
+	data := t.data[t.pos : t.pos+n]
+	t.pos += n
+	return data, nil
+}
+
+func (t *binaryTokenizer) readUint32() (uint32, error) {
+	data, err := t.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+func (t *binaryTokenizer) next() (token, error) {
+	if t.listCount > 0 {
+		t.listCount--
+		if t.list == tokenInteger {
+			value, err := t.readUint32()
+			return token{kind: tokenInteger, number: float64(value)}, err
+		}
+		data, err := t.read(t.floatSize / 8)
+		if err != nil {
+			return token{}, err
+		}
+		if t.floatSize == 64 {
+			return token{kind: tokenFloat, number: math.Float64frombits(binary.LittleEndian.Uint64(data))}, nil
+		}
+		return token{kind: tokenFloat, number: float64(math.Float32frombits(binary.LittleEndian.Uint32(data)))}, nil
+	}
+
+	if t.pos == len(t.data) {
+		return token{kind: tokenEOF}, nil
+	}
+	data, err := t.read(2)
+	if err != nil {
+		return token{}, err
+	}
+	id := binary.LittleEndian.Uint16(data)
+
+	switch id {
+	case binaryName, binaryString:
+		size, err := t.readUint32()
+		if err != nil {
+			return token{}, err
+		}
+		text, err := t.read(int(size))
+		if err != nil {
+			return token{}, err
+		}
+		if id == binaryName {
+			return token{kind: tokenName, text: string(text)}, nil
+		}
+		// The semicolon or comma ending the string follows as its own token
+		return token{kind: tokenString, text: string(text)}, nil
+	case binaryInteger:
+		value, err := t.readUint32()
+		return token{kind: tokenInteger, number: float64(value)}, err
+	case binaryGUID:
+		guid, err := t.read(16)
+		if err != nil {
+			return token{}, err
+		}
+		return token{kind: tokenGUID, text: fmt.Sprintf("%08X-%04X-%04X-%X-%X",
+			binary.LittleEndian.Uint32(guid[0:4]), binary.LittleEndian.Uint16(guid[4:6]),
+			binary.LittleEndian.Uint16(guid[6:8]), guid[8:10], guid[10:16])}, nil
+	case binaryIntegerList, binaryFloatList:
+		count, err := t.readUint32()
+		if err != nil {
+			return token{}, err
+		}
+		t.list, t.listCount = tokenInteger, int(count)
+		if id == binaryFloatList {
+			t.list = tokenFloat
+		}
+		return t.next()
+	}
+
+	if kind, ok := binaryPunctuation[id]; ok {
+		return token{kind: kind}, nil
+	}
+	if keyword, ok := binaryKeywords[id]; ok {
+		return token{kind: tokenName, text: keyword}, nil
+	}
+	return token{}, fmt.Errorf("unknown binary token 0x%02x at %s", id, t.position())
+}
@@ -0,0 +1,216 @@
+package xfile
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// binaryBuilder builds the tokens of a binary .x file
+type binaryBuilder struct {
+	data []byte
+}
+
+func (b *binaryBuilder) tokens(ids ...uint16) {
+	for _, id := range ids {
+		b.data = binary.LittleEndian.AppendUint16(b.data, id)
+	}
+}
+
+func (b *binaryBuilder) name(name string) {
+	b.tokens(binaryName)
+	b.data = binary.LittleEndian.AppendUint32(b.data, uint32(len(name)))
+	b.data = append(b.data, name...)
+}
+
+func (b *binaryBuilder) string(text string) {
+	b.tokens(binaryString)
+	b.data = binary.LittleEndian.AppendUint32(b.data, uint32(len(text)))
+	b.data = append(b.data, text...)
+	b.tokens(0x14)
+}
+
+func (b *binaryBuilder) integers(values ...uint32) {
+	b.tokens(binaryIntegerList)
+	b.data = binary.LittleEndian.AppendUint32(b.data, uint32(len(values)))
+	for _, value := range values {
+		b.data = binary.LittleEndian.AppendUint32(b.data, value)
+	}
+}
+
+func (b *binaryBuilder) floats(values ...float32) {
+	b.tokens(binaryFloatList)
+	b.data = binary.LittleEndian.AppendUint32(b.data, uint32(len(values)))
+	for _, value := range values {
+		b.data = binary.LittleEndian.AppendUint32(b.data, math.Float32bits(value))
+	}
+}
+
+// createBinaryModel builds a binary .x file declaring a template and holding a textured triangle in a frame
+func createBinaryModel() []byte {
+	b := &binaryBuilder{data: []byte("xof 0303bin 0032")}
+
+	// template Weights { <GUID> DWORD n; array FLOAT values[n]; [...] }
+	b.tokens(0x1f)
+	b.name("Weights")
+	b.tokens(0x0a, binaryGUID)
+	b.data = append(b.data, 0x44, 0xab, 0x82, 0x3d, 0xda, 0x62, 0xcf, 0x11, 0xab, 0x39, 0x00, 0x20, 0xaf, 0x71, 0xe4, 0x33)
+	b.tokens(0x29)
+	b.name("n")
+	b.tokens(0x14, 0x34, 0x2a)
+	b.name("values")
+	b.tokens(0x0e)
+	b.name("n")
+	b.tokens(0x0f, 0x14, 0x0e, 0x12, 0x12, 0x12, 0x0f, 0x0b)
+
+	b.name("Frame")
+	b.name("Root")
+	b.tokens(0x0a)
+	b.name("FrameTransformMatrix")
+	b.tokens(0x0a)
+	b.floats(1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0.5, 0, 0, 1)
+	b.tokens(0x0b)
+
+	b.name("Mesh")
+	b.name("Triangle")
+	b.tokens(0x0a)
+	b.integers(3)
+	b.floats(0, 0, 0, 1, 0, 0, 0, 1, 0)
+	b.integers(1, 3, 0, 1, 2)
+	b.name("MeshMaterialList")
+	b.tokens(0x0a)
+	b.integers(1, 1, 0)
+	b.name("Material")
+	b.tokens(0x0a)
+	b.floats(1, 1, 1, 1, 8, 0, 0, 0, 0.25, 0.25, 0.25)
+	b.name("TextureFilename")
+	b.tokens(0x0a)
+	b.string("tex.bmp")
+	b.tokens(0x0b, 0x0b, 0x0b)
+	b.name("Weights")
+	b.tokens(0x0a)
+	b.integers(2)
+	b.floats(0.5, 1.5)
+	b.tokens(0x0b, 0x0b, 0x0b)
+	return b.data
+}
+
+// compressMSZip compresses the data following the header of a .x file in blocks of blockSize bytes
+func compressMSZip(t *testing.T, data []byte, encoding string, blockSize int) []byte {
+	t.Helper()
+	body := data[HeaderSize:]
+	compressed := append([]byte(nil), data[:HeaderSize]...)
+	copy(compressed[8:12], encoding)
+	compressed = binary.LittleEndian.AppendUint32(compressed, uint32(len(data)))
+
+	for start := 0; start < len(body); start += blockSize {
+		block := body[start:min(start+blockSize, len(body))]
+		var buffer bytes.Buffer
+		writer, err := flate.NewWriterDict(&buffer, flate.BestCompression, body[max(start-msZipWindow, 0):start])
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write(block)
+		writer.Close()
+
+		compressed = binary.LittleEndian.AppendUint16(compressed, uint16(len(block)))
+		compressed = binary.LittleEndian.AppendUint16(compressed, uint16(2+buffer.Len()))
+		compressed = append(compressed, "CK"...)
+		compressed = append(compressed, buffer.Bytes()...)
+	}
+	return compressed
+}
+
+func TestParseBinary(t *testing.T) {
+	data := createBinaryModel()
+	file, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := file.Templates[0]
+	if template.Name != "Weights" || template.GUID != "3D82AB44-62DA-11CF-AB39-0020AF71E433" || !template.Open || len(template.Members) != 2 {
+		t.Errorf("got template %+v", template)
+	}
+
+	scene, err := file.Scene()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := scene.Frames[0]
+	if root.Name != "Root" || root.Transform[12] != 0.5 || len(root.Meshes) != 1 {
+		t.Fatalf("got frame %+v", root)
+	}
+	mesh := root.Meshes[0]
+	if len(mesh.Positions) != 3 || mesh.Positions[2] != [3]float32{0, 1, 0} || len(mesh.Faces) != 1 {
+		t.Errorf("got mesh %+v", mesh)
+	}
+	if len(mesh.Materials) != 1 || mesh.Materials[0].TextureFilename != "tex.bmp" || mesh.Materials[0].Power != 8 {
+		t.Errorf("got materials %+v", mesh.Materials)
+	}
+
+	// The compressed encoding gives the same objects, whatever the block size
+	for _, blockSize := range []int{16, 4096} {
+		compressed, err := Parse(compressMSZip(t, data, EncodingCompressedBin, blockSize))
+		if err != nil {
+			t.Fatalf("blocks of %d bytes: %v", blockSize, err)
+		}
+		if !reflect.DeepEqual(compressed.Objects, file.Objects) {
+			t.Errorf("blocks of %d bytes: compressed file gives other objects", blockSize)
+		}
+	}
+
+	textData := []byte(testModel)
+	text, err := Parse(textData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressedText, err := Parse(compressMSZip(t, textData, EncodingCompressedText, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(compressedText.Objects, text.Objects) {
+		t.Errorf("compressed text file gives other objects")
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	file, err := Parse(createBinaryModel())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	if err := file.WriteText(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	text := buffer.String()
+
+	// Values are laid out with the standard templates and the declared ones
+	for _, want := range []string{
+		"xof 0303txt 0032\n",
+		"template Weights {\n <3D82AB44-62DA-11CF-AB39-0020AF71E433>\n DWORD n;\n array FLOAT values[n];\n [...]\n}\n",
+		" FrameTransformMatrix {\n  1.0,0.0,0.0,0.0,0.0,1.0,0.0,0.0,0.0,0.0,1.0,0.0,0.5,0.0,0.0,1.0;;\n }\n",
+		"  3;\n  0.0;0.0;0.0;,\n  1.0;0.0;0.0;,\n  0.0;1.0;0.0;;\n  1;\n  3;0,1,2;;\n",
+		"    1.0;1.0;1.0;1.0;;\n    8.0;\n    0.0;0.0;0.0;;\n    0.25;0.25;0.25;;\n",
+		"\"tex.bmp\";",
+		"   2;\n   0.5,1.5;\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text has no %q:\n%s", want, text)
+		}
+	}
+
+	// The text gives the same objects back
+	back, err := Parse(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back.Objects, file.Objects) || !reflect.DeepEqual(back.Templates, file.Templates) {
+		t.Errorf("text file gives other objects:\n%s", text)
+	}
+}
@@ -0,0 +1,54 @@
+package xfile
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// msZipWindow is the size of the history carried from one compressed block to the next
+const msZipWindow = 32768
+
+// decompressMSZip decompresses the data following the header of tzip and bzip files.
+//
+// It starts with the uint32 size of the decompressed file, header included, followed by blocks made
+// of the uint16 decompressed and compressed sizes of the block and of the compressed data. The
+// compressed data is the "CK" signature and a deflate stream using the previous blocks as dictionary.
+func decompressMSZip(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("compressed .x file is truncated")
+	}
+	size := int(binary.LittleEndian.Uint32(data[0:4]))
+
+	var decompressed []byte
+	for pos := 4; pos < len(data); {
+		if pos+4 > len(data) {
+			return nil, fmt.Errorf("truncated compressed block at byte %d", HeaderSize+pos)
+		}
+		blockSize := int(binary.LittleEndian.Uint16(data[pos:]))
+		compressedSize := int(binary.LittleEndian.Uint16(data[pos+2:]))
+		pos += 4
+		if compressedSize < 2 || compressedSize > len(data)-pos {
+			return nil, fmt.Errorf("compressed block at byte %d is %d bytes, %d left", HeaderSize+pos-4, compressedSize, len(data)-pos)
+		}
+		block := data[pos : pos+compressedSize]
+		if string(block[0:2]) != "CK" {
+			return nil, fmt.Errorf("compressed block at byte %d has no MSZIP signature", HeaderSize+pos-4)
+		}
+
+		dictionary := decompressed[max(len(decompressed)-msZipWindow, 0):]
+		reader := flate.NewReaderDict(bytes.NewReader(block[2:]), dictionary)
+		decompressed = append(decompressed, make([]byte, blockSize)...)
+		if _, err := io.ReadFull(reader, decompressed[len(decompressed)-blockSize:]); err != nil {
+			return nil, fmt.Errorf("error decompressing block at byte %d: %w", HeaderSize+pos-4, err)
+		}
+		pos += compressedSize
+	}
+
+	if HeaderSize+len(decompressed) < size {
+		return nil, fmt.Errorf("compressed .x file is truncated: %d bytes decompressed, %d expected", HeaderSize+len(decompressed), size)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,195 @@
+package xfile
+
+// Objects returns the data objects of the standard templates describing the scene, the reverse of File.Scene.
+// AnimTicksPerSecond comes first unless TicksPerSecond is 0. Materials are written inside the material
+// list of their mesh, skinned meshes get an XSkinMeshHeader before their SkinWeights.
+func (s *Scene) Objects() []*Object {
+	var objects []*Object
+	if s.TicksPerSecond != 0 {
+		objects = append(objects, &Object{Type: "AnimTicksPerSecond", Values: []Value{integerValue(uint32(s.TicksPerSecond))}})
+	}
+	for _, frame := range s.Frames {
+		objects = append(objects, frameObject(frame))
+	}
+	for _, mesh := range s.Meshes {
+		objects = append(objects, meshObject(mesh))
+	}
+	for _, set := range s.AnimationSets {
+		objects = append(objects, animationSetObject(set))
+	}
+	return objects
+}
+
+func integerValue(n uint32) Value {
+	return Value{Kind: Integer, Number: float64(n)}
+}
+
+// objectValues builds the values of an object in order
+type objectValues []Value
+
+func (v *objectValues) uint(n uint32) {
+	*v = append(*v, integerValue(n))
+}
+
+func (v *objectValues) floats(values ...float32) {
+	for _, value := range values {
+		*v = append(*v, Value{Kind: Float, Number: float64(value)})
+	}
+}
+
+func (v *objectValues) string(text string) {
+	*v = append(*v, Value{Kind: String, Text: text})
+}
+
+// faces writes an array of faces, each being a count followed by as many indices
+func (v *objectValues) faces(faces [][]uint32) {
+	v.uint(uint32(len(faces)))
+	for _, face := range faces {
+		v.uint(uint32(len(face)))
+		for _, index := range face {
+			v.uint(index)
+		}
+	}
+}
+
+func frameObject(frame *Frame) *Object {
+	var transform objectValues
+	transform.floats(frame.Transform[:]...)
+	object := &Object{Type: "Frame", Name: frame.Name}
+	object.Children = append(object.Children, &Object{Type: "FrameTransformMatrix", Values: transform})
+	for _, mesh := range frame.Meshes {
+		object.Children = append(object.Children, meshObject(mesh))
+	}
+	for _, child := range frame.Children {
+		object.Children = append(object.Children, frameObject(child))
+	}
+	return object
+}
+
+func meshObject(mesh *Mesh) *Object {
+	var values objectValues
+	values.uint(uint32(len(mesh.Positions)))
+	for _, position := range mesh.Positions {
+		values.floats(position[:]...)
+	}
+	values.faces(mesh.Faces)
+	object := &Object{Type: "Mesh", Name: mesh.Name, Values: values}
+
+	if len(mesh.Normals) > 0 {
+		var normals objectValues
+		normals.uint(uint32(len(mesh.Normals)))
+		for _, normal := range mesh.Normals {
+			normals.floats(normal[:]...)
+		}
+		normals.faces(mesh.NormalFaces)
+		object.Children = append(object.Children, &Object{Type: "MeshNormals", Values: normals})
+	}
+
+	if len(mesh.TexCoords) > 0 {
+		var texCoords objectValues
+		texCoords.uint(uint32(len(mesh.TexCoords)))
+		for _, texCoord := range mesh.TexCoords {
+			texCoords.floats(texCoord[:]...)
+		}
+		object.Children = append(object.Children, &Object{Type: "MeshTextureCoords", Values: texCoords})
+	}
+
+	if len(mesh.Materials) > 0 {
+		var list objectValues
+		list.uint(uint32(len(mesh.Materials)))
+		list.uint(uint32(len(mesh.FaceMaterials)))
+		for _, material := range mesh.FaceMaterials {
+			list.uint(material)
+		}
+		listObject := &Object{Type: "MeshMaterialList", Values: list}
+		for _, material := range mesh.Materials {
+			listObject.Children = append(listObject.Children, materialObject(material))
+		}
+		object.Children = append(object.Children, listObject)
+	}
+
+	if len(mesh.Skins) > 0 {
+		object.Children = append(object.Children, skinMeshHeader(mesh))
+		for _, skin := range mesh.Skins {
+			var weights objectValues
+			weights.string(skin.Frame)
+			weights.uint(uint32(len(skin.Vertices)))
+			for _, vertex := range skin.Vertices {
+				weights.uint(vertex)
+			}
+			weights.floats(skin.Weights...)
+			weights.floats(skin.Offset[:]...)
+			object.Children = append(object.Children, &Object{Type: "SkinWeights", Values: weights})
+		}
+	}
+	return object
+}
+
+// skinMeshHeader returns the XSkinMeshHeader of a skinned mesh, counting the bones weighting every vertex and face
+func skinMeshHeader(mesh *Mesh) *Object {
+	vertexBones := make([][]int, len(mesh.Positions))
+	for bone, skin := range mesh.Skins {
+		for i, vertex := range skin.Vertices {
+			if int(vertex) < len(vertexBones) && skin.Weights[i] != 0 {
+				vertexBones[vertex] = append(vertexBones[vertex], bone)
+			}
+		}
+	}
+
+	maxPerVertex, maxPerFace := 0, 0
+	for _, bones := range vertexBones {
+		maxPerVertex = max(maxPerVertex, len(bones))
+	}
+	for _, face := range mesh.Faces {
+		faceBones := make(map[int]bool)
+		for _, vertex := range face {
+			for _, bone := range vertexBones[vertex] {
+				faceBones[bone] = true
+			}
+		}
+		maxPerFace = max(maxPerFace, len(faceBones))
+	}
+
+	var values objectValues
+	values.uint(uint32(maxPerVertex))
+	values.uint(uint32(maxPerFace))
+	values.uint(uint32(len(mesh.Skins)))
+	return &Object{Type: "XSkinMeshHeader", Values: values}
+}
+
+func materialObject(material *Material) *Object {
+	var values objectValues
+	values.floats(material.FaceColor[:]...)
+	values.floats(material.Power)
+	values.floats(material.Specular[:]...)
+	values.floats(material.Emissive[:]...)
+	object := &Object{Type: "Material", Name: material.Name, Values: values}
+
+	if material.TextureFilename != "" {
+		var filename objectValues
+		filename.string(material.TextureFilename)
+		object.Children = append(object.Children, &Object{Type: "TextureFilename", Values: filename})
+	}
+	return object
+}
+
+func animationSetObject(set *AnimationSet) *Object {
+	object := &Object{Type: "AnimationSet", Name: set.Name}
+	for _, animation := range set.Animations {
+		animationObject := &Object{Type: "Animation", Name: animation.Name}
+		animationObject.Children = append(animationObject.Children, &Object{Reference: animation.Frame})
+		for _, key := range animation.Keys {
+			var values objectValues
+			values.uint(uint32(key.Type))
+			values.uint(uint32(len(key.Times)))
+			for i, time := range key.Times {
+				values.uint(time)
+				values.uint(uint32(len(key.Values[i])))
+				values.floats(key.Values[i]...)
+			}
+			animationObject.Children = append(animationObject.Children, &Object{Type: "AnimationKey", Values: values})
+		}
+		object.Children = append(object.Children, animationObject)
+	}
+	return object
+}
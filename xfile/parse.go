@@ -0,0 +1,371 @@
+package xfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind is the kind of a token, the text and binary encodings give the same tokens
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenString
+	tokenInteger
+	tokenFloat
+	tokenGUID
+	tokenOpenBrace
+	tokenCloseBrace
+	tokenOpenBracket
+	tokenCloseBracket
+	tokenSemicolon
+	tokenComma
+	tokenDot // Binary encoding only, the text encoding reads "..." as a name
+)
+
+// punctuation maps the punctuation characters of the text encoding to their tokens
+var punctuation = map[byte]tokenKind{
+	'{': tokenOpenBrace, '}': tokenCloseBrace, '[': tokenOpenBracket, ']': tokenCloseBracket,
+	';': tokenSemicolon, ',': tokenComma, '.': tokenDot,
+}
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of file"
+	case tokenName:
+		return "name"
+	case tokenString:
+		return "string"
+	case tokenInteger:
+		return "integer"
+	case tokenFloat:
+		return "float"
+	case tokenGUID:
+		return "GUID"
+	}
+	for c, kind := range punctuation {
+		if kind == k {
+			return fmt.Sprintf("'%c'", c)
+		}
+	}
+	return "token"
+}
+
+type token struct {
+	kind   tokenKind
+	text   string  // Names, strings and GUIDs
+	number float64 // Integers and floats
+}
+
+// tokenizer reads the tokens of a .x file after its header
+type tokenizer interface {
+	next() (token, error)
+	// position describes where the last token was read, for errors
+	position() string
+}
+
+// parser builds the templates and objects of a file from its tokens
+type parser struct {
+	tokens tokenizer
+	peeked *token
+}
+
+func newParser(tokens tokenizer) *parser {
+	return &parser{tokens: tokens}
+}
+
+func (p *parser) next() (token, error) {
+	if p.peeked != nil {
+		t := *p.peeked
+		p.peeked = nil
+		return t, nil
+	}
+	return p.tokens.next()
+}
+
+func (p *parser) peek() (token, error) {
+	if p.peeked == nil {
+		t, err := p.tokens.next()
+		if err != nil {
+			return token{}, err
+		}
+		p.peeked = &t
+	}
+	return *p.peeked, nil
+}
+
+// expect reads the next token and fails unless it is of the given kind
+func (p *parser) expect(kind tokenKind) (token, error) {
+	t, err := p.next()
+	if err != nil {
+		return token{}, err
+	}
+	if t.kind != kind {
+		return token{}, p.unexpected(t, kind.String())
+	}
+	return t, nil
+}
+
+func (p *parser) unexpected(t token, want string) error {
+	got := t.kind.String()
+	if t.kind == tokenName {
+		got = fmt.Sprintf("name %q", t.text)
+	}
+	return fmt.Errorf("expected %s, got %s at %s", want, got, p.tokens.position())
+}
+
+// skipSeparators skips the semicolons and commas between values
+func (p *parser) skipSeparators() error {
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if t.kind != tokenSemicolon && t.kind != tokenComma {
+			return nil
+		}
+		p.peeked = nil
+	}
+}
+
+func (p *parser) parseFile(file *File) error {
+	for {
+		if err := p.skipSeparators(); err != nil {
+			return err
+		}
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case t.kind == tokenEOF:
+			return nil
+		case t.kind == tokenName && t.text == "template":
+			template, err := p.parseTemplate()
+			if err != nil {
+				return err
+			}
+			file.Templates = append(file.Templates, template)
+		case t.kind == tokenName:
+			object, err := p.parseObject(t.text)
+			if err != nil {
+				return err
+			}
+			file.Objects = append(file.Objects, object)
+		default:
+			return p.unexpected(t, "template or data object")
+		}
+	}
+}
+
+// parseTemplate reads a template declaration after the template keyword
+func (p *parser) parseTemplate() (*Template, error) {
+	name, err := p.expect(tokenName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenOpenBrace); err != nil {
+		return nil, err
+	}
+	template := &Template{Name: name.text}
+
+	// Declarations need a GUID, but the standard templates of this package go without
+	if t, err := p.peek(); err != nil {
+		return nil, err
+	} else if t.kind == tokenGUID {
+		p.peeked = nil
+		template.GUID = t.text
+	}
+
+	for {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch t.kind {
+		case tokenCloseBrace:
+			return template, nil
+		case tokenOpenBracket:
+			if err := p.parseRestrictions(template); err != nil {
+				return nil, err
+			}
+		case tokenName:
+			member, err := p.parseMember(t.text)
+			if err != nil {
+				return nil, err
+			}
+			template.Members = append(template.Members, member)
+		default:
+			return nil, p.unexpected(t, "template member")
+		}
+	}
+}
+
+// parseMember reads a template member up to its semicolon, first is its first word
+func (p *parser) parseMember(first string) (*Member, error) {
+	isArray := first == "array"
+	typeName := first
+	if isArray {
+		t, err := p.expect(tokenName)
+		if err != nil {
+			return nil, err
+		}
+		typeName = t.text
+	}
+	member := &Member{Type: typeName}
+
+	for {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch t.kind {
+		case tokenSemicolon:
+			if isArray && len(member.Dimensions) == 0 {
+				return nil, fmt.Errorf("array member %s of type %s has no dimension at %s", member.Name, typeName, p.tokens.position())
+			}
+			return member, nil
+		case tokenName:
+			if member.Name != "" {
+				return nil, p.unexpected(t, "';'")
+			}
+			member.Name = t.text
+		case tokenOpenBracket:
+			size, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			switch size.kind {
+			case tokenInteger:
+				member.Dimensions = append(member.Dimensions, fmt.Sprint(int64(size.number)))
+			case tokenName:
+				member.Dimensions = append(member.Dimensions, size.text)
+			default:
+				return nil, p.unexpected(size, "array dimension")
+			}
+			if _, err := p.expect(tokenCloseBracket); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.unexpected(t, "member name")
+		}
+	}
+}
+
+// parseRestrictions reads the restrictions of a template after their opening bracket
+func (p *parser) parseRestrictions(template *Template) error {
+	for {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch t.kind {
+		case tokenCloseBracket:
+			return nil
+		case tokenComma:
+		case tokenDot:
+			template.Open = true
+		case tokenName:
+			if strings.Trim(t.text, ".") == "" {
+				template.Open = true
+				continue
+			}
+			restriction := &Restriction{Name: t.text}
+			if next, err := p.peek(); err != nil {
+				return err
+			} else if next.kind == tokenGUID {
+				p.peeked = nil
+				restriction.GUID = next.text
+			}
+			template.Restrictions = append(template.Restrictions, restriction)
+		default:
+			return p.unexpected(t, "template restriction")
+		}
+	}
+}
+
+// parseObject reads a data object after its template name
+func (p *parser) parseObject(typeName string) (*Object, error) {
+	object := &Object{Type: typeName}
+
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind == tokenName {
+		object.Name = t.text
+		t, err = p.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t.kind != tokenOpenBrace {
+		return nil, p.unexpected(t, "'{'")
+	}
+
+	if t, err := p.peek(); err != nil {
+		return nil, err
+	} else if t.kind == tokenGUID {
+		p.peeked = nil
+		object.GUID = t.text
+	}
+
+	for {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch t.kind {
+		case tokenCloseBrace:
+			return object, nil
+		case tokenSemicolon, tokenComma:
+		case tokenInteger:
+			object.Values = append(object.Values, Value{Kind: Integer, Number: t.number})
+		case tokenFloat:
+			object.Values = append(object.Values, Value{Kind: Float, Number: t.number})
+		case tokenString:
+			object.Values = append(object.Values, Value{Kind: String, Text: t.text})
+		case tokenOpenBrace:
+			reference, err := p.parseReference()
+			if err != nil {
+				return nil, err
+			}
+			object.Children = append(object.Children, reference)
+		case tokenName:
+			child, err := p.parseObject(t.text)
+			if err != nil {
+				return nil, fmt.Errorf("in %s %s: %w", typeName, object.Name, err)
+			}
+			object.Children = append(object.Children, child)
+		default:
+			return nil, p.unexpected(t, "data value or object")
+		}
+	}
+}
+
+// parseReference reads a reference to another object after its opening brace
+func (p *parser) parseReference() (*Object, error) {
+	reference := &Object{}
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind == tokenName {
+		reference.Reference = t.text
+		if t, err = p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if t.kind == tokenGUID {
+		reference.GUID = t.text
+		if t, err = p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if t.kind != tokenCloseBrace || (reference.Reference == "" && reference.GUID == "") {
+		return nil, p.unexpected(t, "object reference")
+	}
+	return reference, nil
+}
@@ -0,0 +1,400 @@
+package xfile
+
+import (
+	"fmt"
+)
+
+// DefaultTicksPerSecond is the animation speed of files without AnimTicksPerSecond, as assumed by D3DX
+const DefaultTicksPerSecond = 4800
+
+// Key types of AnimationKey objects
+const (
+	KeyRotation = 0 // Quaternions stored w, x, y, z
+	KeyScale    = 1
+	KeyPosition = 2
+	KeyMatrix   = 4 // Matrices stored like FrameTransformMatrix
+)
+
+// Matrices are stored row by row and transform row vectors, translation is in elements 12 to 14
+type Matrix [16]float32
+
+// Identity is the identity matrix
+var Identity = Matrix{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+
+// Scene is the model described by the standard templates of a file
+type Scene struct {
+	Frames         []*Frame // Root frames
+	Meshes         []*Mesh  // Meshes outside of any frame
+	AnimationSets  []*AnimationSet
+	TicksPerSecond int // DefaultTicksPerSecond when the file does not set it
+}
+
+// Frame is a node of the frame hierarchy, bones of skinned meshes are frames too
+type Frame struct {
+	Name      string
+	Transform Matrix // Relative to the parent frame
+	Children  []*Frame
+	Meshes    []*Mesh
+}
+
+// Mesh is a polygon mesh. Normals, texture coordinates and skin weights are optional.
+type Mesh struct {
+	Name          string
+	Positions     [][3]float32
+	Faces         [][]uint32 // Indices into Positions, faces are polygons with clockwise winding
+	Normals       [][3]float32
+	NormalFaces   [][]uint32 // Indices into Normals, one face per face of Faces
+	TexCoords     [][2]float32
+	Materials     []*Material
+	FaceMaterials []uint32 // Index into Materials of every face, the last one repeats for the faces past its end
+	Skins         []*SkinWeights
+}
+
+// Material is a material of a mesh
+type Material struct {
+	Name            string
+	FaceColor       [4]float32 // RGBA
+	Power           float32
+	Specular        [3]float32
+	Emissive        [3]float32
+	TextureFilename string // Can be empty
+}
+
+// SkinWeights binds vertices of a mesh to a bone
+type SkinWeights struct {
+	Frame    string   // Name of the bone frame
+	Vertices []uint32 // Indices into the Positions of the mesh
+	Weights  []float32
+	Offset   Matrix // Transforms the mesh to the space of the bone in bind pose
+}
+
+// AnimationSet is an animation clip
+type AnimationSet struct {
+	Name       string
+	Animations []*Animation
+}
+
+// Animation animates one frame
+type Animation struct {
+	Name  string
+	Frame string
+	Keys  []*AnimationKey
+}
+
+// AnimationKey holds the keys of one kind of transformation. Times are in ticks.
+type AnimationKey struct {
+	Type   int // One of the Key constants
+	Times  []uint32
+	Values [][]float32 // 4 values for rotations, 3 for scales and positions, 16 for matrices
+}
+
+// Scene interprets the data objects of the standard templates. Objects of other templates are ignored.
+func (f *File) Scene() (*Scene, error) {
+	scene := &Scene{TicksPerSecond: DefaultTicksPerSecond}
+	for _, object := range f.Objects {
+		var err error
+		switch object.Type {
+		case "Frame":
+			var frame *Frame
+			frame, err = f.frame(object)
+			scene.Frames = append(scene.Frames, frame)
+		case "Mesh":
+			var mesh *Mesh
+			mesh, err = f.mesh(object)
+			scene.Meshes = append(scene.Meshes, mesh)
+		case "AnimationSet":
+			var set *AnimationSet
+			set, err = f.animationSet(object)
+			scene.AnimationSets = append(scene.AnimationSets, set)
+		case "AnimTicksPerSecond":
+			values := newValueReader(object)
+			scene.TicksPerSecond = int(values.uint())
+			err = values.err
+			if err == nil && scene.TicksPerSecond == 0 {
+				err = fmt.Errorf("AnimTicksPerSecond is 0")
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scene, nil
+}
+
+// valueReader reads the values of an object in order, the first missing or mistyped value sets err
+type valueReader struct {
+	object *Object
+	pos    int
+	err    error
+}
+
+func newValueReader(object *Object) *valueReader {
+	return &valueReader{object: object}
+}
+
+func (r *valueReader) value(kind ValueKind) Value {
+	if r.err != nil {
+		return Value{}
+	}
+	if r.pos >= len(r.object.Values) {
+		r.err = fmt.Errorf("%s %s: missing values, %d read", r.object.Type, r.object.Name, r.pos)
+		return Value{}
+	}
+	value := r.object.Values[r.pos]
+	// Integers and floats are not told apart, exporters write whole floats without decimals
+	if kind == String && value.Kind != String {
+		r.err = fmt.Errorf("%s %s: value %d is not a string", r.object.Type, r.object.Name, r.pos)
+		return Value{}
+	}
+	if kind != String && value.Kind == String {
+		r.err = fmt.Errorf("%s %s: value %d is not a number", r.object.Type, r.object.Name, r.pos)
+		return Value{}
+	}
+	r.pos++
+	return value
+}
+
+func (r *valueReader) uint() uint32 {
+	return uint32(r.value(Integer).Number)
+}
+
+// count reads an array size, which cannot exceed the number of values left
+func (r *valueReader) count() int {
+	n := int(r.uint())
+	if r.err == nil && n > len(r.object.Values)-r.pos {
+		r.err = fmt.Errorf("%s %s: array of %d elements with %d values left", r.object.Type, r.object.Name, n, len(r.object.Values)-r.pos)
+		return 0
+	}
+	return n
+}
+
+func (r *valueReader) float() float32 {
+	return float32(r.value(Float).Number)
+}
+
+func (r *valueReader) string() string {
+	return r.value(String).Text
+}
+
+func (r *valueReader) floats(values []float32) {
+	for i := range values {
+		values[i] = r.float()
+	}
+}
+
+func (r *valueReader) matrix() Matrix {
+	var m Matrix
+	r.floats(m[:])
+	return m
+}
+
+// faces reads an array of faces, each being a count followed by as many indices
+func (r *valueReader) faces() [][]uint32 {
+	faces := make([][]uint32, r.count())
+	for i := range faces {
+		faces[i] = make([]uint32, r.count())
+		for j := range faces[i] {
+			faces[i][j] = r.uint()
+		}
+		if r.err != nil {
+			return nil
+		}
+	}
+	return faces
+}
+
+func (f *File) frame(object *Object) (*Frame, error) {
+	frame := &Frame{Name: object.Name, Transform: Identity}
+	for _, child := range object.Children {
+		switch child.Type {
+		case "FrameTransformMatrix":
+			values := newValueReader(child)
+			frame.Transform = values.matrix()
+			if values.err != nil {
+				return nil, values.err
+			}
+		case "Frame":
+			childFrame, err := f.frame(child)
+			if err != nil {
+				return nil, err
+			}
+			frame.Children = append(frame.Children, childFrame)
+		case "Mesh":
+			mesh, err := f.mesh(child)
+			if err != nil {
+				return nil, err
+			}
+			frame.Meshes = append(frame.Meshes, mesh)
+		}
+	}
+	return frame, nil
+}
+
+func (f *File) mesh(object *Object) (*Mesh, error) {
+	values := newValueReader(object)
+	mesh := &Mesh{Name: object.Name, Positions: make([][3]float32, values.count())}
+	for i := range mesh.Positions {
+		values.floats(mesh.Positions[i][:])
+	}
+	mesh.Faces = values.faces()
+	if values.err != nil {
+		return nil, values.err
+	}
+	if err := checkIndices(mesh.Faces, len(mesh.Positions), object); err != nil {
+		return nil, err
+	}
+
+	for _, child := range object.Children {
+		values := newValueReader(child)
+		switch child.Type {
+		case "MeshNormals":
+			mesh.Normals = make([][3]float32, values.count())
+			for i := range mesh.Normals {
+				values.floats(mesh.Normals[i][:])
+			}
+			mesh.NormalFaces = values.faces()
+			if values.err == nil {
+				values.err = checkIndices(mesh.NormalFaces, len(mesh.Normals), child)
+			}
+		case "MeshTextureCoords":
+			mesh.TexCoords = make([][2]float32, values.count())
+			for i := range mesh.TexCoords {
+				values.floats(mesh.TexCoords[i][:])
+			}
+		case "MeshMaterialList":
+			materialCount := int(values.uint())
+			mesh.FaceMaterials = make([]uint32, values.count())
+			for i := range mesh.FaceMaterials {
+				mesh.FaceMaterials[i] = values.uint()
+				if values.err == nil && int(mesh.FaceMaterials[i]) >= materialCount {
+					values.err = fmt.Errorf("mesh %s: face %d uses material %d of %d", mesh.Name, i, mesh.FaceMaterials[i], materialCount)
+				}
+			}
+			for _, materialObject := range child.Children {
+				material, err := f.material(materialObject)
+				if err != nil {
+					return nil, err
+				}
+				mesh.Materials = append(mesh.Materials, material)
+			}
+			if values.err == nil && len(mesh.Materials) != materialCount {
+				values.err = fmt.Errorf("mesh %s: %d materials listed, %d found", mesh.Name, materialCount, len(mesh.Materials))
+			}
+		case "SkinWeights":
+			skin := &SkinWeights{Frame: values.string()}
+			skin.Vertices = make([]uint32, values.count())
+			for i := range skin.Vertices {
+				skin.Vertices[i] = values.uint()
+				if values.err == nil && int(skin.Vertices[i]) >= len(mesh.Positions) {
+					values.err = fmt.Errorf("mesh %s: bone %s weights vertex %d of %d", mesh.Name, skin.Frame, skin.Vertices[i], len(mesh.Positions))
+				}
+			}
+			skin.Weights = make([]float32, len(skin.Vertices))
+			values.floats(skin.Weights)
+			skin.Offset = values.matrix()
+			mesh.Skins = append(mesh.Skins, skin)
+		}
+		if values.err != nil {
+			return nil, values.err
+		}
+	}
+	return mesh, nil
+}
+
+// checkIndices fails when a face of object refers past the end of an array of count elements
+func checkIndices(faces [][]uint32, count int, object *Object) error {
+	for i, face := range faces {
+		for _, index := range face {
+			if int(index) >= count {
+				return fmt.Errorf("%s %s: face %d refers to element %d of %d", object.Type, object.Name, i, index, count)
+			}
+		}
+	}
+	return nil
+}
+
+// material reads a Material object or a reference to one
+func (f *File) material(object *Object) (*Material, error) {
+	if object.Type == "" {
+		referenced := f.Find("Material", object.Reference)
+		if referenced == nil {
+			return nil, fmt.Errorf("material %s not found", object.Reference)
+		}
+		object = referenced
+	}
+	if object.Type != "Material" {
+		return nil, fmt.Errorf("%s %s found in a material list", object.Type, object.Name)
+	}
+
+	values := newValueReader(object)
+	material := &Material{Name: object.Name}
+	values.floats(material.FaceColor[:])
+	material.Power = values.float()
+	values.floats(material.Specular[:])
+	values.floats(material.Emissive[:])
+	for _, child := range object.Children {
+		if child.Type == "TextureFilename" || child.Type == "TextureFileName" {
+			textureValues := newValueReader(child)
+			material.TextureFilename = textureValues.string()
+			if textureValues.err != nil {
+				return nil, textureValues.err
+			}
+		}
+	}
+	return material, values.err
+}
+
+func (f *File) animationSet(object *Object) (*AnimationSet, error) {
+	set := &AnimationSet{Name: object.Name}
+	for _, child := range object.Children {
+		if child.Type != "Animation" {
+			continue
+		}
+
+		animation := &Animation{Name: child.Name}
+		for _, animationChild := range child.Children {
+			switch animationChild.Type {
+			case "":
+				animation.Frame = animationChild.Reference
+			case "Frame":
+				animation.Frame = animationChild.Name
+			case "AnimationKey":
+				key, err := animationKey(animationChild)
+				if err != nil {
+					return nil, fmt.Errorf("animation %s of set %s: %w", animation.Name, set.Name, err)
+				}
+				animation.Keys = append(animation.Keys, key)
+			}
+		}
+		set.Animations = append(set.Animations, animation)
+	}
+	return set, nil
+}
+
+// keySizes are the number of values of every key type
+var keySizes = map[int]int{KeyRotation: 4, KeyScale: 3, KeyPosition: 3, KeyMatrix: 16}
+
+func animationKey(object *Object) (*AnimationKey, error) {
+	values := newValueReader(object)
+	key := &AnimationKey{Type: int(values.uint())}
+	size, ok := keySizes[key.Type]
+	if values.err == nil && !ok {
+		return nil, fmt.Errorf("unknown animation key type %d", key.Type)
+	}
+
+	count := values.count()
+	key.Times = make([]uint32, count)
+	key.Values = make([][]float32, count)
+	for i := range count {
+		key.Times[i] = values.uint()
+		if n := int(values.uint()); values.err == nil && n != size {
+			return nil, fmt.Errorf("key %d of type %d has %d values instead of %d", i, key.Type, n, size)
+		}
+		key.Values[i] = make([]float32, size)
+		values.floats(key.Values[i])
+		if values.err != nil {
+			return nil, values.err
+		}
+	}
+	return key, values.err
+}
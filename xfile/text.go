@@ -0,0 +1,98 @@
+package xfile
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// textTokenizer splits the text encoding of a .x file into tokens
+type textTokenizer struct {
+	data []byte
+	pos  int
+	line int
+}
+
+func newTextTokenizer(data []byte) *textTokenizer {
+	return &textTokenizer{data: data, line: 1}
+}
+
+// isTextDelimiter reports whether c ends a name or a number
+func isTextDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '{', '}', '[', ']', '<', '>', ';', ',', '"':
+		return true
+	}
+	return false
+}
+
+func (t *textTokenizer) position() string {
+	return fmt.Sprintf("line %d", t.line)
+}
+
+func (t *textTokenizer) next() (token, error) {
+	// Skip blanks and the // and # comments
+	for t.pos < len(t.data) {
+		c := t.data[t.pos]
+		switch {
+		case c == '\n':
+			t.line++
+			t.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			t.pos++
+		case c == '#' || (c == '/' && t.pos+1 < len(t.data) && t.data[t.pos+1] == '/'):
+			end := bytes.IndexByte(t.data[t.pos:], '\n')
+			if end < 0 {
+				t.pos = len(t.data)
+			} else {
+				t.pos += end
+			}
+		default:
+			return t.readToken()
+		}
+	}
+	return token{kind: tokenEOF}, nil
+}
+
+func (t *textTokenizer) readToken() (token, error) {
+	c := t.data[t.pos]
+	switch c {
+	case '{', '}', '[', ']', ';', ',':
+		t.pos++
+		return token{kind: punctuation[c]}, nil
+	case '<':
+		end := bytes.IndexByte(t.data[t.pos:], '>')
+		if end < 0 {
+			return token{}, fmt.Errorf("unterminated GUID at %s", t.position())
+		}
+		guid := string(bytes.TrimSpace(t.data[t.pos+1 : t.pos+end]))
+		t.pos += end + 1
+		return token{kind: tokenGUID, text: guid}, nil
+	case '"':
+		end := bytes.IndexByte(t.data[t.pos+1:], '"')
+		if end < 0 {
+			return token{}, fmt.Errorf("unterminated string at %s", t.position())
+		}
+		text := string(t.data[t.pos+1 : t.pos+1+end])
+		t.line += bytes.Count(t.data[t.pos+1:t.pos+1+end], []byte{'\n'})
+		t.pos += end + 2
+		return token{kind: tokenString, text: text}, nil
+	case '>':
+		return token{}, fmt.Errorf("unexpected '>' at %s", t.position())
+	}
+
+	start := t.pos
+	for t.pos < len(t.data) && !isTextDelimiter(t.data[t.pos]) {
+		t.pos++
+	}
+	word := string(t.data[start:t.pos])
+
+	// Numbers are integers unless they have a fractional part or an exponent
+	if number, err := strconv.ParseInt(word, 10, 64); err == nil {
+		return token{kind: tokenInteger, number: float64(number)}, nil
+	}
+	if number, err := strconv.ParseFloat(word, 64); err == nil {
+		return token{kind: tokenFloat, number: number}, nil
+	}
+	return token{kind: tokenName, text: word}, nil
+}
@@ -0,0 +1,315 @@
+package xfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// standardTemplates are the layouts of the templates Direct3D knows, which files use without
+// declaring them. Only the members matter to WriteText, so their GUIDs are left out.
+const standardTemplates = `xof 0303txt 0032
+template Header { WORD major; WORD minor; DWORD flags; }
+template Vector { FLOAT x; FLOAT y; FLOAT z; }
+template Coords2d { FLOAT u; FLOAT v; }
+template Matrix4x4 { array FLOAT matrix[16]; }
+template ColorRGBA { FLOAT red; FLOAT green; FLOAT blue; FLOAT alpha; }
+template ColorRGB { FLOAT red; FLOAT green; FLOAT blue; }
+template IndexedColor { DWORD index; ColorRGBA indexColor; }
+template Boolean { WORD truefalse; }
+template Boolean2d { Boolean u; Boolean v; }
+template MaterialWrap { Boolean u; Boolean v; }
+template TextureFilename { STRING filename; }
+template Material { ColorRGBA faceColor; FLOAT power; ColorRGB specularColor; ColorRGB emissiveColor; [...] }
+template MeshFace { DWORD nFaceVertexIndices; array DWORD faceVertexIndices[nFaceVertexIndices]; }
+template MeshFaceWraps { DWORD nFaceWrapValues; array Boolean2d faceWrapValues[nFaceWrapValues]; }
+template MeshTextureCoords { DWORD nTextureCoords; array Coords2d textureCoords[nTextureCoords]; }
+template MeshMaterialList { DWORD nMaterials; DWORD nFaceIndexes; array DWORD faceIndexes[nFaceIndexes]; [Material] }
+template MeshNormals { DWORD nNormals; array Vector normals[nNormals]; DWORD nFaceNormals; array MeshFace faceNormals[nFaceNormals]; }
+template MeshVertexColors { DWORD nVertexColors; array IndexedColor vertexColors[nVertexColors]; }
+template Mesh { DWORD nVertices; array Vector vertices[nVertices]; DWORD nFaces; array MeshFace faces[nFaces]; [...] }
+template FrameTransformMatrix { Matrix4x4 frameMatrix; }
+template Frame { [...] }
+template FloatKeys { DWORD nValues; array FLOAT values[nValues]; }
+template TimedFloatKeys { DWORD time; FloatKeys tfkeys; }
+template AnimationKey { DWORD keyType; DWORD nKeys; array TimedFloatKeys keys[nKeys]; }
+template AnimationOptions { DWORD openclosed; DWORD positionquality; }
+template Animation { [...] }
+template AnimationSet { [Animation] }
+template AnimTicksPerSecond { DWORD AnimTicksPerSecond; }
+template XSkinMeshHeader { WORD nMaxSkinWeightsPerVertex; WORD nMaxSkinWeightsPerFace; WORD nBones; }
+template VertexDuplicationIndices { DWORD nIndices; DWORD nOriginalVertices; array DWORD indices[nIndices]; }
+template SkinWeights { STRING transformNodeName; DWORD nWeights; array DWORD vertexIndices[nWeights]; array FLOAT weights[nWeights]; Matrix4x4 matrixOffset; }
+template FVFData { DWORD dwFVF; DWORD nDWords; array DWORD data[nDWords]; }
+template VertexElement { DWORD Type; DWORD Method; DWORD Usage; DWORD UsageIndex; }
+template DeclData { DWORD nElements; array VertexElement Elements[nElements]; DWORD nDWords; array DWORD data[nDWords]; }
+template EffectFloats { DWORD nFloats; array FLOAT Floats[nFloats]; }
+template EffectString { STRING Value; }
+template EffectDWord { DWORD Value; }
+template EffectParamFloats { STRING ParamName; DWORD nFloats; array FLOAT Floats[nFloats]; }
+template EffectParamString { STRING ParamName; STRING Value; }
+template EffectParamDWord { STRING ParamName; DWORD Value; }
+template EffectInstance { STRING EffectFilename; [...] }
+`
+
+// primitiveTypes are the member types holding a single value
+var primitiveTypes = map[string]bool{
+	"WORD": true, "DWORD": true, "FLOAT": true, "DOUBLE": true, "CHAR": true, "UCHAR": true, "BYTE": true,
+	"SWORD": true, "SDWORD": true, "STRING": true, "CSTRING": true, "UNICODE": true, "ULONGLONG": true,
+}
+
+// valuesPerLine is the number of array values written on a line
+const valuesPerLine = 16
+
+// StandardTemplates returns the layouts of the templates Direct3D knows, by name
+func StandardTemplates() map[string]*Template {
+	file, err := Parse([]byte(standardTemplates))
+	if err != nil {
+		panic(err)
+	}
+	templates := make(map[string]*Template)
+	for _, template := range file.Templates {
+		templates[template.Name] = template
+	}
+	return templates
+}
+
+// textWriter writes a file in the text encoding
+type textWriter struct {
+	w         *bufio.Writer
+	templates map[string]*Template
+	floatSize int
+}
+
+// WriteText writes the file in the text encoding, with the version and float size of its header.
+// Values are laid out with the templates declared in the file or the standard ones. The values of
+// objects that do not match their template are written one after the other.
+func (f *File) WriteText(w io.Writer) error {
+	writer := &textWriter{w: bufio.NewWriter(w), templates: StandardTemplates(), floatSize: f.Header.FloatSize}
+	for _, template := range f.Templates {
+		writer.templates[template.Name] = template
+	}
+
+	fmt.Fprintf(writer.w, "xof %s%s%04d\n", f.Header.Version, EncodingText, f.Header.FloatSize)
+	for _, template := range f.Templates {
+		writer.w.WriteString("\n")
+		writer.template(template)
+	}
+	for _, object := range f.Objects {
+		writer.w.WriteString("\n")
+		writer.object(object, "")
+	}
+	return writer.w.Flush()
+}
+
+func (tw *textWriter) template(template *Template) {
+	fmt.Fprintf(tw.w, "template %s {\n", template.Name)
+	if template.GUID != "" {
+		fmt.Fprintf(tw.w, " <%s>\n", template.GUID)
+	}
+	for _, member := range template.Members {
+		tw.w.WriteString(" ")
+		if len(member.Dimensions) > 0 {
+			tw.w.WriteString("array ")
+		}
+		tw.w.WriteString(member.Type)
+		if member.Name != "" {
+			tw.w.WriteString(" " + member.Name)
+		}
+		for _, dimension := range member.Dimensions {
+			tw.w.WriteString("[" + dimension + "]")
+		}
+		tw.w.WriteString(";\n")
+	}
+
+	var restrictions []string
+	if template.Open {
+		restrictions = append(restrictions, "...")
+	}
+	for _, restriction := range template.Restrictions {
+		if restriction.GUID != "" {
+			restrictions = append(restrictions, fmt.Sprintf("%s <%s>", restriction.Name, restriction.GUID))
+		} else {
+			restrictions = append(restrictions, restriction.Name)
+		}
+	}
+	if restrictions != nil {
+		fmt.Fprintf(tw.w, " [%s]\n", strings.Join(restrictions, ", "))
+	}
+	tw.w.WriteString("}\n")
+}
+
+func (tw *textWriter) object(object *Object, indent string) {
+	if object.Type == "" {
+		reference := object.Reference
+		if object.GUID != "" {
+			reference = strings.TrimSpace(reference + " <" + object.GUID + ">")
+		}
+		fmt.Fprintf(tw.w, "%s{ %s }\n", indent, reference)
+		return
+	}
+
+	tw.w.WriteString(indent + object.Type)
+	if object.Name != "" {
+		tw.w.WriteString(" " + object.Name)
+	}
+	tw.w.WriteString(" {\n")
+	if object.GUID != "" {
+		fmt.Fprintf(tw.w, "%s <%s>\n", indent, object.GUID)
+	}
+
+	lines := tw.values(object)
+	for _, line := range lines {
+		fmt.Fprintf(tw.w, "%s %s\n", indent, line)
+	}
+	for i, child := range object.Children {
+		if i > 0 || len(lines) > 0 {
+			tw.w.WriteString("\n")
+		}
+		tw.object(child, indent+" ")
+	}
+	fmt.Fprintf(tw.w, "%s}\n", indent)
+}
+
+// lineBuilder collects the lines of the values of an object
+type lineBuilder struct {
+	lines   []string
+	current strings.Builder
+}
+
+func (b *lineBuilder) write(text string) {
+	b.current.WriteString(text)
+}
+
+// newLine starts a new line unless the current one is empty
+func (b *lineBuilder) newLine() {
+	if b.current.Len() > 0 {
+		b.lines = append(b.lines, b.current.String())
+		b.current.Reset()
+	}
+}
+
+func (b *lineBuilder) result() []string {
+	b.newLine()
+	return b.lines
+}
+
+// values lays out the values of an object with its template, each top level member on its own line
+func (tw *textWriter) values(object *Object) []string {
+	if len(object.Values) == 0 {
+		return nil
+	}
+
+	if template := tw.templates[object.Type]; template != nil {
+		var b lineBuilder
+		if pos, ok := tw.members(&b, template, object.Values, 0, true); ok && pos == len(object.Values) {
+			return b.result()
+		}
+	}
+
+	// Without a matching template every value is a member of its own
+	var b lineBuilder
+	for i, value := range object.Values {
+		if i > 0 && i%valuesPerLine == 0 {
+			b.newLine()
+		}
+		b.write(tw.value(value) + ";")
+	}
+	return b.result()
+}
+
+// members writes the members of template from values[pos:] and returns the position of the values left.
+// It fails when the values do not match the template.
+func (tw *textWriter) members(b *lineBuilder, template *Template, values []Value, pos int, topLevel bool) (int, bool) {
+	// Array sizes refer to earlier members of the same template
+	sizes := make(map[string]int)
+
+	for _, member := range template.Members {
+		if topLevel {
+			b.newLine()
+		}
+
+		if len(member.Dimensions) == 0 {
+			if primitiveTypes[member.Type] && pos < len(values) && values[pos].Kind == Integer && member.Name != "" {
+				sizes[member.Name] = int(values[pos].Number)
+			}
+			var ok bool
+			if pos, ok = tw.element(b, member.Type, values, pos); !ok {
+				return pos, false
+			}
+			b.write(";")
+			continue
+		}
+
+		count := 1
+		for _, dimension := range member.Dimensions {
+			size, err := strconv.Atoi(dimension)
+			if err != nil {
+				var found bool
+				if size, found = sizes[dimension]; !found {
+					return pos, false
+				}
+			}
+			count *= size
+		}
+		if count < 0 || count > len(values)-pos {
+			return pos, false
+		}
+
+		primitive := primitiveTypes[member.Type]
+		for i := range count {
+			if i > 0 {
+				b.write(",")
+				// Arrays of templates get a line per element, arrays of values a line per valuesPerLine values
+				if topLevel && (!primitive || i%valuesPerLine == 0) {
+					b.newLine()
+				}
+			}
+			var ok bool
+			if pos, ok = tw.element(b, member.Type, values, pos); !ok {
+				return pos, false
+			}
+		}
+		b.write(";")
+	}
+	return pos, true
+}
+
+// element writes a value of a primitive type, or the members of a template, without its separator
+func (tw *textWriter) element(b *lineBuilder, typeName string, values []Value, pos int) (int, bool) {
+	if primitiveTypes[typeName] {
+		if pos >= len(values) || (values[pos].Kind == String) != (typeName == "STRING" || typeName == "CSTRING" || typeName == "UNICODE") {
+			return pos, false
+		}
+		b.write(tw.value(values[pos]))
+		return pos + 1, true
+	}
+
+	template := tw.templates[typeName]
+	if template == nil {
+		return pos, false
+	}
+	return tw.members(b, template, values, pos, false)
+}
+
+// value formats a value, floats always have a decimal point so they read back as floats
+func (tw *textWriter) value(value Value) string {
+	switch value.Kind {
+	case Integer:
+		return strconv.FormatInt(int64(value.Number), 10)
+	case Float:
+		if value.Number == 0 {
+			// Negative zeros too, as left by mirrored matrices
+			return "0.0"
+		}
+		text := strconv.FormatFloat(value.Number, 'f', -1, tw.floatSize)
+		if !strings.Contains(text, ".") {
+			text += ".0"
+		}
+		return text
+	default:
+		return `"` + value.Text + `"`
+	}
+}
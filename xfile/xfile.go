@@ -0,0 +1,158 @@
+// Package xfile reads DirectX .x files, the model format of Higurashi Daybreak, and writes them as text.
+//
+// A .x file starts with a 16 byte header such as "xof 0303txt 0032": the magic, the major and
+// minor format version, the encoding of the rest of the file (txt, bin, tzip or bzip) and the
+// size of its floats in bits. The rest is a stream of template declarations, describing the
+// layout of data objects, and of the data objects themselves.
+//
+// Data objects are read without looking at their templates: an Object holds the values of its
+// members in file order, followed by its child objects and references. Scene interprets the
+// objects of the standard templates used for models: frames, meshes, materials, skin weights
+// and animations, and Scene.Objects builds them back. WriteText lays the values out again with the templates.
+package xfile
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// HeaderSize is the size of the header starting every .x file
+const HeaderSize = 16
+
+// Encodings of the data following the header
+const (
+	EncodingText           = "txt "
+	EncodingBinary         = "bin "
+	EncodingCompressedText = "tzip"
+	EncodingCompressedBin  = "bzip"
+)
+
+// ErrUnsupportedEncoding is returned for .x files whose data is stored in an unknown encoding
+var ErrUnsupportedEncoding = errors.New("unsupported .x encoding")
+
+// Header is the header of a .x file
+type Header struct {
+	Version   string // Major and minor version, "0302" or "0303"
+	Encoding  string // One of the Encoding constants
+	FloatSize int    // Size of floats in bits, 32 or 64
+}
+
+// File is the content of a .x file
+type File struct {
+	Header    Header
+	Templates []*Template // Template declarations, in file order
+	Objects   []*Object   // Top level data objects, in file order
+}
+
+// Template is a template declaration
+type Template struct {
+	Name         string
+	GUID         string // Without the angle brackets
+	Members      []*Member
+	Open         bool           // Restriction [...], any child object is allowed
+	Restrictions []*Restriction // Templates allowed as children, none when the template is closed
+}
+
+// Member is a member of a template, like "array Vector vertices[nVertices]"
+type Member struct {
+	Type       string   // Primitive type like DWORD or FLOAT, or the name of another template
+	Name       string   // Can be empty
+	Dimensions []string // Sizes of an array member, numbers or names of earlier members
+}
+
+// Restriction is a template allowed as child of a restricted template
+type Restriction struct {
+	Name string
+	GUID string // Can be empty
+}
+
+// ValueKind is the kind of a data value
+type ValueKind int
+
+const (
+	Integer ValueKind = iota
+	Float
+	String
+)
+
+// Value is a member value of a data object. Numbers of both kinds are held in Number, strings in Text.
+type Value struct {
+	Kind   ValueKind
+	Number float64
+	Text   string // Bytes of the string as stored, Daybreak models use Shift JIS
+}
+
+// Object is a data object, or a reference to one
+type Object struct {
+	Type      string // Template of the object, empty for references
+	Name      string // Can be empty
+	GUID      string // Can be empty
+	Values    []Value
+	Children  []*Object
+	Reference string // Name of the referenced object, for references only
+}
+
+// ParseHeader reads the header at the start of data
+func ParseHeader(data []byte) (Header, error) {
+	if len(data) < HeaderSize || string(data[0:4]) != "xof " {
+		return Header{}, errors.New("not a .x file")
+	}
+
+	header := Header{Version: string(data[4:8]), Encoding: string(data[8:12])}
+	floatSize, err := strconv.Atoi(string(data[12:16]))
+	if err != nil || (floatSize != 32 && floatSize != 64) {
+		return Header{}, fmt.Errorf("bad float size %q in .x header", data[12:16])
+	}
+	header.FloatSize = floatSize
+	return header, nil
+}
+
+// Parse reads a .x file
+func Parse(data []byte) (*File, error) {
+	header, err := ParseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	body := data[HeaderSize:]
+	if header.Encoding == EncodingCompressedText || header.Encoding == EncodingCompressedBin {
+		body, err = decompressMSZip(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var tokens tokenizer
+	switch header.Encoding {
+	case EncodingText, EncodingCompressedText:
+		tokens = newTextTokenizer(body)
+	case EncodingBinary, EncodingCompressedBin:
+		tokens = newBinaryTokenizer(body, header.FloatSize)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, header.Encoding)
+	}
+
+	file := &File{Header: header}
+	if err := newParser(tokens).parseFile(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Find returns the first object of the given type and name in the file, searching children too
+func (f *File) Find(typeName, name string) *Object {
+	return findObject(f.Objects, typeName, name)
+}
+
+func findObject(objects []*Object, typeName, name string) *Object {
+	for _, object := range objects {
+		if object.Type == typeName && object.Name == name {
+			return object
+		}
+		if found := findObject(object.Children, typeName, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
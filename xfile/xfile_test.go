@@ -0,0 +1,178 @@
+package xfile
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testModel = `xof 0303txt 0032
+// Templates are kept but not needed to read the objects
+template Vector {
+ <3D82AB5E-62DA-11cf-AB39-0020AF71E433>
+ FLOAT x;
+ FLOAT y;
+ FLOAT z;
+}
+
+template Mesh {
+ <3D82AB44-62DA-11cf-AB39-0020AF71E433>
+ DWORD nVertices;
+ array Vector vertices[nVertices];
+ DWORD nFaces;
+ array MeshFace faces[nFaces];
+ [...]
+}
+
+AnimTicksPerSecond {
+ 30;
+}
+
+Material Skin {
+ 1.000000;1.000000;1.000000;1.000000;;
+ 5.000000;
+ 0.000000;0.000000;0.000000;;
+ 0.000000;0.000000;0.000000;;
+ TextureFilename {
+  "face.bmp";
+ }
+}
+
+Frame Root {
+ FrameTransformMatrix {
+  1.000000,0.000000,0.000000,0.000000,
+  0.000000,1.000000,0.000000,0.000000,
+  0.000000,0.000000,1.000000,0.000000,
+  0.000000,0.000000,2.000000,1.000000;;
+ }
+
+ Frame Bone {
+  FrameTransformMatrix {
+   1,0,0,0,0,1,0,0,0,0,1,0,0,1,0,1;;
+  }
+ }
+
+ Mesh Quad {
+  4;
+  0.0;0.0;0.0;,
+  1.0;0.0;0.0;,
+  1.0;1.0;0.0;,
+  0.0;1.0;0.0;;
+  1;
+  4;0,1,2,3;;
+
+  MeshMaterialList {
+   1;
+   1;
+   0;;
+   { Skin }
+  }
+
+  SkinWeights {
+   "Bone";
+   2;
+   2,
+   3;
+   1.000000,
+   0.500000;
+   1,0,0,0,0,1,0,0,0,0,1,0,0,-1,0,1;;
+  }
+ }
+}
+
+AnimationSet Wave {
+ Animation {
+  { Bone }
+  AnimationKey {
+   0;
+   2;
+   0;4;1.000000,0.000000,0.000000,0.000000;;,
+   30;4;0.707107,0.707107,0.000000,0.000000;;;
+  }
+ }
+}
+`
+
+func TestParseText(t *testing.T) {
+	file, err := Parse([]byte(testModel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Header != (Header{Version: "0303", Encoding: EncodingText, FloatSize: 32}) {
+		t.Errorf("got header %+v", file.Header)
+	}
+
+	if len(file.Templates) != 2 {
+		t.Fatalf("got %d templates, want 2", len(file.Templates))
+	}
+	mesh := file.Templates[1]
+	if mesh.Name != "Mesh" || !mesh.Open || len(mesh.Members) != 4 {
+		t.Errorf("got template %+v", mesh)
+	}
+	if vertices := mesh.Members[1]; vertices.Type != "Vector" || vertices.Name != "vertices" || len(vertices.Dimensions) != 1 || vertices.Dimensions[0] != "nVertices" {
+		t.Errorf("got member %+v", vertices)
+	}
+
+	scene, err := file.Scene()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scene.TicksPerSecond != 30 {
+		t.Errorf("got %d ticks per second, want 30", scene.TicksPerSecond)
+	}
+	if len(scene.Frames) != 1 || len(scene.Frames[0].Children) != 1 || len(scene.Frames[0].Meshes) != 1 {
+		t.Fatalf("got frames %+v", scene.Frames)
+	}
+	if root := scene.Frames[0]; root.Transform[14] != 2 {
+		t.Errorf("got root transform %v", root.Transform)
+	}
+
+	quad := scene.Frames[0].Meshes[0]
+	if len(quad.Positions) != 4 || len(quad.Faces) != 1 || len(quad.Faces[0]) != 4 {
+		t.Errorf("got mesh %+v", quad)
+	}
+	if len(quad.Materials) != 1 || quad.Materials[0].Name != "Skin" || quad.Materials[0].TextureFilename != "face.bmp" {
+		t.Errorf("referenced material not read: %+v", quad.Materials)
+	}
+	if len(quad.Skins) != 1 || quad.Skins[0].Frame != "Bone" || quad.Skins[0].Weights[1] != 0.5 || quad.Skins[0].Offset[13] != -1 {
+		t.Errorf("got skin weights %+v", quad.Skins)
+	}
+
+	if len(scene.AnimationSets) != 1 || len(scene.AnimationSets[0].Animations) != 1 {
+		t.Fatalf("got animation sets %+v", scene.AnimationSets)
+	}
+	animation := scene.AnimationSets[0].Animations[0]
+	if animation.Frame != "Bone" || len(animation.Keys) != 1 {
+		t.Fatalf("got animation %+v", animation)
+	}
+	if key := animation.Keys[0]; key.Type != KeyRotation || len(key.Times) != 2 || key.Times[1] != 30 || key.Values[1][1] != 0.707107 {
+		t.Errorf("got key %+v", key)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"xof 0303abcd0032", "unsupported .x encoding"},
+		{"xof 0303bzip0032", "compressed .x file is truncated"},
+		{"xof 0303txt 0016", "bad float size"},
+		{"xof 0303txt 0032\nFrame Root {\n 1;\n", "expected data value or object, got end of file at line 4"},
+		{"xof 0303txt 0032\nMesh {\n 9;\n 0;0;0;;\n 0;\n}\n", "Mesh : array of 9 elements with 4 values left"},
+		{"xof 0303txt 0032\nMesh {\n 2;\n 0;0;0;;\n 0;\n}\n", "Mesh : missing values"},
+	}
+	for _, test := range tests {
+		file, err := Parse([]byte(test.data))
+		if err == nil {
+			_, err = file.Scene()
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: got error %v, want %q", test.data, err, test.want)
+		}
+	}
+
+	if _, err := Parse([]byte("xof 0303abcd0032")); !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Errorf("got %v, want %v", err, ErrUnsupportedEncoding)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"BundleTools/xfile"
+
+	"golang.org/x/text/encoding"
+)
+
+// decodeModelToText returns a .x file in the text encoding
+func decodeModelToText(data []byte) ([]byte, *xfile.File, error) {
+	model, err := xfile.Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var text bytes.Buffer
+	if err := model.WriteText(&text); err != nil {
+		return nil, nil, err
+	}
+	return text.Bytes(), model, nil
+}
+
+// convertModelToText converts a binary or compressed .x file to a text one
+func convertModelToText(inputPath, outputPath string) error {
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", inputPath, err)
+	}
+
+	text, model, err := decodeModelToText(data)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", inputPath, err)
+	}
+	if err := os.WriteFile(outputPath, text, 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %w", outputPath, err)
+	}
+
+	fmt.Printf("Converted %s from %q encoding to text: %d templates, %d objects, %d bytes\n",
+		inputPath, model.Header.Encoding, len(model.Templates), len(model.Objects), len(text))
+	return nil
+}
+
+// xTextConverter decodes binary and compressed .x models to text when -text-models is given.
+// Direct3D reads text models too, so they are stored back as they are.
+type xTextConverter struct{}
+
+func (xTextConverter) Name() string            { return conversionXText }
+func (xTextConverter) Extension() string       { return ".x" }
+func (xTextConverter) StoredExtension() string { return ".x" }
+
+// Enabled only decodes models with -text-models, they are otherwise extracted and packed as they are
+func (xTextConverter) Enabled(options conversionOptions) bool {
+	return options.textModels
+}
+
+// Lossy is true, decoded models are stored back as text
+func (xTextConverter) Lossy() bool { return true }
+
+func (xTextConverter) Detect(header []byte) bool {
+	xHeader, err := xfile.ParseHeader(header)
+	return err == nil && xHeader.Encoding != xfile.EncodingText
+}
+
+func (xTextConverter) Decode(data []byte) ([]byte, error) {
+	text, _, err := decodeModelToText(data)
+	return text, err
+}
+
+func (xTextConverter) Encode(data, template []byte) ([]byte, error) {
+	// Edited text models are checked before they get into the bundle, others are not touched
+	if header, err := xfile.ParseHeader(data); err == nil && header.Encoding == xfile.EncodingText {
+		if _, err := xfile.Parse(data); err != nil {
+			return nil, fmt.Errorf("error in text model: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// CheckReplacement warns when the bones of a model differ from the ones of the model it replaces
+func (xTextConverter) CheckReplacement(data, original []byte, names encoding.Encoding) []string {
+	scenes := make([]*xfile.Scene, 2)
+	for i, modelData := range [][]byte{data, original} {
+		model, err := xfile.Parse(modelData)
+		if err != nil {
+			return nil
+		}
+		if scenes[i], err = model.Scene(); err != nil {
+			return nil
+		}
+	}
+	return compareModelBones(scenes[1], scenes[0], names)
+}